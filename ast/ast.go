@@ -18,8 +18,64 @@ type Decl interface {
 	Node
 	declNode()
 }
+
+// Comment is a '//' comment, Text includes the slashes.
+type Comment struct {
+	Pos  error.Position
+	Text string
+}
+
+// Comments are the comments a declaration, statement or field carries, the
+// parser attaches them when it is given the comments of the source.
+type Comments struct {
+	Leading  []*Comment // on the lines before the node
+	Trailing *Comment   // after the node on its last line
+	Blank    bool       // an empty line separates the node from the previous one
+}
+
+func (c *Comments) GetComments() *Comments {
+	return c
+}
+
+// Commented is implemented by the nodes that carry comments.
+type Commented interface {
+	GetComments() *Comments
+}
+
+// Module is a parsed source file. Imports maps the path of every DeclImport in
+// Decls to the module loaded for it.
+type Module struct {
+	Name    string
+	Path    string
+	Decls   []Decl
+	Imports map[string]*Module
+}
+
+// BadDecl, BadStmt and BadExpr stand for source the parser skipped after a
+// syntax error, Pos spans it.
+type BadDecl struct {
+	Comments
+	Pos error.Position
+}
+type BadStmt struct {
+	Comments
+	Pos error.Position
+}
+type BadExpr struct {
+	Pos error.Position
+}
+
+type DeclImport struct {
+	Comments
+	Pos   error.Position
+	Path  string
+	Name  string // the qualifier of the module's symbols, the last element of Path unless renamed
+	Alias bool   // Name is given with 'as'
+}
 type DeclFunction struct {
+	Comments
 	Pos        error.Position
+	Pub        bool
 	Name       string
 	Parameters []Field
 	RetType    TypeSpec
@@ -27,17 +83,24 @@ type DeclFunction struct {
 	End        error.Position
 }
 type Field struct {
+	Comments
 	Pos  error.Position
 	Name string
 	Type TypeSpec
 }
 type DeclStruct struct {
+	Comments
 	Pos    error.Position
+	Pub    bool
 	Name   string
 	Fields []*Field
+	End    error.Position // closing brace
+	Footer []*Comment     // after the last field
 }
 type DeclExternalFunction struct {
+	Comments
 	Pos        error.Position
+	Pub        bool
 	Name       string
 	Parameters []Field
 	ReturnType TypeSpec
@@ -48,28 +111,61 @@ type Stmt interface {
 	stmtNode()
 }
 type StmtBlock struct {
-	Pos   error.Position
-	Block []Stmt
-	Scope *scope.Scope
+	Comments
+	Pos    error.Position
+	End    error.Position // closing brace
+	Block  []Stmt
+	Scope  *scope.Scope
+	Footer []*Comment // after the last statement
 }
 type StmtLet struct {
+	Comments
 	Pos  error.Position
 	Name string
 	Type TypeSpec
 	Init Expr
 }
 type StmtIf struct {
+	Comments
 	Pos  error.Position
 	Cond Expr
 	Then *StmtBlock
+	Else Stmt // nil, *StmtBlock or *StmtIf of an 'else if'
+}
+type StmtWhile struct {
+	Comments
+	Pos  error.Position
+	Cond Expr
+	Body *StmtBlock
+}
+
+// StmtFor is 'for init; cond; post { body }', every part of the header may be
+// left out. A missing condition loops until a break or return.
+type StmtFor struct {
+	Comments
+	Pos  error.Position
+	Init Stmt // nil, *StmtLet or *StmtExpr
+	Cond Expr
+	Post Expr
+	Body *StmtBlock
+}
+type StmtBreak struct {
+	Comments
+	Pos error.Position
+}
+type StmtContinue struct {
+	Comments
+	Pos error.Position
 }
 
 type StmtReturn struct {
+	Comments
 	Pos    error.Position
 	Result Expr
 }
 
 type StmtExpr struct {
+	Comments
 	Pos  error.Position
 	Expr Expr
 }
@@ -88,9 +184,11 @@ type ExprAssign struct {
 }
 
 type ExprCall struct {
-	Pos  error.Position
-	Name string
-	Args []Expr
+	Pos     error.Position
+	NamePos error.Position // spans the callee, qualifier included
+	Module  string         // qualifier of 'mem.copy()', empty for a plain call
+	Name    string
+	Args    []Expr
 }
 
 type CompoundField struct {
@@ -133,6 +231,28 @@ type ExprBoolean struct {
 	Value bool
 }
 
+func (e *BadDecl) declNode() {}
+func (e *BadDecl) GetPos() error.Position {
+	return e.Pos
+}
+func (s *BadStmt) stmtNode() {}
+func (s *BadStmt) GetPos() error.Position {
+	return s.Pos
+}
+func (e *BadExpr) exprNode() {}
+func (e *BadExpr) GetPos() error.Position {
+	return e.Pos
+}
+
+func (e *DeclImport) declNode() {}
+func (e *DeclImport) GetPos() error.Position {
+	return e.Pos
+}
+
+func (f *Field) GetPos() error.Position {
+	return f.Pos
+}
+
 func (e *DeclFunction) declNode() {}
 func (e *DeclFunction) GetPos() error.Position {
 	return e.Pos
@@ -154,6 +274,22 @@ func (s *StmtIf) stmtNode() {}
 func (s *StmtIf) GetPos() error.Position {
 	return s.Pos
 }
+func (s *StmtWhile) stmtNode() {}
+func (s *StmtWhile) GetPos() error.Position {
+	return s.Pos
+}
+func (s *StmtFor) stmtNode() {}
+func (s *StmtFor) GetPos() error.Position {
+	return s.Pos
+}
+func (s *StmtBreak) stmtNode() {}
+func (s *StmtBreak) GetPos() error.Position {
+	return s.Pos
+}
+func (s *StmtContinue) stmtNode() {}
+func (s *StmtContinue) GetPos() error.Position {
+	return s.Pos
+}
 func (s *StmtBlock) stmtNode() {}
 func (s *StmtBlock) GetPos() error.Position {
 	return s.Pos
@@ -0,0 +1,253 @@
+package ast
+
+import "github.com/s0h1s2/error"
+
+// JSON_VERSION is raised whenever the layout of the exported trees changes in a
+// way readers must know about.
+const JSON_VERSION = 2
+
+// Object is a JSON object, encoding/json writes its keys sorted.
+type Object = map[string]interface{}
+
+// PosJSON exports a source position, the file is named once per export.
+func PosJSON(pos error.Position) Object {
+	return Object{"line": pos.Line, "column": pos.Column, "start": pos.Start, "end": pos.End}
+}
+
+// JSON exports the declarations of the file at 'path' for encoding/json. Every
+// node is an object whose "kind" is the name of its Go type.
+func JSON(path string, decls []Decl) Object {
+	list := []interface{}{}
+	for _, decl := range decls {
+		if decl != nil {
+			list = append(list, NodeJSON(decl))
+		}
+	}
+	return Object{"version": JSON_VERSION, "file": path, "decls": list}
+}
+
+func stmtsJSON(stmts []Stmt) []interface{} {
+	list := make([]interface{}, len(stmts))
+	for i, stmt := range stmts {
+		list[i] = NodeJSON(stmt)
+	}
+	return list
+}
+func exprsJSON(exprs []Expr) []interface{} {
+	list := make([]interface{}, len(exprs))
+	for i, expr := range exprs {
+		list[i] = NodeJSON(expr)
+	}
+	return list
+}
+func fieldsJSON(fields []Field) []interface{} {
+	list := make([]interface{}, len(fields))
+	for i := range fields {
+		list[i] = NodeJSON(&fields[i])
+	}
+	return list
+}
+func commentsJSON(obj Object, c *Comments) {
+	if len(c.Leading) > 0 {
+		leading := make([]string, len(c.Leading))
+		for i, comment := range c.Leading {
+			leading[i] = comment.Text
+		}
+		obj["leading"] = leading
+	}
+	if c.Trailing != nil {
+		obj["trailing"] = c.Trailing.Text
+	}
+}
+
+// NodeJSON exports a node and its children, a missing node is null.
+func NodeJSON(node Node) interface{} {
+	if node == nil {
+		return nil
+	}
+	obj := Object{"pos": PosJSON(node.GetPos())}
+	if c, ok := node.(Commented); ok {
+		commentsJSON(obj, c.GetComments())
+	}
+	switch n := node.(type) {
+	case *DeclImport:
+		{
+			obj["kind"] = "DeclImport"
+			obj["path"] = n.Path
+			obj["name"] = n.Name
+			obj["alias"] = n.Alias
+		}
+	case *DeclFunction:
+		{
+			obj["kind"] = "DeclFunction"
+			obj["name"] = n.Name
+			obj["pub"] = n.Pub
+			obj["params"] = fieldsJSON(n.Parameters)
+			obj["returnType"] = NodeJSON(n.RetType)
+			obj["body"] = NodeJSON(n.Body)
+		}
+	case *DeclExternalFunction:
+		{
+			obj["kind"] = "DeclExternalFunction"
+			obj["name"] = n.Name
+			obj["pub"] = n.Pub
+			obj["params"] = fieldsJSON(n.Parameters)
+			obj["returnType"] = NodeJSON(n.ReturnType)
+		}
+	case *DeclStruct:
+		{
+			obj["kind"] = "DeclStruct"
+			obj["name"] = n.Name
+			obj["pub"] = n.Pub
+			fields := make([]interface{}, len(n.Fields))
+			for i, field := range n.Fields {
+				fields[i] = NodeJSON(field)
+			}
+			obj["fields"] = fields
+		}
+	case *Field:
+		{
+			obj["kind"] = "Field"
+			obj["name"] = n.Name
+			obj["type"] = NodeJSON(n.Type)
+		}
+	case *StmtBlock:
+		{
+			obj["kind"] = "StmtBlock"
+			obj["stmts"] = stmtsJSON(n.Block)
+		}
+	case *StmtLet:
+		{
+			obj["kind"] = "StmtLet"
+			obj["name"] = n.Name
+			obj["type"] = NodeJSON(n.Type)
+			obj["init"] = NodeJSON(n.Init)
+		}
+	case *StmtIf:
+		{
+			obj["kind"] = "StmtIf"
+			obj["cond"] = NodeJSON(n.Cond)
+			obj["then"] = NodeJSON(n.Then)
+			obj["else"] = NodeJSON(n.Else)
+		}
+	case *StmtWhile:
+		{
+			obj["kind"] = "StmtWhile"
+			obj["cond"] = NodeJSON(n.Cond)
+			obj["body"] = NodeJSON(n.Body)
+		}
+	case *StmtFor:
+		{
+			obj["kind"] = "StmtFor"
+			obj["init"] = NodeJSON(n.Init)
+			obj["cond"] = NodeJSON(n.Cond)
+			obj["post"] = NodeJSON(n.Post)
+			obj["body"] = NodeJSON(n.Body)
+		}
+	case *StmtBreak:
+		{
+			obj["kind"] = "StmtBreak"
+		}
+	case *StmtContinue:
+		{
+			obj["kind"] = "StmtContinue"
+		}
+	case *StmtReturn:
+		{
+			obj["kind"] = "StmtReturn"
+			obj["result"] = NodeJSON(n.Result)
+		}
+	case *StmtExpr:
+		{
+			obj["kind"] = "StmtExpr"
+			obj["expr"] = NodeJSON(n.Expr)
+		}
+	case *ExprBinary:
+		{
+			obj["kind"] = "ExprBinary"
+			obj["op"] = n.Op.String()
+			obj["left"] = NodeJSON(n.Left)
+			obj["right"] = NodeJSON(n.Right)
+		}
+	case *ExprAssign:
+		{
+			obj["kind"] = "ExprAssign"
+			obj["left"] = NodeJSON(n.Left)
+			obj["right"] = NodeJSON(n.Right)
+		}
+	case *ExprCall:
+		{
+			obj["kind"] = "ExprCall"
+			obj["module"] = n.Module
+			obj["name"] = n.Name
+			obj["args"] = exprsJSON(n.Args)
+			obj["namePos"] = PosJSON(n.NamePos)
+		}
+	case *ExprCompound:
+		{
+			fields := make([]interface{}, len(n.Fields))
+			for i, field := range n.Fields {
+				fields[i] = Object{"name": field.Name, "pos": PosJSON(field.Pos), "init": NodeJSON(field.Init)}
+			}
+			obj["kind"] = "ExprCompound"
+			obj["type"] = NodeJSON(n.Type)
+			obj["fields"] = fields
+		}
+	case *ExprField:
+		{
+			obj["kind"] = "ExprField"
+			obj["name"] = n.Name
+			obj["expr"] = NodeJSON(n.Expr)
+		}
+	case *ExprIdent:
+		{
+			obj["kind"] = "ExprIdent"
+			obj["name"] = n.Name
+		}
+	case *ExprUnary:
+		{
+			obj["kind"] = "ExprUnary"
+			obj["op"] = n.Op.String()
+			obj["operand"] = NodeJSON(n.Right)
+		}
+	case *ExprInt:
+		{
+			obj["kind"] = "ExprInt"
+			obj["value"] = n.Value
+		}
+	case *ExprString:
+		{
+			obj["kind"] = "ExprString"
+			obj["value"] = n.Value
+		}
+	case *ExprBoolean:
+		{
+			obj["kind"] = "ExprBoolean"
+			obj["value"] = n.Value
+		}
+	case *TypeName:
+		{
+			obj["kind"] = "TypeName"
+			obj["module"] = n.Module
+			obj["name"] = n.Name
+		}
+	case *TypePtr:
+		{
+			obj["kind"] = "TypePtr"
+			obj["base"] = NodeJSON(n.Base)
+		}
+	case *BadDecl:
+		{
+			obj["kind"] = "BadDecl"
+		}
+	case *BadStmt:
+		{
+			obj["kind"] = "BadStmt"
+		}
+	case *BadExpr:
+		{
+			obj["kind"] = "BadExpr"
+		}
+	}
+	return obj
+}
@@ -0,0 +1,259 @@
+package ast
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// TypeString renders a type the way it is written in source.
+func TypeString(typ TypeSpec) string {
+	switch t := typ.(type) {
+	case *TypeName:
+		if t.Module != "" {
+			return t.Module + "." + t.Name
+		}
+		return t.Name
+	case *TypePtr:
+		return "*" + TypeString(t.Base)
+	}
+	return "?"
+}
+
+type printer struct {
+	w     io.Writer
+	depth int
+}
+
+func (p *printer) line(node Node, format string, args ...interface{}) {
+	text := fmt.Sprintf(format, args...)
+	if node != nil {
+		pos := node.GetPos()
+		text += fmt.Sprintf(" @%d:%d", pos.Line, pos.Column)
+	}
+	fmt.Fprintf(p.w, "%s%s\n", strings.Repeat("  ", p.depth), text)
+}
+
+// Fprint writes the syntax tree of 'decls' to 'w', one node per line indented
+// by depth.
+func Fprint(w io.Writer, decls []Decl) {
+	p := &printer{w: w}
+	for _, decl := range decls {
+		p.decl(decl)
+	}
+}
+
+func (p *printer) fields(kind string, fields []Field) {
+	for _, field := range fields {
+		p.line(nil, "%s %s %s", kind, field.Name, TypeString(field.Type))
+	}
+}
+
+func (p *printer) decl(decl Decl) {
+	switch node := decl.(type) {
+	case *DeclImport:
+		{
+			if node.Alias {
+				p.line(node, "DeclImport %q as %s", node.Path, node.Name)
+			} else {
+				p.line(node, "DeclImport %q", node.Path)
+			}
+		}
+	case *BadDecl:
+		{
+			p.line(node, "BadDecl")
+		}
+	case *DeclFunction:
+		{
+			p.line(node, "DeclFunction %s%s %s", pub(node.Pub), node.Name, TypeString(node.RetType))
+			p.depth++
+			p.fields("Param", node.Parameters)
+			p.stmt(node.Body)
+			p.depth--
+		}
+	case *DeclExternalFunction:
+		{
+			p.line(node, "DeclExternalFunction %s%s %s", pub(node.Pub), node.Name, TypeString(node.ReturnType))
+			p.depth++
+			p.fields("Param", node.Parameters)
+			p.depth--
+		}
+	case *DeclStruct:
+		{
+			p.line(node, "DeclStruct %s%s", pub(node.Pub), node.Name)
+			p.depth++
+			for _, field := range node.Fields {
+				p.line(nil, "Field %s %s", field.Name, TypeString(field.Type))
+			}
+			p.depth--
+		}
+	}
+}
+
+func pub(public bool) string {
+	if public {
+		return "pub "
+	}
+	return ""
+}
+
+func (p *printer) stmt(stmt Stmt) {
+	switch node := stmt.(type) {
+	case *StmtBlock:
+		{
+			p.line(node, "StmtBlock")
+			p.depth++
+			for _, stmt := range node.Block {
+				p.stmt(stmt)
+			}
+			p.depth--
+		}
+	case *StmtLet:
+		{
+			p.line(node, "StmtLet %s %s", node.Name, TypeString(node.Type))
+			p.child(node.Init)
+		}
+	case *StmtIf:
+		{
+			p.line(node, "StmtIf")
+			p.depth++
+			p.expr(node.Cond)
+			p.stmt(node.Then)
+			if node.Else != nil {
+				p.line(nil, "Else")
+				p.depth++
+				p.stmt(node.Else)
+				p.depth--
+			}
+			p.depth--
+		}
+	case *StmtWhile:
+		{
+			p.line(node, "StmtWhile")
+			p.depth++
+			p.expr(node.Cond)
+			p.stmt(node.Body)
+			p.depth--
+		}
+	case *StmtFor:
+		{
+			p.line(node, "StmtFor")
+			p.depth++
+			if node.Init != nil {
+				p.line(nil, "Init")
+				p.depth++
+				p.stmt(node.Init)
+				p.depth--
+			}
+			if node.Cond != nil {
+				p.line(nil, "Cond")
+				p.child(node.Cond)
+			}
+			if node.Post != nil {
+				p.line(nil, "Post")
+				p.child(node.Post)
+			}
+			p.stmt(node.Body)
+			p.depth--
+		}
+	case *StmtBreak:
+		{
+			p.line(node, "StmtBreak")
+		}
+	case *StmtContinue:
+		{
+			p.line(node, "StmtContinue")
+		}
+	case *StmtReturn:
+		{
+			p.line(node, "StmtReturn")
+			p.child(node.Result)
+		}
+	case *StmtExpr:
+		{
+			p.line(node, "StmtExpr")
+			p.child(node.Expr)
+		}
+	case *BadStmt:
+		{
+			p.line(node, "BadStmt")
+		}
+	}
+}
+
+func (p *printer) child(expr Expr) {
+	if expr == nil {
+		return
+	}
+	p.depth++
+	p.expr(expr)
+	p.depth--
+}
+
+func (p *printer) expr(expr Expr) {
+	switch node := expr.(type) {
+	case *ExprBinary:
+		{
+			p.line(node, "ExprBinary %s", node.Op.String())
+			p.child(node.Left)
+			p.child(node.Right)
+		}
+	case *ExprAssign:
+		{
+			p.line(node, "ExprAssign")
+			p.child(node.Left)
+			p.child(node.Right)
+		}
+	case *ExprUnary:
+		{
+			p.line(node, "ExprUnary %s", node.Op.String())
+			p.child(node.Right)
+		}
+	case *ExprCall:
+		{
+			name := node.Name
+			if node.Module != "" {
+				name = node.Module + "." + name
+			}
+			p.line(node, "ExprCall %s", name)
+			for _, arg := range node.Args {
+				p.child(arg)
+			}
+		}
+	case *ExprCompound:
+		{
+			p.line(node, "ExprCompound %s", TypeString(node.Type))
+			p.depth++
+			for _, field := range node.Fields {
+				p.line(nil, "Field %s", field.Name)
+				p.child(field.Init)
+			}
+			p.depth--
+		}
+	case *ExprField:
+		{
+			p.line(node, "ExprField %s", node.Name)
+			p.child(node.Expr)
+		}
+	case *ExprIdent:
+		{
+			p.line(node, "ExprIdent %s", node.Name)
+		}
+	case *ExprInt:
+		{
+			p.line(node, "ExprInt %s", node.Value)
+		}
+	case *ExprString:
+		{
+			p.line(node, "ExprString %q", node.Value)
+		}
+	case *ExprBoolean:
+		{
+			p.line(node, "ExprBoolean %t", node.Value)
+		}
+	case *BadExpr:
+		{
+			p.line(node, "BadExpr")
+		}
+	}
+}
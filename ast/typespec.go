@@ -7,8 +7,9 @@ type TypeSpec interface {
 	GetPos() error.Position
 }
 type TypeName struct {
-	Name string
-	Pos  error.Position
+	Module string // qualifier of 'mem.Buffer', empty for a plain name
+	Name   string
+	Pos    error.Position
 }
 type TypePtr struct {
 	Pos  error.Position
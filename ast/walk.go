@@ -0,0 +1,148 @@
+package ast
+
+// Visitor is called by Walk for every node it reaches. The visitor Visit returns
+// visits the children of the node, they are skipped when it is nil.
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+// Walk traverses the tree rooted at 'node' depth first. It calls v.Visit(node)
+// and, unless that returns nil, walks every child with the visitor returned
+// and then calls its Visit(nil). Nodes missing after syntax errors are skipped.
+func Walk(v Visitor, node Node) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+	switch n := node.(type) {
+	case *DeclFunction:
+		{
+			for i := range n.Parameters {
+				Walk(v, &n.Parameters[i])
+			}
+			walk(v, n.RetType)
+			if n.Body != nil {
+				Walk(v, n.Body)
+			}
+		}
+	case *DeclExternalFunction:
+		{
+			for i := range n.Parameters {
+				Walk(v, &n.Parameters[i])
+			}
+			walk(v, n.ReturnType)
+		}
+	case *DeclStruct:
+		{
+			for _, field := range n.Fields {
+				if field != nil {
+					Walk(v, field)
+				}
+			}
+		}
+	case *Field:
+		{
+			walk(v, n.Type)
+		}
+	case *StmtBlock:
+		{
+			for _, stmt := range n.Block {
+				walk(v, stmt)
+			}
+		}
+	case *StmtLet:
+		{
+			walk(v, n.Type)
+			walk(v, n.Init)
+		}
+	case *StmtIf:
+		{
+			walk(v, n.Cond)
+			if n.Then != nil {
+				Walk(v, n.Then)
+			}
+			walk(v, n.Else)
+		}
+	case *StmtWhile:
+		{
+			walk(v, n.Cond)
+			if n.Body != nil {
+				Walk(v, n.Body)
+			}
+		}
+	case *StmtFor:
+		{
+			walk(v, n.Init)
+			walk(v, n.Cond)
+			walk(v, n.Post)
+			if n.Body != nil {
+				Walk(v, n.Body)
+			}
+		}
+	case *StmtReturn:
+		{
+			walk(v, n.Result)
+		}
+	case *StmtExpr:
+		{
+			walk(v, n.Expr)
+		}
+	case *ExprBinary:
+		{
+			walk(v, n.Left)
+			walk(v, n.Right)
+		}
+	case *ExprAssign:
+		{
+			walk(v, n.Left)
+			walk(v, n.Right)
+		}
+	case *ExprCall:
+		{
+			for _, arg := range n.Args {
+				walk(v, arg)
+			}
+		}
+	case *ExprCompound:
+		{
+			walk(v, n.Type)
+			for _, field := range n.Fields {
+				walk(v, field.Init)
+			}
+		}
+	case *ExprField:
+		{
+			walk(v, n.Expr)
+		}
+	case *ExprUnary:
+		{
+			walk(v, n.Right)
+		}
+	case *TypePtr:
+		{
+			walk(v, n.Base)
+		}
+	}
+	v.Visit(nil)
+}
+
+// walk walks 'node' unless it is missing.
+func walk(v Visitor, node Node) {
+	if node != nil {
+		Walk(v, node)
+	}
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect walks the tree rooted at 'node', calling f for every node and, after
+// the children of a node, f(nil). The children are skipped when f returns false.
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
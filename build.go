@@ -0,0 +1,150 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/s0h1s2/compiler"
+)
+
+// outputs maps the -emit kinds to what the compiler produces for them and the
+// extension of the file it goes to. Executables are linked from an object.
+var outputs = map[string]struct {
+	kind compiler.Output
+	ext  string
+}{
+	"exe":  {compiler.OUTPUT_OBJ, ""},
+	"obj":  {compiler.OUTPUT_OBJ, ".o"},
+	"asm":  {compiler.OUTPUT_ASM, ".s"},
+	"c":    {compiler.OUTPUT_C, ".c"},
+	"llvm": {compiler.OUTPUT_LLVM, ".ll"},
+	"bc":   {compiler.OUTPUT_BC, ".dbc"},
+	"wat":  {compiler.OUTPUT_WAT, ".wat"},
+}
+
+// build compiles a source file, or the package around the working directory
+// when none is given, to an executable or one of the intermediate outputs and
+// returns the process exit code.
+func build(args []string) int {
+	flags := newFlags("build")
+	outFile := flags.String("o", "", "write the result to `file`")
+	emit := flags.String("emit", "exe", "output to produce: 'exe', 'obj', 'asm', 'c', 'llvm', 'bc' or 'wat'")
+	objectOnly := flags.Bool("c", false, "compile to an object file without linking, same as -emit=obj")
+	asmOnly := flags.Bool("S", false, "compile to assembly without assembling, same as -emit=asm")
+	target := flags.String("target", "x86_64", "architecture to compile for: 'x86_64' or 'wasm32'")
+	debug := flags.Bool("g", false, "emit DWARF debug information")
+	optLevel := optFlags(flags)
+	var libs, libDirs listFlag
+	flags.Var(&libs, "l", "link against `library`, may be repeated")
+	flags.Var(&libDirs, "L", "search `dir` for libraries, may be repeated")
+	filePath, pkg, ok := parseSource(flags, splitLibFlags(args))
+	if !ok {
+		return 2
+	}
+	if pkg != nil {
+		targetSet := false
+		flags.Visit(func(f *flag.Flag) {
+			targetSet = targetSet || f.Name == "target"
+		})
+		if !targetSet {
+			*target = pkg.Target
+		}
+		pkgLibs, pkgDirs := pkg.Link()
+		libs = append(pkgLibs, libs...)
+		libDirs = append(pkgDirs, libDirs...)
+	}
+	switch {
+	case *objectOnly:
+		*emit = "obj"
+	case *asmOnly:
+		*emit = "asm"
+	}
+	switch *target {
+	case "x86_64":
+		if *emit == "wat" {
+			fmt.Fprintf(os.Stderr, "Output 'wat' needs -target=wasm32.\n")
+			return 2
+		}
+	case "wasm32":
+		*emit = "wat"
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown target '%s'.\n", *target)
+		return 2
+	}
+	output, ok := outputs[*emit]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown output '%s'.\n", *emit)
+		return 2
+	}
+	outPath := *outFile
+	if outPath == "" && pkg != nil {
+		outPath = filepath.Join(pkg.Dir, pkg.Name) + output.ext
+	} else if outPath == "" {
+		outPath = strings.TrimSuffix(filePath, filepath.Ext(filePath)) + output.ext
+	}
+
+	session := packageSession(pkg)
+	session.OptLevel = optLevel()
+	session.Debug = *debug
+	if !session.ParseFile(filePath) {
+		return failed(session)
+	}
+	data, ok := session.Emit(output.kind)
+	if !ok {
+		return failed(session)
+	}
+	if *emit != "exe" {
+		return status(writeOutput(outPath, data))
+	}
+
+	dir, err := os.MkdirTemp("", "dennis")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to create a temporary directory: %s\n", err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+	objPath := filepath.Join(dir, filepath.Base(outPath)+".o")
+	if !writeOutput(objPath, data) {
+		return 1
+	}
+	return link(outPath, objPath, libs, libDirs)
+}
+
+// link runs the C compiler driver, $CC or cc, so the C runtime and the
+// libraries behind extern functions end up in the executable.
+func link(outPath string, objPath string, libs []string, libDirs []string) int {
+	cc := os.Getenv("CC")
+	if cc == "" {
+		cc = "cc"
+	}
+	args := []string{"-o", outPath, objPath}
+	for _, dir := range libDirs {
+		args = append(args, "-L"+dir)
+	}
+	for _, lib := range libs {
+		args = append(args, "-l"+lib)
+	}
+	cmd := exec.Command(cc, args...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return exitErr.ExitCode()
+		}
+		fmt.Fprintf(os.Stderr, "Unable to run linker '%s': %s\n", cc, err)
+		return 1
+	}
+	return 0
+}
+
+// status turns the outcome of writing the result into an exit code.
+func status(ok bool) int {
+	if ok {
+		return 0
+	}
+	return 1
+}
@@ -0,0 +1,152 @@
+package bytecode
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+type Op byte
+
+const (
+	OP_CONST Op = iota // push the operand
+	OP_LOCAL           // push the address of the frame slot at the operand
+	OP_LOAD            // replace an address by the signed integer of operand bytes stored there
+	OP_LOADU           // like OP_LOAD but zero extending
+	OP_STORE           // pop a value and an address and store the low operand bytes of the value
+	OP_TEE             // like OP_STORE but push the value back
+	OP_COPY            // pop a source and a destination address and copy operand bytes
+	OP_ADD
+	OP_SUB
+	OP_MUL
+	OP_DIV
+	OP_EQ
+	OP_NE
+	OP_LT
+	OP_LE
+	OP_GT
+	OP_GE
+	OP_NOT
+	OP_NEG
+	OP_WRAP // sign extend the low operand bytes of the top of the stack
+	OP_DROP
+	OP_CALL  // call Functions[operand]
+	OP_CALLX // call Externs[operand]
+	OP_RET   // return the top of the stack
+	OP_RETV  // return nothing
+	OP_JMP   // continue at the pc in the operand
+	OP_JZ    // pop a value and continue at the pc in the operand when it is zero
+)
+
+var opNames = [...]string{
+	OP_CONST: "const",
+	OP_LOCAL: "local",
+	OP_LOAD:  "load",
+	OP_LOADU: "loadu",
+	OP_STORE: "store",
+	OP_TEE:   "tee",
+	OP_COPY:  "copy",
+	OP_ADD:   "add",
+	OP_SUB:   "sub",
+	OP_MUL:   "mul",
+	OP_DIV:   "div",
+	OP_EQ:    "eq",
+	OP_NE:    "ne",
+	OP_LT:    "lt",
+	OP_LE:    "le",
+	OP_GT:    "gt",
+	OP_GE:    "ge",
+	OP_NOT:   "not",
+	OP_NEG:   "neg",
+	OP_WRAP:  "wrap",
+	OP_DROP:  "drop",
+	OP_CALL:  "call",
+	OP_CALLX: "callx",
+	OP_RET:   "ret",
+	OP_RETV:  "retv",
+	OP_JMP:   "jmp",
+	OP_JZ:    "jz",
+}
+
+func (op Op) String() string {
+	if int(op) < len(opNames) {
+		return opNames[op]
+	}
+	return fmt.Sprintf("op(%d)", byte(op))
+}
+
+// HasOperand reports whether the opcode is followed by a varint operand.
+func (op Op) HasOperand() bool {
+	switch op {
+	case OP_CONST, OP_LOCAL, OP_LOAD, OP_LOADU, OP_STORE, OP_TEE, OP_COPY, OP_WRAP, OP_CALL, OP_CALLX, OP_JMP, OP_JZ:
+		return true
+	}
+	return false
+}
+
+// DataBase is the address the data section is loaded at, address 0 stays invalid.
+const DataBase = 16
+
+// Param tells the VM where a function expects an argument in its frame.
+type Param struct {
+	Offset int64
+	Size   int64
+}
+
+type Function struct {
+	Name      string
+	Params    []Param
+	FrameSize int64
+	Code      []byte
+}
+
+type Extern struct {
+	Name    string
+	Strings []bool // which parameters are strings, for host functions
+	Returns bool
+}
+
+// Program is a compiled set of functions operating on a single linear memory.
+type Program struct {
+	Functions []*Function
+	Externs   []*Extern
+	Data      []byte // NUL terminated string literals
+}
+
+func (p *Program) Function(name string) (int, *Function) {
+	for i, fn := range p.Functions {
+		if fn.Name == name {
+			return i, fn
+		}
+	}
+	return -1, nil
+}
+
+// Operand decodes the varint following the opcode at 'pc' and returns the next pc.
+func Operand(code []byte, pc int) (int64, int) {
+	v, n := binary.Varint(code[pc:])
+	return v, pc + n
+}
+
+// String disassembles the program.
+func (p *Program) String() string {
+	var sb strings.Builder
+	for i, ext := range p.Externs {
+		fmt.Fprintf(&sb, "extern %d %s/%d\n", i, ext.Name, len(ext.Strings))
+	}
+	for i, fn := range p.Functions {
+		fmt.Fprintf(&sb, "\nfunction %d %s/%d frame=%d\n", i, fn.Name, len(fn.Params), fn.FrameSize)
+		for pc := 0; pc < len(fn.Code); {
+			op := Op(fn.Code[pc])
+			fmt.Fprintf(&sb, "  %04d %s", pc, op)
+			pc++
+			if op.HasOperand() {
+				var v int64
+				v, pc = Operand(fn.Code, pc)
+				fmt.Fprintf(&sb, " %d", v)
+			}
+			sb.WriteByte('\n')
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,482 @@
+package bytecode
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+
+	"github.com/s0h1s2/error"
+	"github.com/s0h1s2/resolver"
+	"github.com/s0h1s2/scope"
+	"github.com/s0h1s2/types"
+)
+
+type compiler struct {
+	handler   *error.DiagnosticBag
+	table     *resolver.Table
+	program   *Program
+	functions map[string]int
+	externs   map[string]int
+	strings   map[string]int64
+	fn        *Function
+	ret       *types.Type
+	slots     map[*scope.Object]int64
+	loops     []*loop // around the statement being compiled, innermost last
+}
+
+// loop collects the jumps of the breaks and continues of a loop until their
+// targets are known.
+type loop struct {
+	breaks    []int
+	continues []int
+}
+
+// Compile translates checked declarations into bytecode. Every local lives in
+// the frame of its function, struct values are handled through their address.
+func Compile(decls []resolver.DeclNode, table *resolver.Table, handler *error.DiagnosticBag) *Program {
+	c := &compiler{
+		handler:   handler,
+		table:     table,
+		program:   &Program{},
+		functions: make(map[string]int),
+		externs:   make(map[string]int),
+		strings:   make(map[string]int64),
+	}
+	for _, decl := range decls {
+		switch node := decl.(type) {
+		case *resolver.DeclFunction:
+			{
+				c.functions[node.Link] = len(c.program.Functions)
+				c.program.Functions = append(c.program.Functions, &Function{Name: node.Link})
+			}
+		case *resolver.DeclExternalFunction:
+			{
+				ext := &Extern{Name: node.Name, Returns: node.ReturnType.Kind != types.TYPE_VOID}
+				for _, param := range node.Params {
+					ext.Strings = append(ext.Strings, param.Type.Kind == types.TYPE_STRING)
+				}
+				c.externs[node.Name] = len(c.program.Externs)
+				c.program.Externs = append(c.program.Externs, ext)
+			}
+		}
+	}
+	for _, decl := range decls {
+		if node, ok := decl.(*resolver.DeclFunction); ok {
+			c.compileFunction(node)
+		}
+	}
+	return c.program
+}
+
+func (c *compiler) emit(op Op) {
+	c.fn.Code = append(c.fn.Code, byte(op))
+}
+func (c *compiler) emitArg(op Op, arg int64) {
+	c.fn.Code = binary.AppendVarint(append(c.fn.Code, byte(op)), arg)
+}
+
+// JUMP_WIDTH is the size of a jump operand. Jumps are emitted before their
+// target is known, so their varint is padded to a fixed width to be patched.
+const JUMP_WIDTH = 5
+
+// jump emits a jump and returns the offset of its operand, see patch.
+func (c *compiler) jump(op Op) int {
+	c.emit(op)
+	at := len(c.fn.Code)
+	c.fn.Code = append(c.fn.Code, make([]byte, JUMP_WIDTH)...)
+	return at
+}
+
+// patch points the jump whose operand is at 'at' to the end of the code.
+func (c *compiler) patch(at int) {
+	c.patchTo(at, len(c.fn.Code))
+}
+func (c *compiler) patchTo(at int, pc int) {
+	target := uint64(pc) << 1 // zigzag encoded
+	for i := 0; i < JUMP_WIDTH-1; i++ {
+		c.fn.Code[at+i] = byte(target) | 0x80
+		target >>= 7
+	}
+	c.fn.Code[at+JUMP_WIDTH-1] = byte(target)
+}
+func (c *compiler) allocSlot(typ *types.Type) int64 {
+	align := int64(typ.Alignment)
+	if align == 0 {
+		align = 1
+	}
+	offset := (c.fn.FrameSize + align - 1) / align * align
+	c.fn.FrameSize = offset + int64(typ.Size)
+	return offset
+}
+
+func (c *compiler) compileFunction(fun *resolver.DeclFunction) {
+	c.fn = c.program.Functions[c.functions[fun.Link]]
+	c.ret = fun.ReturnType
+	c.slots = make(map[*scope.Object]int64)
+	if fun.ReturnType.Kind == types.TYPE_STRUCT {
+		c.handler.ReportError(fun.GetPos(), "Returning struct '%s' by value is not supported yet", fun.ReturnType.TypeName)
+		return
+	}
+	for _, param := range fun.Params {
+		if param.Type.Kind == types.TYPE_STRUCT {
+			c.handler.ReportError(fun.GetPos(), "Passing struct '%s' by value is not supported yet", param.Type.TypeName)
+			return
+		}
+		slot := c.allocSlot(param.Type)
+		c.slots[fun.Scope.GetObj(param.Name)] = slot
+		c.fn.Params = append(c.fn.Params, Param{Offset: slot, Size: int64(param.Type.Size)})
+	}
+	c.compileStmt(fun.Body)
+	if fun.ReturnType.Kind == types.TYPE_VOID {
+		c.emit(OP_RETV)
+	} else {
+		c.emitArg(OP_CONST, 0)
+		c.emit(OP_RET)
+	}
+	c.fn.FrameSize = (c.fn.FrameSize + 15) / 16 * 16
+}
+
+func (c *compiler) compileStmt(stmt resolver.StmtNode) {
+	switch node := stmt.(type) {
+	case *resolver.StmtBlock:
+		{
+			for _, stmt := range node.Body {
+				c.compileStmt(stmt)
+			}
+		}
+	case *resolver.StmtLet:
+		{
+			slot := c.allocSlot(node.Type)
+			c.slots[node.Scope.GetObj(node.Name)] = slot
+			if node.Init == nil {
+				return
+			}
+			c.emitArg(OP_LOCAL, slot)
+			if node.Type.Kind == types.TYPE_STRUCT {
+				c.compileAddr(node.Init)
+				c.emitArg(OP_COPY, int64(node.Type.Size))
+				return
+			}
+			c.compileValue(node.Init, node.Type)
+			c.emitArg(OP_STORE, int64(node.Type.Size))
+		}
+	case *resolver.StmtIf:
+		{
+			c.compileExpr(node.Cond)
+			skip := c.jump(OP_JZ)
+			c.compileStmt(node.Then)
+			if node.Else == nil {
+				c.patch(skip)
+				return
+			}
+			end := c.jump(OP_JMP)
+			c.patch(skip)
+			c.compileStmt(node.Else)
+			c.patch(end)
+		}
+	case *resolver.StmtWhile:
+		{
+			c.compileLoop(node.Cond, nil, node.Body)
+		}
+	case *resolver.StmtFor:
+		{
+			c.compileStmt(node.Init)
+			var post resolver.StmtNode
+			if node.Post != nil {
+				post = &resolver.StmtExpr{Expr: node.Post, Scope: node.Scope, Pos: node.Pos}
+			}
+			c.compileLoop(node.Cond, post, node.Body)
+		}
+	case *resolver.StmtBreak:
+		{
+			l := c.loops[len(c.loops)-1]
+			l.breaks = append(l.breaks, c.jump(OP_JMP))
+		}
+	case *resolver.StmtContinue:
+		{
+			l := c.loops[len(c.loops)-1]
+			l.continues = append(l.continues, c.jump(OP_JMP))
+		}
+	case *resolver.StmtReturn:
+		{
+			if node.Result == nil {
+				c.emit(OP_RETV)
+				return
+			}
+			c.compileValue(node.Result, c.ret)
+			c.emit(OP_RET)
+		}
+	case *resolver.StmtExpr:
+		{
+			if assign, ok := node.Expr.(*resolver.ExprAssign); ok {
+				c.compileAssign(assign, false)
+				return
+			}
+			typ := c.exprType(node.Expr)
+			if typ.Kind == types.TYPE_STRUCT {
+				c.compileAddr(node.Expr)
+			} else {
+				c.compileExpr(node.Expr)
+			}
+			if typ.Kind != types.TYPE_VOID {
+				c.emit(OP_DROP)
+			}
+		}
+	}
+}
+
+// compileLoop runs 'body' then 'post' while 'cond', which may be nil, holds.
+func (c *compiler) compileLoop(cond resolver.ExprNode, post resolver.StmtNode, body resolver.StmtNode) {
+	top := len(c.fn.Code)
+	l := &loop{}
+	if cond != nil {
+		c.compileExpr(cond)
+		l.breaks = append(l.breaks, c.jump(OP_JZ))
+	}
+	c.loops = append(c.loops, l)
+	c.compileStmt(body)
+	c.loops = c.loops[:len(c.loops)-1]
+	for _, at := range l.continues {
+		c.patch(at)
+	}
+	c.compileStmt(post)
+	c.patchTo(c.jump(OP_JMP), top)
+	for _, at := range l.breaks {
+		c.patch(at)
+	}
+}
+
+var binaryOps = map[resolver.BinaryOperator]Op{
+	resolver.ADD: OP_ADD,
+	resolver.SUB: OP_SUB,
+	resolver.MUL: OP_MUL,
+	resolver.DIV: OP_DIV,
+	resolver.EQ:  OP_EQ,
+	resolver.NE:  OP_NE,
+	resolver.LT:  OP_LT,
+	resolver.LE:  OP_LE,
+	resolver.GT:  OP_GT,
+	resolver.GE:  OP_GE,
+}
+
+// compileExpr pushes the value of a scalar expression, or the address of a struct one.
+func (c *compiler) compileExpr(expr resolver.ExprNode) {
+	switch node := expr.(type) {
+	case *resolver.ExprInt:
+		{
+			val, err := strconv.ParseInt(node.Value, 10, 64)
+			if err != nil {
+				c.handler.ReportError(node.GetPos(), "Integer literal '%s' is out of range", node.Value)
+			}
+			c.emitArg(OP_CONST, val)
+		}
+	case *resolver.ExprBool:
+		{
+			val := int64(0)
+			if node.Value {
+				val = 1
+			}
+			c.emitArg(OP_CONST, val)
+		}
+	case *resolver.ExprString:
+		{
+			c.emitArg(OP_CONST, c.addString(node.Value))
+		}
+	case *resolver.ExprIdentifier, *resolver.ExprField:
+		{
+			c.compileAddr(node)
+			c.load(c.exprType(node))
+		}
+	case *resolver.ExprUnary:
+		{
+			switch node.Op {
+			case resolver.REFER:
+				{
+					c.compileAddr(node.Right)
+				}
+			case resolver.DEREF:
+				{
+					c.compileExpr(node.Right)
+					c.load(c.exprType(node))
+				}
+			case resolver.NOT:
+				{
+					c.compileExpr(node.Right)
+					c.emit(OP_NOT)
+				}
+			case resolver.MINUS:
+				{
+					typ := c.exprType(node)
+					c.compileValue(node.Right, typ)
+					c.emit(OP_NEG)
+					c.wrap(typ)
+				}
+			}
+		}
+	case *resolver.ExprBinary:
+		{
+			operandType := node.Type
+			if resolver.IsCompare(node.Op) {
+				operandType = c.exprType(node.Left)
+				if right := c.exprType(node.Right); right.Size > operandType.Size {
+					operandType = right
+				}
+			}
+			c.compileValue(node.Left, operandType)
+			c.compileValue(node.Right, operandType)
+			c.emit(binaryOps[node.Op])
+			if !resolver.IsCompare(node.Op) {
+				c.wrap(node.Type)
+			}
+		}
+	case *resolver.ExprAssign:
+		{
+			c.compileAssign(node, true)
+		}
+	case *resolver.ExprCall:
+		{
+			c.compileCall(node)
+		}
+	case *resolver.ExprCompound:
+		{
+			c.compileAddr(node)
+		}
+	default:
+		{
+			panic(fmt.Sprintf("Unhandled %T or unreachable", node))
+		}
+	}
+}
+
+// compileValue pushes a scalar wrapped to the width of 'to'.
+func (c *compiler) compileValue(expr resolver.ExprNode, to *types.Type) {
+	c.compileExpr(expr)
+	from := c.exprType(expr)
+	if from.Kind == types.TYPE_INT && to.Kind == types.TYPE_INT && to.Size < from.Size {
+		c.wrap(to)
+	}
+}
+func (c *compiler) wrap(typ *types.Type) {
+	if typ.Kind == types.TYPE_INT && typ.Size < 8 {
+		c.emitArg(OP_WRAP, int64(typ.Size))
+	}
+}
+
+func (c *compiler) compileAssign(node *resolver.ExprAssign, result bool) {
+	typ := c.exprType(node.Left)
+	c.compileAddr(node.Left)
+	if typ.Kind == types.TYPE_STRUCT {
+		c.compileAddr(node.Right)
+		c.emitArg(OP_COPY, int64(typ.Size))
+		if result {
+			c.compileAddr(node.Left)
+		}
+		return
+	}
+	c.compileValue(node.Right, typ)
+	if result {
+		c.emitArg(OP_TEE, int64(typ.Size))
+	} else {
+		c.emitArg(OP_STORE, int64(typ.Size))
+	}
+}
+
+// compileAddr pushes the address of an lvalue or struct value.
+func (c *compiler) compileAddr(expr resolver.ExprNode) {
+	switch node := expr.(type) {
+	case *resolver.ExprIdentifier:
+		{
+			c.emitArg(OP_LOCAL, c.slots[node.Obj])
+		}
+	case *resolver.ExprField:
+		{
+			base := c.exprType(node.Expr)
+			if base.Kind == types.TYPE_PTR {
+				c.compileExpr(node.Expr)
+				base = base.Base
+			} else {
+				c.compileAddr(node.Expr)
+			}
+			field := base.Field(node.Name)
+			if field.Offset != 0 {
+				c.emitArg(OP_CONST, int64(field.Offset))
+				c.emit(OP_ADD)
+			}
+		}
+	case *resolver.ExprUnary:
+		{
+			if node.Op != resolver.DEREF {
+				c.handler.ReportError(node.GetPos(), "Expression is not addressable")
+				return
+			}
+			c.compileExpr(node.Right)
+		}
+	case *resolver.ExprCompound:
+		{
+			slot := c.allocSlot(node.Type)
+			for _, init := range node.Fields {
+				field := node.Type.Field(init.Name)
+				c.emitArg(OP_LOCAL, slot+int64(field.Offset))
+				if field.Type.Kind == types.TYPE_STRUCT {
+					c.compileAddr(init.Expr)
+					c.emitArg(OP_COPY, int64(field.Type.Size))
+					continue
+				}
+				c.compileValue(init.Expr, field.Type)
+				c.emitArg(OP_STORE, int64(field.Type.Size))
+			}
+			c.emitArg(OP_LOCAL, slot)
+		}
+	default:
+		{
+			c.handler.ReportError(expr.GetPos(), "Expression is not addressable")
+		}
+	}
+}
+
+func (c *compiler) compileCall(node *resolver.ExprCall) {
+	fnObj := node.Obj
+	params := fnObj.Scope.QueryObjByKind(scope.PARAM)
+	for i, arg := range node.Args {
+		if typ := c.exprType(arg.Expr); typ.Kind == types.TYPE_STRUCT {
+			c.handler.ReportError(node.Pos, "Passing struct '%s' by value is not supported yet", typ.TypeName)
+			return
+		}
+		c.compileValue(arg.Expr, params[i].Type)
+	}
+	if fnObj.Type.Kind == types.TYPE_STRUCT {
+		c.handler.ReportError(node.Pos, "Returning struct '%s' by value is not supported yet", fnObj.Type.TypeName)
+	}
+	if index, ok := c.externs[fnObj.Link]; ok {
+		c.emitArg(OP_CALLX, int64(index))
+	} else {
+		c.emitArg(OP_CALL, int64(c.functions[fnObj.Link]))
+	}
+	c.wrap(fnObj.Type)
+}
+
+func (c *compiler) exprType(expr resolver.ExprNode) *types.Type {
+	return c.table.TypeOf(expr)
+}
+
+func (c *compiler) load(typ *types.Type) {
+	switch typ.Kind {
+	case types.TYPE_STRUCT:
+		return
+	case types.TYPE_INT:
+		c.emitArg(OP_LOAD, int64(typ.Size))
+	default:
+		c.emitArg(OP_LOADU, int64(typ.Size))
+	}
+}
+
+// addString places a NUL terminated string in the data section and returns its address.
+func (c *compiler) addString(value string) int64 {
+	if addr, ok := c.strings[value]; ok {
+		return addr
+	}
+	addr := DataBase + int64(len(c.program.Data))
+	c.program.Data = append(append(c.program.Data, value...), 0)
+	c.strings[value] = addr
+	return addr
+}
@@ -0,0 +1,242 @@
+package bytecode
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	magic   = "DNBC"
+	version = 1
+)
+
+type writer struct {
+	buf []byte
+}
+
+func (w *writer) uint(v uint64) {
+	w.buf = binary.AppendUvarint(w.buf, v)
+}
+func (w *writer) int(v int64) {
+	w.buf = binary.AppendVarint(w.buf, v)
+}
+func (w *writer) bytes(b []byte) {
+	w.uint(uint64(len(b)))
+	w.buf = append(w.buf, b...)
+}
+func (w *writer) bool(b bool) {
+	if b {
+		w.buf = append(w.buf, 1)
+	} else {
+		w.buf = append(w.buf, 0)
+	}
+}
+
+// Bytes serializes the program into its on-disk form.
+func (p *Program) Bytes() []byte {
+	w := &writer{buf: append([]byte(magic), version)}
+	w.bytes(p.Data)
+	w.uint(uint64(len(p.Externs)))
+	for _, ext := range p.Externs {
+		w.bytes([]byte(ext.Name))
+		w.uint(uint64(len(ext.Strings)))
+		for _, str := range ext.Strings {
+			w.bool(str)
+		}
+		w.bool(ext.Returns)
+	}
+	w.uint(uint64(len(p.Functions)))
+	for _, fn := range p.Functions {
+		w.bytes([]byte(fn.Name))
+		w.uint(uint64(len(fn.Params)))
+		for _, param := range fn.Params {
+			w.int(param.Offset)
+			w.int(param.Size)
+		}
+		w.int(fn.FrameSize)
+		w.bytes(fn.Code)
+	}
+	return w.buf
+}
+
+var errTruncated = errors.New("bytecode: unexpected end of data")
+
+type reader struct {
+	buf []byte
+	err error
+}
+
+func (r *reader) uint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.buf)
+	if n <= 0 {
+		r.err = errTruncated
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return v
+}
+func (r *reader) int() int64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(r.buf)
+	if n <= 0 {
+		r.err = errTruncated
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return v
+}
+func (r *reader) bytes() []byte {
+	n := r.uint()
+	if r.err != nil {
+		return nil
+	}
+	if n > uint64(len(r.buf)) {
+		r.err = errTruncated
+		return nil
+	}
+	b := r.buf[:n:n]
+	r.buf = r.buf[n:]
+	return b
+}
+func (r *reader) bool() bool {
+	if r.err != nil {
+		return false
+	}
+	if len(r.buf) == 0 {
+		r.err = errTruncated
+		return false
+	}
+	b := r.buf[0]
+	r.buf = r.buf[1:]
+	return b != 0
+}
+
+// count reads a length and rejects ones that can't fit in the remaining data.
+func (r *reader) count() int {
+	n := r.uint()
+	if n > uint64(len(r.buf)) {
+		r.err = errTruncated
+		return 0
+	}
+	return int(n)
+}
+
+// Read decodes a program serialized by Bytes.
+func Read(data []byte) (*Program, error) {
+	if len(data) < len(magic)+1 || string(data[:len(magic)]) != magic {
+		return nil, errors.New("bytecode: not a Dennis bytecode file")
+	}
+	if data[len(magic)] != version {
+		return nil, fmt.Errorf("bytecode: unsupported version %d", data[len(magic)])
+	}
+	r := &reader{buf: data[len(magic)+1:]}
+	p := &Program{Data: r.bytes()}
+	for i, n := 0, r.count(); i < n && r.err == nil; i++ {
+		ext := &Extern{Name: string(r.bytes())}
+		ext.Strings = make([]bool, r.count())
+		for j := range ext.Strings {
+			ext.Strings[j] = r.bool()
+		}
+		ext.Returns = r.bool()
+		p.Externs = append(p.Externs, ext)
+	}
+	for i, n := 0, r.count(); i < n && r.err == nil; i++ {
+		fn := &Function{Name: string(r.bytes())}
+		fn.Params = make([]Param, r.count())
+		for j := range fn.Params {
+			fn.Params[j] = Param{Offset: r.int(), Size: r.int()}
+		}
+		fn.FrameSize = r.int()
+		fn.Code = r.bytes()
+		p.Functions = append(p.Functions, fn)
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	for _, fn := range p.Functions {
+		if err := p.verify(fn); err != nil {
+			return nil, fmt.Errorf("bytecode: function '%s': %s", fn.Name, err)
+		}
+	}
+	return p, nil
+}
+
+// scalar reports whether 'size' is the size of a value the VM loads and stores.
+func scalar(size int64) bool {
+	return size == 1 || size == 2 || size == 4 || size == 8
+}
+
+// verify checks what the VM trusts about a decoded function: its frame holds its
+// parameters, every instruction and operand decodes, sizes and indices are in
+// range and jumps land on instructions.
+func (p *Program) verify(fn *Function) error {
+	if fn.FrameSize < 0 {
+		return fmt.Errorf("negative frame size %d", fn.FrameSize)
+	}
+	for _, param := range fn.Params {
+		if param.Offset < 0 || !scalar(param.Size) || param.Offset+param.Size > fn.FrameSize {
+			return fmt.Errorf("parameter at %d of size %d is outside the frame", param.Offset, param.Size)
+		}
+	}
+	starts := make(map[int64]bool)
+	jumps := [][2]int64{} // pc of a jump and its target
+	code := fn.Code
+	for pc := 0; pc < len(code); {
+		at := pc
+		starts[int64(at)] = true
+		op := Op(code[pc])
+		pc++
+		if int(op) >= len(opNames) {
+			return fmt.Errorf("invalid opcode %d at %d", byte(op), at)
+		}
+		if !op.HasOperand() {
+			continue
+		}
+		arg, n := binary.Varint(code[pc:])
+		if n <= 0 {
+			return fmt.Errorf("truncated operand of %s at %d", op, at)
+		}
+		pc += n
+		switch op {
+		case OP_LOAD, OP_LOADU, OP_STORE, OP_TEE, OP_WRAP:
+			{
+				if !scalar(arg) {
+					return fmt.Errorf("invalid size %d of %s at %d", arg, op, at)
+				}
+			}
+		case OP_COPY:
+			{
+				if arg < 0 {
+					return fmt.Errorf("invalid size %d of %s at %d", arg, op, at)
+				}
+			}
+		case OP_CALL:
+			{
+				if arg < 0 || arg >= int64(len(p.Functions)) {
+					return fmt.Errorf("call of missing function %d at %d", arg, at)
+				}
+			}
+		case OP_CALLX:
+			{
+				if arg < 0 || arg >= int64(len(p.Externs)) {
+					return fmt.Errorf("call of missing extern %d at %d", arg, at)
+				}
+			}
+		case OP_JMP, OP_JZ:
+			jumps = append(jumps, [2]int64{int64(at), arg})
+		}
+	}
+	starts[int64(len(code))] = true
+	for _, jump := range jumps {
+		if !starts[jump[1]] {
+			return fmt.Errorf("jump at %d to %d is not an instruction", jump[0], jump[1])
+		}
+	}
+	return nil
+}
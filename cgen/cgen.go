@@ -0,0 +1,447 @@
+package cgen
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/s0h1s2/resolver"
+	"github.com/s0h1s2/scope"
+	"github.com/s0h1s2/types"
+)
+
+// C keywords and names from the included headers which Dennis allows as identifiers.
+var reserved = map[string]bool{
+	"auto": true, "break": true, "case": true, "char": true, "const": true, "continue": true,
+	"default": true, "do": true, "double": true, "else": true, "enum": true, "extern": true,
+	"float": true, "for": true, "goto": true, "inline": true, "int": true, "long": true,
+	"register": true, "restrict": true, "short": true, "signed": true, "sizeof": true,
+	"static": true, "switch": true, "typedef": true, "union": true, "unsigned": true,
+	"volatile": true, "while": true, "bool": true, "true": true, "false": true,
+}
+
+type generator struct {
+	table  *resolver.Table
+	sb     *strings.Builder
+	indent int
+	fun    *resolver.DeclFunction
+	temps  []string // declarations of the temporaries of the function
+}
+
+// Generate translates checked declarations into a C99 translation unit.
+func Generate(decls []resolver.DeclNode, table *resolver.Table) string {
+	g := &generator{table: table, sb: &strings.Builder{}}
+	g.line("#include <stdbool.h>")
+	g.line("#include <stdint.h>")
+	g.line("")
+	for _, decl := range decls {
+		if node, ok := decl.(*resolver.DeclStruct); ok {
+			g.genStruct(node)
+		}
+	}
+	for _, decl := range decls {
+		switch node := decl.(type) {
+		case *resolver.DeclExternalFunction:
+			{
+				g.line("%s;", g.prototype(node.Name, node.ReturnType, node.Params))
+			}
+		case *resolver.DeclFunction:
+			{
+				g.line("%s;", g.declaration(node))
+			}
+		}
+	}
+	for _, decl := range decls {
+		if node, ok := decl.(*resolver.DeclFunction); ok {
+			g.line("")
+			g.genFunction(node)
+		}
+	}
+	return g.sb.String()
+}
+
+func (g *generator) line(format string, args ...interface{}) {
+	if format != "" {
+		g.sb.WriteString(strings.Repeat("    ", g.indent))
+	}
+	fmt.Fprintf(g.sb, format, args...)
+	g.sb.WriteByte('\n')
+}
+func name(ident string) string {
+	if reserved[ident] {
+		return ident + "_"
+	}
+	return ident
+}
+
+func cType(typ *types.Type) string {
+	switch typ.Kind {
+	case types.TYPE_INT:
+		return fmt.Sprintf("int%d_t", typ.Size*8)
+	case types.TYPE_BOOL:
+		return "bool"
+	case types.TYPE_VOID:
+		return "void"
+	case types.TYPE_STRING:
+		return "const char*"
+	case types.TYPE_PTR:
+		return cType(typ.Base) + "*"
+	}
+	return name(typ.Link)
+}
+
+func (g *generator) genStruct(node *resolver.DeclStruct) {
+	structName := name(node.Type.Link)
+	g.line("typedef struct %s %s;", structName, structName)
+	g.line("struct %s {", structName)
+	g.indent++
+	for _, field := range node.Fields {
+		g.line("%s %s;", cType(field.Type), name(field.Name))
+	}
+	g.indent--
+	g.line("};")
+	g.line("")
+}
+
+func (g *generator) prototype(fnName string, ret *types.Type, params []resolver.Field) string {
+	if fnName == "main" {
+		return "int main(void)"
+	}
+	args := make([]string, 0, len(params))
+	for _, param := range params {
+		args = append(args, cType(param.Type)+" "+name(param.Name))
+	}
+	if len(args) == 0 {
+		args = append(args, "void")
+	}
+	return fmt.Sprintf("%s %s(%s)", cType(ret), fnName, strings.Join(args, ", "))
+}
+
+// declaration is the prototype of a function of the program, private ones are static.
+func (g *generator) declaration(fun *resolver.DeclFunction) string {
+	proto := g.prototype(fun.Link, fun.ReturnType, fun.Params)
+	if !fun.Public && fun.Link != "main" {
+		return "static " + proto
+	}
+	return proto
+}
+
+func (g *generator) genFunction(fun *resolver.DeclFunction) {
+	g.fun = fun
+	g.temps = nil
+	out := g.sb
+	g.sb = &strings.Builder{}
+	g.genBody(fun.Body.(*resolver.StmtBlock))
+	body := g.sb.String()
+	g.sb = out
+	g.line("%s {", g.declaration(fun))
+	g.indent++
+	for _, temp := range g.temps {
+		g.line("%s", temp)
+	}
+	g.indent--
+	g.sb.WriteString(body)
+	g.line("}")
+}
+
+// temp declares a temporary of type 'typ' in the function being generated.
+// Dennis identifiers can't start with '_', so its name is free.
+func (g *generator) temp(typ *types.Type) string {
+	temp := fmt.Sprintf("_%d", len(g.temps))
+	g.temps = append(g.temps, fmt.Sprintf("%s %s;", cType(typ), temp))
+	return temp
+}
+
+// ordered prints 'exprs', which are converted to 'types', so that they are
+// evaluated left to right like the other backends do. C leaves the order of
+// operands and arguments unspecified, so when one of them has side effects
+// each that isn't a literal is stored in a temporary first. The stores are
+// returned for sequence.
+func (g *generator) ordered(exprs []resolver.ExprNode, typs []*types.Type) ([]string, string) {
+	values := make([]string, len(exprs))
+	pure := true
+	for i, expr := range exprs {
+		values[i] = g.genExpr(expr)
+		pure = pure && !effects(expr)
+	}
+	if pure || len(exprs) < 2 {
+		return values, ""
+	}
+	stores := ""
+	for i, expr := range exprs {
+		switch expr.(type) {
+		case *resolver.ExprInt, *resolver.ExprBool, *resolver.ExprString:
+			continue
+		}
+		temp := g.temp(typs[i])
+		stores += fmt.Sprintf("%s = %s, ", temp, values[i])
+		values[i] = temp
+	}
+	return values, stores
+}
+
+// sequence prints 'expr' after the stores returned by ordered.
+func sequence(stores string, expr string) string {
+	if stores == "" {
+		return expr
+	}
+	return "(" + stores + expr + ")"
+}
+
+// effects reports whether evaluating 'expr' may call a function or assign.
+func effects(expr resolver.ExprNode) bool {
+	found := false
+	resolver.Inspect(expr, func(node resolver.Node) bool {
+		switch node.(type) {
+		case *resolver.ExprCall, *resolver.ExprAssign:
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+func (g *generator) genBody(block *resolver.StmtBlock) {
+	g.indent++
+	for _, stmt := range block.Body {
+		g.genStmt(stmt)
+	}
+	g.indent--
+}
+
+func (g *generator) genStmt(stmt resolver.StmtNode) {
+	switch node := stmt.(type) {
+	case *resolver.StmtBlock:
+		{
+			g.line("{")
+			g.genBody(node)
+			g.line("}")
+		}
+	case *resolver.StmtLet:
+		{
+			if node.Init == nil {
+				g.line("%s %s;", cType(node.Type), name(node.Name))
+				return
+			}
+			g.line("%s %s = %s;", cType(node.Type), name(node.Name), g.genExpr(node.Init))
+		}
+	case *resolver.StmtIf:
+		{
+			g.genIf(node, "if")
+		}
+	case *resolver.StmtWhile:
+		{
+			g.line("while (%s) {", g.genExpr(node.Cond))
+			g.genBody(node.Body.(*resolver.StmtBlock))
+			g.line("}")
+		}
+	case *resolver.StmtFor:
+		{
+			// The braces scope the variable of the initializer like the
+			// loop scopes it.
+			g.line("{")
+			g.indent++
+			g.genStmt(node.Init)
+			cond, post := "", ""
+			if node.Cond != nil {
+				cond = g.genExpr(node.Cond)
+			}
+			if node.Post != nil {
+				post = g.genExpr(node.Post)
+			}
+			g.line("for (; %s; %s) {", cond, post)
+			g.genBody(node.Body.(*resolver.StmtBlock))
+			g.line("}")
+			g.indent--
+			g.line("}")
+		}
+	case *resolver.StmtBreak:
+		{
+			g.line("break;")
+		}
+	case *resolver.StmtContinue:
+		{
+			g.line("continue;")
+		}
+	case *resolver.StmtReturn:
+		{
+			if node.Result != nil {
+				g.line("return %s;", g.genExpr(node.Result))
+			} else if g.fun.Link == "main" {
+				g.line("return 0;")
+			} else {
+				g.line("return;")
+			}
+		}
+	case *resolver.StmtExpr:
+		{
+			g.line("%s;", g.genExpr(node.Expr))
+		}
+	}
+}
+
+// genIf prints an if statement opened by 'keyword', an 'else if' continues the
+// chain on the closing brace of the branch before it.
+func (g *generator) genIf(node *resolver.StmtIf, keyword string) {
+	g.line("%s (%s) {", keyword, g.genExpr(node.Cond))
+	g.genBody(node.Then.(*resolver.StmtBlock))
+	switch els := node.Else.(type) {
+	case *resolver.StmtIf:
+		{
+			g.genIf(els, "} else if")
+		}
+	case *resolver.StmtBlock:
+		{
+			g.line("} else {")
+			g.genBody(els)
+			g.line("}")
+		}
+	default:
+		{
+			g.line("}")
+		}
+	}
+}
+
+var binaryOps = map[resolver.BinaryOperator]string{
+	resolver.ADD: "+",
+	resolver.SUB: "-",
+	resolver.MUL: "*",
+	resolver.DIV: "/",
+	resolver.AND: "&&",
+	resolver.OR:  "||",
+	resolver.EQ:  "==",
+	resolver.NE:  "!=",
+	resolver.LT:  "<",
+	resolver.LE:  "<=",
+	resolver.GT:  ">",
+	resolver.GE:  ">=",
+}
+
+var unaryOps = map[resolver.UnaryOperator]string{
+	resolver.DEREF: "*",
+	resolver.REFER: "&",
+	resolver.MINUS: "-",
+	resolver.NOT:   "!",
+}
+
+func (g *generator) genExpr(expr resolver.ExprNode) string {
+	switch node := expr.(type) {
+	case *resolver.ExprInt:
+		{
+			return node.Value
+		}
+	case *resolver.ExprBool:
+		{
+			if node.Value {
+				return "true"
+			}
+			return "false"
+		}
+	case *resolver.ExprString:
+		{
+			return quote(node.Value)
+		}
+	case *resolver.ExprIdentifier:
+		{
+			return name(node.Name)
+		}
+	case *resolver.ExprField:
+		{
+			if g.table.TypeOf(node.Expr).Kind == types.TYPE_PTR {
+				return fmt.Sprintf("%s->%s", g.genExpr(node.Expr), name(node.Name))
+			}
+			return fmt.Sprintf("%s.%s", g.genExpr(node.Expr), name(node.Name))
+		}
+	case *resolver.ExprUnary:
+		{
+			return unaryOps[node.Op] + g.genExpr(node.Right)
+		}
+	case *resolver.ExprBinary:
+		{
+			if node.Op == resolver.AND || node.Op == resolver.OR {
+				return fmt.Sprintf("(%s %s %s)", g.genExpr(node.Left), binaryOps[node.Op], g.genExpr(node.Right))
+			}
+			operands := []resolver.ExprNode{node.Left, node.Right}
+			values, stores := g.ordered(operands, []*types.Type{g.table.TypeOf(node.Left), g.table.TypeOf(node.Right)})
+			return sequence(stores, arithmetic(node.Op, g.table.TypeOf(node), values[0], values[1]))
+		}
+	case *resolver.ExprAssign:
+		{
+			return fmt.Sprintf("%s = %s", g.genExpr(node.Left), g.genExpr(node.Right))
+		}
+	case *resolver.ExprCall:
+		{
+			params := node.Obj.Scope.QueryObjByKind(scope.PARAM)
+			exprs := make([]resolver.ExprNode, 0, len(node.Args))
+			typs := make([]*types.Type, 0, len(node.Args))
+			for i, arg := range node.Args {
+				exprs = append(exprs, arg.Expr)
+				typs = append(typs, params[i].Type)
+			}
+			args, stores := g.ordered(exprs, typs)
+			return sequence(stores, fmt.Sprintf("%s(%s)", node.Obj.Link, strings.Join(args, ", ")))
+		}
+	case *resolver.ExprCompound:
+		{
+			exprs := make([]resolver.ExprNode, 0, len(node.Fields))
+			typs := make([]*types.Type, 0, len(node.Fields))
+			for _, field := range node.Fields {
+				exprs = append(exprs, field.Expr)
+				typs = append(typs, node.Type.Field(field.Name).Type)
+			}
+			values, stores := g.ordered(exprs, typs)
+			fields := make([]string, 0, len(node.Fields))
+			for i, field := range node.Fields {
+				fields = append(fields, fmt.Sprintf(".%s = %s", name(field.Name), values[i]))
+			}
+			return sequence(stores, fmt.Sprintf("(%s){%s}", cType(node.Type), strings.Join(fields, ", ")))
+		}
+	}
+	panic(fmt.Sprintf("Unhandled %T or unreachable", expr))
+}
+
+// arithmetic prints a binary operation of type 'typ'. Integers wrap to the
+// width of their type like in the other backends: C would promote narrow
+// operands to int and leaves signed overflow undefined, so sums, differences
+// and products are computed unsigned and converted back.
+func arithmetic(op resolver.BinaryOperator, typ *types.Type, left string, right string) string {
+	switch op {
+	case resolver.ADD, resolver.SUB, resolver.MUL:
+		{
+			unsigned := "uint32_t"
+			if typ.Size == 8 {
+				unsigned = "uint64_t"
+			}
+			return fmt.Sprintf("(%s)((%s)%s %s (%s)%s)", cType(typ), unsigned, left, binaryOps[op], unsigned, right)
+		}
+	case resolver.DIV:
+		{
+			if typ.Size < 8 {
+				return fmt.Sprintf("(%s)((int64_t)%s / %s)", cType(typ), left, right)
+			}
+		}
+	}
+	return fmt.Sprintf("(%s %s %s)", left, binaryOps[op], right)
+}
+
+func quote(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case c == '\n':
+			sb.WriteString("\\n")
+		case c == '\t':
+			sb.WriteString("\\t")
+		case c < 0x20 || c >= 0x7f:
+			fmt.Fprintf(&sb, "\\%03o", c)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
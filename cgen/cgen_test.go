@@ -0,0 +1,81 @@
+package cgen_test
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/s0h1s2/compiler"
+	"github.com/s0h1s2/interp"
+)
+
+// The C translation computes what the interpreter does: narrow integers wrap,
+// overflow isn't undefined and operands are evaluated left to right.
+func TestSameAsInterpreter(t *testing.T) {
+	cc, err := exec.LookPath("cc")
+	if err != nil {
+		t.Skip("no C compiler")
+	}
+	src := `
+extern fn printf(fmt:string, a:i64):i32;
+fn id(x:i64):i64 {
+  printf("%ld ", x);
+  return x;
+}
+fn add(a:i64, b:i64):i64 {
+  return a + b;
+}
+fn bump(p:*i64):i64 {
+  *p = *p + 1;
+  return *p;
+}
+fn main():i32 {
+  let a:i8 = 100;
+  if a + a > 0 {
+    printf("%ld", 1);
+  } else {
+    printf("%ld", 0);
+  }
+  let s:i16 = 300;
+  let m:i16 = s * s;
+  printf(" %ld", m);
+  let big:i32 = 2147483647;
+  let w:i32 = big + 1;
+  printf(" %ld ", w);
+  printf("%ld ", add(id(1), id(2)));
+  printf("%ld ", id(3) + id(4));
+  let g:i64 = 0;
+  printf("%ld", add(g, bump(&g)));
+  return 0;
+}
+`
+	s := compiler.NewSession()
+	if !s.Parse("test.des", []byte(src)) || !s.Check() {
+		t.Fatalf("errors %v", s.Diagnostics.Errors())
+	}
+	var want bytes.Buffer
+	if _, err := interp.Run(s.Decls, s.Table, &want); err != nil {
+		t.Fatal(err)
+	}
+	out, ok := s.Emit(compiler.OUTPUT_C)
+	if !ok {
+		t.Fatalf("errors %v", s.Diagnostics.Errors())
+	}
+	dir := t.TempDir()
+	source, binary := filepath.Join(dir, "test.c"), filepath.Join(dir, "test")
+	if err := os.WriteFile(source, out, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if msg, err := exec.Command(cc, "-w", "-o", binary, source).CombinedOutput(); err != nil {
+		t.Fatalf("%s: %s\n%s", err, msg, out)
+	}
+	got, err := exec.Command(binary).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want.String() {
+		t.Errorf("C prints %q, the interpreter %q\n%s", got, want.String(), out)
+	}
+}
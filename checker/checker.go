@@ -10,19 +10,31 @@ import (
 )
 
 type checker struct {
-	handler          *error.DiagnosticBag
-	symTable         *resolver.Table
-	currentFun       *resolver.DeclFunction
-	isFunReturnValue bool
+	handler    *error.DiagnosticBag
+	symTable   *resolver.Table
+	currentFun *resolver.DeclFunction
+	loops      int // loops around the statement being checked
 }
 
 func Check(decls []resolver.DeclNode, table *resolver.Table, handler *error.DiagnosticBag) {
-	println("----CHECKER----")
 	c := &checker{handler: handler, symTable: table}
 	for _, decl := range decls {
 		c.checkDecl(decl)
 	}
 }
+
+// CheckStmt checks a statement outside of any function and returns the type of
+// an expression statement.
+func CheckStmt(stmt resolver.StmtNode, table *resolver.Table, handler *error.DiagnosticBag) *types.Type {
+	c := &checker{handler: handler, symTable: table}
+	return c.checkStmt(stmt)
+}
+
+// CheckExpr checks an expression whose integer literals default to 'expectedType'.
+func CheckExpr(expr resolver.ExprNode, expectedType *types.Type, table *resolver.Table, handler *error.DiagnosticBag) *types.Type {
+	c := &checker{handler: handler, symTable: table}
+	return c.checkExpr(expr, expectedType)
+}
 func (c *checker) areTypesEqual(type1 *types.Type, type2 *types.Type) bool {
 	if type1 == nil || type2 == nil {
 		return false
@@ -50,13 +62,72 @@ func (c *checker) checkDecl(decl resolver.DeclNode) {
 }
 func (c *checker) checkFunction(fun *resolver.DeclFunction) {
 	c.currentFun = fun
-	c.isFunReturnValue = false
 	c.checkStmt(fun.Body)
-	if types.TYPE_VOID != fun.ReturnType.Kind && !c.isFunReturnValue {
+	if types.TYPE_VOID != fun.ReturnType.Kind && !returns(fun.Body) {
 		c.handler.ReportError(fun.GetPos(), "Function '%s' expected to return '%s' type", fun.Name, fun.ReturnType.TypeName)
 	}
 
 }
+func (c *checker) checkCond(cond resolver.ExprNode, keyword string) {
+	typ := c.checkExpr(cond, nil)
+	if typ != nil && typ.Kind != types.TYPE_BOOL {
+		c.handler.ReportError(cond.GetPos(), "Condition of '%s' must be 'bool' but got '%s'", keyword, typ.TypeName)
+	}
+}
+func (c *checker) checkLoop(body resolver.StmtNode) {
+	c.loops++
+	c.checkStmt(body)
+	c.loops--
+}
+
+// returns reports whether every path through 'stmt' ends in a return.
+func returns(stmt resolver.StmtNode) bool {
+	switch node := stmt.(type) {
+	case *resolver.StmtReturn:
+		return true
+	case *resolver.StmtBlock:
+		{
+			for _, stmt := range node.Body {
+				if returns(stmt) {
+					return true
+				}
+			}
+		}
+	case *resolver.StmtIf:
+		return node.Else != nil && returns(node.Then) && returns(node.Else)
+	case *resolver.StmtWhile:
+		return forever(node.Cond) && !breaks(node.Body)
+	case *resolver.StmtFor:
+		return forever(node.Cond) && !breaks(node.Body)
+	}
+	return false
+}
+
+// forever reports whether a loop condition never fails, which a missing
+// condition doesn't either.
+func forever(cond resolver.ExprNode) bool {
+	if cond == nil {
+		return true
+	}
+	value, ok := cond.(*resolver.ExprBool)
+	return ok && value.Value
+}
+
+// breaks reports whether 'body' breaks out of the loop it belongs to, breaks
+// of nested loops leave only those.
+func breaks(body resolver.StmtNode) bool {
+	found := false
+	resolver.Inspect(body, func(node resolver.Node) bool {
+		switch node.(type) {
+		case *resolver.StmtBreak:
+			found = true
+		case *resolver.StmtWhile, *resolver.StmtFor:
+			return false
+		}
+		return !found
+	})
+	return found
+}
 func (c *checker) checkStmt(stmt resolver.StmtNode) *types.Type {
 	switch node := stmt.(type) {
 	case *resolver.StmtBlock:
@@ -65,9 +136,49 @@ func (c *checker) checkStmt(stmt resolver.StmtNode) *types.Type {
 				c.checkStmt(stmt)
 			}
 		}
+	case *resolver.StmtIf:
+		{
+			c.checkCond(node.Cond, "if")
+			c.checkStmt(node.Then)
+			c.checkStmt(node.Else)
+		}
+	case *resolver.StmtWhile:
+		{
+			c.checkCond(node.Cond, "while")
+			c.checkLoop(node.Body)
+		}
+	case *resolver.StmtFor:
+		{
+			c.checkStmt(node.Init)
+			if node.Cond != nil {
+				c.checkCond(node.Cond, "for")
+			}
+			if node.Post != nil {
+				c.checkExpr(node.Post, nil)
+			}
+			c.checkLoop(node.Body)
+		}
+	case *resolver.StmtBreak:
+		{
+			if c.loops == 0 {
+				c.handler.ReportError(node.GetPos(), "Can't break outside of a loop")
+			}
+		}
+	case *resolver.StmtContinue:
+		{
+			if c.loops == 0 {
+				c.handler.ReportError(node.GetPos(), "Can't continue outside of a loop")
+			}
+		}
 	case *resolver.StmtReturn:
 		{
-			c.isFunReturnValue = true
+			if c.currentFun == nil {
+				c.handler.ReportError(node.GetPos(), "Can't return outside of a function")
+				return nil
+			}
+			if node.Result == nil && c.currentFun.ReturnType.Kind != types.TYPE_VOID {
+				c.handler.ReportError(node.GetPos(), "Expected '%s' but got nothing in function return", c.currentFun.ReturnType.TypeName)
+			}
 			if node.Result != nil {
 				resultType := c.checkExpr(node.Result, c.currentFun.ReturnType)
 				if !c.areTypesEqual(resultType, c.currentFun.ReturnType) {
@@ -113,17 +224,24 @@ func (c *checker) checkExpr(expr resolver.ExprNode, expectedType *types.Type) *t
 		}
 	case *resolver.ExprBinary:
 		{
-			left := c.checkExpr(node.Left, nil)
-			right := c.checkExpr(node.Right, nil)
+			if resolver.IsCompare(node.Op) {
+				expectedType = nil
+			}
+			left := c.checkExpr(node.Left, expectedType)
+			right := c.checkExpr(node.Right, left)
 			if left.Kind != types.TYPE_INT || right.Kind != types.TYPE_INT {
 				c.handler.ReportError(node.GetPos(), "types must be integers when doing arithmetic")
 			}
-			return left
+			node.Type = left
+			if resolver.IsCompare(node.Op) {
+				node.Type = c.symTable.Symbols.GetObj("bool").Type
+			}
+			return node.Type
 		}
 	case *resolver.ExprCompound:
 		{
 			for _, field := range node.Fields {
-				fieldType := c.symTable.Symbols.GetObj(node.Type.TypeName).Scope.GetObj(field.Name).Type
+				fieldType := node.Obj.Scope.GetObj(field.Name).Type
 				checkedField := c.checkExpr(field.Expr, fieldType)
 				if !c.areTypesEqual(fieldType, checkedField) {
 					c.handler.ReportError(node.Pos, "Expcted '%s' type but got '%s' type in struct compound", fieldType.TypeName, checkedField.TypeName)
@@ -133,7 +251,7 @@ func (c *checker) checkExpr(expr resolver.ExprNode, expectedType *types.Type) *t
 		}
 	case *resolver.ExprCall:
 		{
-			fnObj := c.symTable.Symbols.GetObj(node.Name)
+			fnObj := node.Obj
 			params := fnObj.Scope.QueryObjByKind(scope.PARAM)
 			for i, arg := range node.Args {
 				argType := c.checkExpr(arg.Expr, params[i].Type)
@@ -142,6 +260,7 @@ func (c *checker) checkExpr(expr resolver.ExprNode, expectedType *types.Type) *t
 					return nil
 				}
 			}
+			node.Type = fnObj.Type
 			return fnObj.Type
 		}
 	case *resolver.ExprUnary:
@@ -157,8 +276,15 @@ func (c *checker) checkExpr(expr resolver.ExprNode, expectedType *types.Type) *t
 				}
 				if !c.isPtrType(node.Type) {
 					c.handler.ReportError(node.Pos, "'%s' type must be a pointer type", node.Type.TypeName)
+				} else {
+					typeResult = node.Type.Base
 				}
-				typeResult = expectedType
+			} else if node.Op == resolver.NOT {
+				operand := c.checkExpr(node.Right, nil)
+				if operand.Kind != types.TYPE_BOOL {
+					c.handler.ReportError(node.Pos, "Operand of '!' must be 'bool' but got '%s'", operand.TypeName)
+				}
+				typeResult = c.symTable.Symbols.GetObj("bool").Type
 			}
 		}
 	case *resolver.ExprArg:
@@ -167,6 +293,10 @@ func (c *checker) checkExpr(expr resolver.ExprNode, expectedType *types.Type) *t
 	case *resolver.ExprInt:
 		{
 			typeResult = c.symTable.Symbols.GetObj("i8").Type
+			if expectedType != nil && expectedType.Kind == types.TYPE_INT {
+				typeResult = expectedType
+			}
+			node.Type = typeResult
 		}
 	case *resolver.ExprBool:
 		{
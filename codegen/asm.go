@@ -0,0 +1,186 @@
+package codegen
+
+import (
+	"fmt"
+	"strings"
+)
+
+var regNames = [...][4]string{
+	RAX: {"al", "ax", "eax", "rax"},
+	RCX: {"cl", "cx", "ecx", "rcx"},
+	RDX: {"dl", "dx", "edx", "rdx"},
+	RBX: {"bl", "bx", "ebx", "rbx"},
+	RSP: {"spl", "sp", "esp", "rsp"},
+	RBP: {"bpl", "bp", "ebp", "rbp"},
+	RSI: {"sil", "si", "esi", "rsi"},
+	RDI: {"dil", "di", "edi", "rdi"},
+	R8:  {"r8b", "r8w", "r8d", "r8"},
+	R9:  {"r9b", "r9w", "r9d", "r9"},
+	R10: {"r10b", "r10w", "r10d", "r10"},
+	R11: {"r11b", "r11w", "r11d", "r11"},
+	R12: {"r12b", "r12w", "r12d", "r12"},
+	R13: {"r13b", "r13w", "r13d", "r13"},
+	R14: {"r14b", "r14w", "r14d", "r14"},
+	R15: {"r15b", "r15w", "r15d", "r15"},
+}
+
+var opNames = [...]string{
+	OP_MOV:       "mov",
+	OP_LEA:       "lea",
+	OP_ADD:       "add",
+	OP_SUB:       "sub",
+	OP_IMUL:      "imul",
+	OP_CQO:       "cqto",
+	OP_IDIV:      "idiv",
+	OP_CMP:       "cmp",
+	OP_TEST:      "test",
+	OP_SETE:      "sete",
+	OP_SETNE:     "setne",
+	OP_SETL:      "setl",
+	OP_SETLE:     "setle",
+	OP_SETG:      "setg",
+	OP_SETGE:     "setge",
+	OP_PUSH:      "push",
+	OP_POP:       "pop",
+	OP_CALL:      "call",
+	OP_RET:       "ret",
+	OP_LEAVE:     "leave",
+	OP_JMP:       "jmp",
+	OP_JE:        "je",
+	OP_JNE:       "jne",
+	OP_REP_MOVSB: "rep movsb",
+}
+
+func sizeIndex(size int) int {
+	switch size {
+	case 1:
+		return 0
+	case 2:
+		return 1
+	case 4:
+		return 2
+	}
+	return 3
+}
+func sizeSuffix(size int) string {
+	return [...]string{"b", "w", "l", "q"}[sizeIndex(size)]
+}
+
+func formatOperand(op Operand, size int) string {
+	switch op.Kind {
+	case OPERAND_REG:
+		return "%" + regNames[op.Reg][sizeIndex(size)]
+	case OPERAND_IMM:
+		return fmt.Sprintf("$%d", op.Imm)
+	case OPERAND_MEM:
+		if op.Imm == 0 {
+			return fmt.Sprintf("(%%%s)", regNames[op.Reg][3])
+		}
+		return fmt.Sprintf("%d(%%%s)", op.Imm, regNames[op.Reg][3])
+	case OPERAND_SYM:
+		return op.Sym + "(%rip)"
+	}
+	return op.Sym
+}
+
+func formatInstr(instr Instr) string {
+	switch instr.Op {
+	case OP_LABEL:
+		return instr.Args[0].Sym + ":"
+	case OP_MOVSX, OP_MOVZX:
+		if instr.Op == OP_MOVZX && instr.Size == 4 {
+			return fmt.Sprintf("\tmovl %s, %s", formatOperand(instr.Args[0], 4), formatOperand(instr.Args[1], 4))
+		}
+		name := "movs"
+		if instr.Op == OP_MOVZX {
+			name = "movz"
+		}
+		return fmt.Sprintf("\t%s%sq %s, %s", name, sizeSuffix(instr.Size), formatOperand(instr.Args[0], instr.Size), formatOperand(instr.Args[1], 8))
+	case OP_SETE, OP_SETNE, OP_SETL, OP_SETLE, OP_SETG, OP_SETGE:
+		return fmt.Sprintf("\t%s %s", opNames[instr.Op], formatOperand(instr.Args[0], 1))
+	case OP_CALL, OP_JMP, OP_JE, OP_JNE, OP_RET, OP_LEAVE, OP_REP_MOVSB, OP_CQO:
+		if len(instr.Args) == 0 {
+			return "\t" + opNames[instr.Op]
+		}
+		return fmt.Sprintf("\t%s %s", opNames[instr.Op], instr.Args[0].Sym)
+	}
+	name := opNames[instr.Op] + sizeSuffix(instr.Size)
+	if instr.Op == OP_MOV && instr.Args[0].Kind == OPERAND_IMM && !fitsInt32(instr.Args[0].Imm) {
+		name = "movabsq"
+	}
+	args := make([]string, 0, len(instr.Args))
+	for _, arg := range instr.Args {
+		args = append(args, formatOperand(arg, instr.Size))
+	}
+	return fmt.Sprintf("\t%s %s", name, strings.Join(args, ", "))
+}
+
+func fitsInt32(v int64) bool {
+	return v >= -1<<31 && v < 1<<31
+}
+
+// quoteString escapes a literal for the .string directive.
+func quoteString(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case c < 0x20 || c >= 0x7f:
+			fmt.Fprintf(&sb, "\\%03o", c)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// Asm renders the program as GNU as AT&T syntax.
+func (p *Program) Asm() string {
+	var sb strings.Builder
+	if len(p.Strings) > 0 {
+		sb.WriteString("\t.section .rodata\n")
+		for i, str := range p.Strings {
+			fmt.Fprintf(&sb, ".Lstr%d:\n\t.string %s\n", i, quoteString(str))
+		}
+	}
+	debug := p.Source != "" && len(p.Functions) > 0
+	sb.WriteString("\t.text\n")
+	files := p.sourceFiles()
+	if debug {
+		for i, name := range files.names {
+			fmt.Fprintf(&sb, "\t.file %d %s\n", i+1, quoteString(name))
+		}
+	}
+	for _, fn := range p.Functions {
+		if fn.Public {
+			fmt.Fprintf(&sb, "\t.globl %s\n", fn.Name)
+		}
+		fmt.Fprintf(&sb, "\t.type %s, @function\n%s:\n", fn.Name, fn.Name)
+		line, column := 0, 0
+		for _, instr := range fn.Instrs {
+			if debug && instr.Op != OP_LABEL && instr.Pos.Line != 0 && (instr.Pos.Line != line || instr.Pos.Column != column) {
+				line, column = instr.Pos.Line, instr.Pos.Column
+				fmt.Fprintf(&sb, "\t.loc %d %d %d\n", files.of(instr.Pos.File), line, column)
+			}
+			sb.WriteString(formatInstr(instr))
+			sb.WriteByte('\n')
+		}
+		if debug {
+			fmt.Fprintf(&sb, "%s:\n", endLabel(fn.Name))
+		}
+		fmt.Fprintf(&sb, "\t.size %s, .-%s\n", fn.Name, fn.Name)
+	}
+	if debug {
+		debugAbbrev().asm(&sb)
+		p.debugInfo().asm(&sb)
+		// The assembler fills .debug_line from the .loc directives.
+		fmt.Fprintf(&sb, "\t.section .debug_line,\"\",@progbits\n%s:\n", sectionLabel(".debug_line"))
+	}
+	sb.WriteString("\t.section .note.GNU-stack,\"\",@progbits\n")
+	return sb.String()
+}
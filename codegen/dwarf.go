@@ -0,0 +1,517 @@
+package codegen
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/s0h1s2/types"
+)
+
+// Constants from the DWARF 4 standard.
+const (
+	DW_TAG_formal_parameter = 0x05
+	DW_TAG_member           = 0x0d
+	DW_TAG_pointer_type     = 0x0f
+	DW_TAG_compile_unit     = 0x11
+	DW_TAG_structure_type   = 0x13
+	DW_TAG_typedef          = 0x16
+	DW_TAG_base_type        = 0x24
+	DW_TAG_subprogram       = 0x2e
+	DW_TAG_variable         = 0x34
+
+	DW_AT_location             = 0x02
+	DW_AT_name                 = 0x03
+	DW_AT_byte_size            = 0x0b
+	DW_AT_stmt_list            = 0x10
+	DW_AT_low_pc               = 0x11
+	DW_AT_high_pc              = 0x12
+	DW_AT_language             = 0x13
+	DW_AT_comp_dir             = 0x1b
+	DW_AT_producer             = 0x25
+	DW_AT_data_member_location = 0x38
+	DW_AT_decl_file            = 0x3a
+	DW_AT_decl_line            = 0x3b
+	DW_AT_encoding             = 0x3e
+	DW_AT_external             = 0x3f
+	DW_AT_frame_base           = 0x40
+	DW_AT_type                 = 0x49
+	DW_AT_linkage_name         = 0x6e
+
+	DW_FORM_addr       = 0x01
+	DW_FORM_data1      = 0x0b
+	DW_FORM_data2      = 0x05
+	DW_FORM_data8      = 0x07
+	DW_FORM_flag       = 0x0c
+	DW_FORM_string     = 0x08
+	DW_FORM_udata      = 0x0f
+	DW_FORM_ref4       = 0x13
+	DW_FORM_sec_offset = 0x17
+	DW_FORM_exprloc    = 0x18
+
+	DW_ATE_boolean     = 0x02
+	DW_ATE_signed      = 0x05
+	DW_ATE_signed_char = 0x06
+
+	DW_OP_reg6  = 0x56 // %rbp
+	DW_OP_fbreg = 0x91
+
+	DW_LANG_C99 = 0x0c
+
+	DW_LNS_copy         = 0x01
+	DW_LNS_advance_pc   = 0x02
+	DW_LNS_advance_line = 0x03
+	DW_LNS_set_file     = 0x04
+	DW_LNS_set_column   = 0x05
+
+	DW_LNE_end_sequence = 0x01
+	DW_LNE_set_address  = 0x02
+)
+
+const producer = "dennis"
+
+// Abbreviation codes, see abbrevTable.
+const (
+	abbrevCompileUnit = iota + 1
+	abbrevSubprogram
+	abbrevSubprogramVoid
+	abbrevParam
+	abbrevVariable
+	abbrevBaseType
+	abbrevPointer
+	abbrevVoidPointer
+	abbrevStruct
+	abbrevMember
+	abbrevTypedef
+)
+
+type abbrev struct {
+	tag      byte
+	children bool
+	attrs    [][2]byte // attribute and form
+}
+
+var abbrevTable = []abbrev{
+	abbrevCompileUnit: {DW_TAG_compile_unit, true, [][2]byte{
+		{DW_AT_producer, DW_FORM_string}, {DW_AT_language, DW_FORM_data2}, {DW_AT_name, DW_FORM_string},
+		{DW_AT_comp_dir, DW_FORM_string}, {DW_AT_low_pc, DW_FORM_addr}, {DW_AT_high_pc, DW_FORM_data8},
+		{DW_AT_stmt_list, DW_FORM_sec_offset},
+	}},
+	abbrevSubprogram: {DW_TAG_subprogram, true, [][2]byte{
+		{DW_AT_name, DW_FORM_string}, {DW_AT_linkage_name, DW_FORM_string}, {DW_AT_decl_file, DW_FORM_data1},
+		{DW_AT_decl_line, DW_FORM_udata}, {DW_AT_type, DW_FORM_ref4}, {DW_AT_low_pc, DW_FORM_addr},
+		{DW_AT_high_pc, DW_FORM_data8}, {DW_AT_frame_base, DW_FORM_exprloc}, {DW_AT_external, DW_FORM_flag},
+	}},
+	abbrevSubprogramVoid: {DW_TAG_subprogram, true, [][2]byte{
+		{DW_AT_name, DW_FORM_string}, {DW_AT_linkage_name, DW_FORM_string}, {DW_AT_decl_file, DW_FORM_data1},
+		{DW_AT_decl_line, DW_FORM_udata}, {DW_AT_low_pc, DW_FORM_addr}, {DW_AT_high_pc, DW_FORM_data8},
+		{DW_AT_frame_base, DW_FORM_exprloc}, {DW_AT_external, DW_FORM_flag},
+	}},
+	abbrevParam: {DW_TAG_formal_parameter, false, [][2]byte{
+		{DW_AT_name, DW_FORM_string}, {DW_AT_decl_file, DW_FORM_data1}, {DW_AT_decl_line, DW_FORM_udata},
+		{DW_AT_type, DW_FORM_ref4}, {DW_AT_location, DW_FORM_exprloc},
+	}},
+	abbrevVariable: {DW_TAG_variable, false, [][2]byte{
+		{DW_AT_name, DW_FORM_string}, {DW_AT_decl_file, DW_FORM_data1}, {DW_AT_decl_line, DW_FORM_udata},
+		{DW_AT_type, DW_FORM_ref4}, {DW_AT_location, DW_FORM_exprloc},
+	}},
+	abbrevBaseType: {DW_TAG_base_type, false, [][2]byte{
+		{DW_AT_name, DW_FORM_string}, {DW_AT_encoding, DW_FORM_data1}, {DW_AT_byte_size, DW_FORM_data1},
+	}},
+	abbrevPointer: {DW_TAG_pointer_type, false, [][2]byte{
+		{DW_AT_byte_size, DW_FORM_data1}, {DW_AT_type, DW_FORM_ref4},
+	}},
+	abbrevVoidPointer: {DW_TAG_pointer_type, false, [][2]byte{
+		{DW_AT_byte_size, DW_FORM_data1},
+	}},
+	abbrevStruct: {DW_TAG_structure_type, true, [][2]byte{
+		{DW_AT_name, DW_FORM_string}, {DW_AT_byte_size, DW_FORM_udata},
+	}},
+	abbrevMember: {DW_TAG_member, false, [][2]byte{
+		{DW_AT_name, DW_FORM_string}, {DW_AT_type, DW_FORM_ref4}, {DW_AT_data_member_location, DW_FORM_udata},
+	}},
+	abbrevTypedef: {DW_TAG_typedef, false, [][2]byte{
+		{DW_AT_name, DW_FORM_string}, {DW_AT_type, DW_FORM_ref4},
+	}},
+}
+
+type debugRelocKind int
+
+const (
+	DEBUG_ADDR   debugRelocKind = iota // 8 byte address of Sym
+	DEBUG_LENGTH                       // 8 byte distance from Sym to End
+	DEBUG_OFFSET                       // 4 byte offset to the start of section Sym
+)
+
+type debugReloc struct {
+	Offset int
+	Kind   debugRelocKind
+	Sym    string
+	End    string
+}
+
+// debugSection holds DWARF data whose addresses are filled in by the assembler
+// or the object writer.
+type debugSection struct {
+	Name   string
+	Data   []byte
+	Relocs []debugReloc
+}
+
+func (d *debugSection) byte(b ...byte) {
+	d.Data = append(d.Data, b...)
+}
+func (d *debugSection) u16(v uint16) {
+	d.Data = binary.LittleEndian.AppendUint16(d.Data, v)
+}
+func (d *debugSection) u32(v uint32) {
+	d.Data = binary.LittleEndian.AppendUint32(d.Data, v)
+}
+func (d *debugSection) uleb(v uint64) {
+	d.Data = appendUleb(d.Data, v)
+}
+func (d *debugSection) sleb(v int64) {
+	d.Data = appendSleb(d.Data, v)
+}
+func (d *debugSection) string(s string) {
+	d.Data = append(d.Data, s...)
+	d.Data = append(d.Data, 0)
+}
+func (d *debugSection) reloc(kind debugRelocKind, sym string, end string) {
+	d.Relocs = append(d.Relocs, debugReloc{Offset: len(d.Data), Kind: kind, Sym: sym, End: end})
+	if kind == DEBUG_OFFSET {
+		d.u32(0)
+		return
+	}
+	d.Data = binary.LittleEndian.AppendUint64(d.Data, 0)
+}
+
+func appendUleb(data []byte, v uint64) []byte {
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+		if v == 0 {
+			return append(data, b)
+		}
+		data = append(data, b|0x80)
+	}
+}
+func appendSleb(data []byte, v int64) []byte {
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+		if v == 0 && b&0x40 == 0 || v == -1 && b&0x40 != 0 {
+			return append(data, b)
+		}
+		data = append(data, b|0x80)
+	}
+}
+
+// endLabel marks the end of a function's code.
+func endLabel(fn string) string {
+	return fmt.Sprintf(".L%s_end", fn)
+}
+
+// sectionLabel marks the start of a debug section in assembly output.
+func sectionLabel(name string) string {
+	return ".L" + name[1:] + "0"
+}
+
+// asm renders the section as data directives.
+func (d *debugSection) asm(sb *strings.Builder) {
+	fmt.Fprintf(sb, "\t.section %s,\"\",@progbits\n%s:\n", d.Name, sectionLabel(d.Name))
+	relocs := make(map[int]debugReloc)
+	for _, r := range d.Relocs {
+		relocs[r.Offset] = r
+	}
+	for i := 0; i < len(d.Data); {
+		if r, ok := relocs[i]; ok {
+			switch r.Kind {
+			case DEBUG_ADDR:
+				fmt.Fprintf(sb, "\t.quad %s\n", r.Sym)
+				i += 8
+			case DEBUG_LENGTH:
+				fmt.Fprintf(sb, "\t.quad %s-%s\n", r.End, r.Sym)
+				i += 8
+			case DEBUG_OFFSET:
+				fmt.Fprintf(sb, "\t.long %s\n", sectionLabel(r.Sym))
+				i += 4
+			}
+			continue
+		}
+		sb.WriteString("\t.byte ")
+		for j := 0; i < len(d.Data) && j < 16; j++ {
+			if _, ok := relocs[i]; ok {
+				break
+			}
+			if j > 0 {
+				sb.WriteString(", ")
+			}
+			fmt.Fprintf(sb, "%d", d.Data[i])
+			i++
+		}
+		sb.WriteByte('\n')
+	}
+}
+
+func debugAbbrev() *debugSection {
+	sec := &debugSection{Name: ".debug_abbrev"}
+	for code := 1; code < len(abbrevTable); code++ {
+		a := abbrevTable[code]
+		sec.uleb(uint64(code))
+		sec.uleb(uint64(a.tag))
+		if a.children {
+			sec.byte(1)
+		} else {
+			sec.byte(0)
+		}
+		for _, attr := range a.attrs {
+			sec.uleb(uint64(attr[0]))
+			sec.uleb(uint64(attr[1]))
+		}
+		sec.byte(0, 0)
+	}
+	sec.byte(0)
+	return sec
+}
+
+// infoWriter lays out .debug_info. Type entries are written after the
+// functions, references to them are patched once their offsets are known.
+type infoWriter struct {
+	sec     *debugSection
+	types   map[*types.Type]int // type -> offset of its entry, -1 while queued
+	queue   []*types.Type
+	pending map[int]*types.Type // offset of a ref4 -> referenced type
+}
+
+func (w *infoWriter) typeRef(t *types.Type) {
+	if _, ok := w.types[t]; !ok {
+		w.types[t] = -1
+		w.queue = append(w.queue, t)
+	}
+	w.pending[len(w.sec.Data)] = t
+	w.sec.u32(0)
+}
+
+func (w *infoWriter) writeType(t *types.Type) {
+	sec := w.sec
+	w.types[t] = len(sec.Data)
+	switch t.Kind {
+	case types.TYPE_INT:
+		{
+			sec.uleb(abbrevBaseType)
+			sec.string(t.TypeName)
+			sec.byte(DW_ATE_signed, byte(t.Size))
+		}
+	case types.TYPE_BOOL:
+		{
+			sec.uleb(abbrevBaseType)
+			sec.string(t.TypeName)
+			sec.byte(DW_ATE_boolean, byte(t.Size))
+		}
+	case types.TYPE_STRING:
+		{
+			// Strings are NUL terminated char pointers.
+			sec.uleb(abbrevTypedef)
+			sec.string(t.TypeName)
+			sec.u32(uint32(len(sec.Data) + 4))
+			sec.uleb(abbrevPointer)
+			sec.byte(8)
+			sec.u32(uint32(len(sec.Data) + 4))
+			sec.uleb(abbrevBaseType)
+			sec.string("char")
+			sec.byte(DW_ATE_signed_char, 1)
+		}
+	case types.TYPE_PTR:
+		{
+			if t.Base.Kind == types.TYPE_VOID {
+				sec.uleb(abbrevVoidPointer)
+				sec.byte(8)
+				return
+			}
+			sec.uleb(abbrevPointer)
+			sec.byte(8)
+			w.typeRef(t.Base)
+		}
+	case types.TYPE_STRUCT:
+		{
+			sec.uleb(abbrevStruct)
+			sec.string(t.TypeName)
+			sec.uleb(t.Size)
+			for _, field := range t.Fields {
+				sec.uleb(abbrevMember)
+				sec.string(field.Name)
+				w.typeRef(field.Type)
+				sec.uleb(field.Offset)
+			}
+			sec.byte(0)
+		}
+	}
+}
+
+// sourceFiles numbers the files the program's positions refer to from 1, Source
+// first. Positions without a file name belong to Source.
+type sourceFiles struct {
+	names []string
+	index map[string]int
+}
+
+func (p *Program) sourceFiles() *sourceFiles {
+	files := &sourceFiles{index: make(map[string]int)}
+	files.add(p.Source)
+	for _, fn := range p.Functions {
+		files.add(fn.Pos.File)
+		for _, instr := range fn.Instrs {
+			files.add(instr.Pos.File)
+		}
+	}
+	return files
+}
+
+func (f *sourceFiles) add(name string) {
+	if _, ok := f.index[name]; !ok && name != "" {
+		f.names = append(f.names, name)
+		f.index[name] = len(f.names)
+	}
+}
+
+func (f *sourceFiles) of(file string) int {
+	if file == "" {
+		return 1
+	}
+	return f.index[file]
+}
+
+// debugInfo describes the program's functions, variables and types.
+func (p *Program) debugInfo() *debugSection {
+	files := p.sourceFiles()
+	sec := &debugSection{Name: ".debug_info"}
+	w := &infoWriter{sec: sec, types: make(map[*types.Type]int), pending: make(map[int]*types.Type)}
+	sec.u32(0) // unit length, patched below
+	sec.u16(4)
+	sec.reloc(DEBUG_OFFSET, ".debug_abbrev", "")
+	sec.byte(8)
+
+	first, last := p.Functions[0].Name, p.Functions[len(p.Functions)-1].Name
+	sec.uleb(abbrevCompileUnit)
+	sec.string(producer)
+	sec.u16(DW_LANG_C99)
+	sec.string(p.Source)
+	sec.string(p.Dir)
+	sec.reloc(DEBUG_ADDR, first, "")
+	sec.reloc(DEBUG_LENGTH, first, endLabel(last))
+	sec.reloc(DEBUG_OFFSET, ".debug_line", "")
+
+	for _, fn := range p.Functions {
+		void := fn.RetType == nil || fn.RetType.Kind == types.TYPE_VOID
+		if void {
+			sec.uleb(abbrevSubprogramVoid)
+		} else {
+			sec.uleb(abbrevSubprogram)
+		}
+		sec.string(fn.Source)
+		sec.string(fn.Name)
+		sec.byte(byte(files.of(fn.Pos.File)))
+		sec.uleb(uint64(fn.Pos.Line))
+		if !void {
+			w.typeRef(fn.RetType)
+		}
+		sec.reloc(DEBUG_ADDR, fn.Name, "")
+		sec.reloc(DEBUG_LENGTH, fn.Name, endLabel(fn.Name))
+		sec.uleb(1)
+		sec.byte(DW_OP_reg6)
+		if fn.Public {
+			sec.byte(1)
+		} else {
+			sec.byte(0)
+		}
+		for _, v := range fn.Vars {
+			if v.Param {
+				sec.uleb(abbrevParam)
+			} else {
+				sec.uleb(abbrevVariable)
+			}
+			sec.string(v.Name)
+			sec.byte(byte(files.of(v.Pos.File)))
+			sec.uleb(uint64(v.Pos.Line))
+			w.typeRef(v.Type)
+			loc := appendSleb([]byte{DW_OP_fbreg}, v.Offset)
+			sec.uleb(uint64(len(loc)))
+			sec.byte(loc...)
+		}
+		sec.byte(0)
+	}
+	for len(w.queue) > 0 {
+		t := w.queue[0]
+		w.queue = w.queue[1:]
+		w.writeType(t)
+	}
+	sec.byte(0)
+	for offset, t := range w.pending {
+		binary.LittleEndian.PutUint32(sec.Data[offset:], uint32(w.types[t]))
+	}
+	binary.LittleEndian.PutUint32(sec.Data, uint32(len(sec.Data)-4))
+	return sec
+}
+
+// lineRow maps the code at Offset bytes into a function to a source position.
+type lineRow struct {
+	Offset int
+	File   int
+	Line   int
+	Column int
+}
+
+// debugLine builds the line number program for the object writer, the
+// assembler derives it from .loc directives instead.
+func (p *Program) debugLine(rows map[string][]lineRow, sizes map[string]int) *debugSection {
+	sec := &debugSection{Name: ".debug_line"}
+	sec.u32(0) // unit length
+	sec.u16(4)
+	sec.u32(0) // header length
+	headerStart := len(sec.Data)
+	sec.byte(1, 1, 1)                            // minimum instruction length, maximum operations per instruction, default is_stmt
+	sec.byte(0xfb, 14, 13)                       // line base -5, line range, opcode base
+	sec.byte(0, 1, 1, 1, 1, 0, 0, 0, 1, 0, 0, 1) // standard opcode lengths
+	sec.byte(0)                                  // no include directories
+	for _, name := range p.sourceFiles().names {
+		sec.string(name)
+		sec.byte(0, 0, 0)
+	}
+	sec.byte(0)
+	binary.LittleEndian.PutUint32(sec.Data[6:], uint32(len(sec.Data)-headerStart))
+
+	for _, fn := range p.Functions {
+		sec.byte(0, 9, DW_LNE_set_address)
+		sec.reloc(DEBUG_ADDR, fn.Name, "")
+		offset, file, line, column := 0, 1, 1, 0
+		for _, row := range rows[fn.Name] {
+			if row.Offset != offset {
+				sec.byte(DW_LNS_advance_pc)
+				sec.uleb(uint64(row.Offset - offset))
+				offset = row.Offset
+			}
+			if row.File != file {
+				sec.byte(DW_LNS_set_file)
+				sec.uleb(uint64(row.File))
+				file = row.File
+			}
+			if row.Line != line {
+				sec.byte(DW_LNS_advance_line)
+				sec.sleb(int64(row.Line - line))
+				line = row.Line
+			}
+			if row.Column != column {
+				sec.byte(DW_LNS_set_column)
+				sec.uleb(uint64(row.Column))
+				column = row.Column
+			}
+			sec.byte(DW_LNS_copy)
+		}
+		sec.byte(DW_LNS_advance_pc)
+		sec.uleb(uint64(sizes[fn.Name] - offset))
+		sec.byte(0, 1, DW_LNE_end_sequence)
+	}
+	binary.LittleEndian.PutUint32(sec.Data, uint32(len(sec.Data)-4))
+	return sec
+}
@@ -0,0 +1,309 @@
+package codegen
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+type relocKind int
+
+const (
+	RELOC_CALL relocKind = iota // rel32 to a function symbol
+	RELOC_PC32                  // rip-relative rel32 to a data symbol
+)
+
+type codeReloc struct {
+	Offset int
+	Kind   relocKind
+	Sym    string
+}
+
+type fixup struct {
+	offset int
+	label  string
+}
+
+// encoder turns instructions into x86_64 machine code.
+type encoder struct {
+	code   []byte
+	labels map[string]int
+	fixups []fixup
+	relocs []codeReloc
+}
+
+func newEncoder() *encoder {
+	return &encoder{labels: make(map[string]int)}
+}
+
+func (e *encoder) byte(b ...byte) {
+	e.code = append(e.code, b...)
+}
+func (e *encoder) imm32(v int64) {
+	e.code = binary.LittleEndian.AppendUint32(e.code, uint32(int32(v)))
+}
+func (e *encoder) imm64(v int64) {
+	e.code = binary.LittleEndian.AppendUint64(e.code, uint64(v))
+}
+func fitsInt8(v int64) bool {
+	return v >= -128 && v < 128
+}
+
+// rex emits a REX prefix when one is required. 'r' is the ModRM reg field and
+// 'rm' the operand encoded in the r/m field.
+func (e *encoder) rex(w bool, r Reg, rm Operand, byteRegs bool) {
+	var prefix byte = 0x40
+	if w {
+		prefix |= 0x08
+	}
+	if r >= R8 {
+		prefix |= 0x04
+	}
+	if (rm.Kind == OPERAND_REG || rm.Kind == OPERAND_MEM) && rm.Reg >= R8 {
+		prefix |= 0x01
+	}
+	// spl, bpl, sil and dil are only reachable with a REX prefix.
+	needed := byteRegs && (r >= RSP && r <= RDI || rm.Kind == OPERAND_REG && rm.Reg >= RSP && rm.Reg <= RDI)
+	if prefix != 0x40 || needed {
+		e.byte(prefix)
+	}
+}
+
+// modrm encodes the ModRM byte, plus SIB and displacement, for 'rm'.
+func (e *encoder) modrm(r Reg, rm Operand) {
+	regField := byte(r&7) << 3
+	switch rm.Kind {
+	case OPERAND_REG:
+		{
+			e.byte(0xC0 | regField | byte(rm.Reg&7))
+		}
+	case OPERAND_MEM:
+		{
+			base := byte(rm.Reg & 7)
+			disp := rm.Imm
+			var mod byte
+			switch {
+			case disp == 0 && base != 5: // rbp and r13 always need a displacement
+				mod = 0x00
+			case fitsInt8(disp):
+				mod = 0x40
+			default:
+				mod = 0x80
+			}
+			e.byte(mod | regField | base)
+			if base == 4 { // rsp and r12 need a SIB byte
+				e.byte(0x24)
+			}
+			if mod == 0x40 {
+				e.byte(byte(int8(disp)))
+			} else if mod == 0x80 {
+				e.imm32(disp)
+			}
+		}
+	case OPERAND_SYM:
+		{
+			e.byte(0x05 | regField)
+			e.relocs = append(e.relocs, codeReloc{Offset: len(e.code), Kind: RELOC_PC32, Sym: rm.Sym})
+			e.imm32(0)
+		}
+	default:
+		{
+			panic(fmt.Sprintf("Unable to encode operand kind '%d' in r/m", rm.Kind))
+		}
+	}
+}
+
+// op emits "prefixes REX opcode ModRM" for an instruction of operand 'size'.
+func (e *encoder) op(size int, opcode []byte, r Reg, rm Operand) {
+	if size == 2 {
+		e.byte(0x66)
+	}
+	e.rex(size == 8, r, rm, size == 1)
+	e.byte(opcode...)
+	e.modrm(r, rm)
+}
+
+// aluOps maps two operand instructions to their "r/m, reg" opcode and the
+// ModRM extension used by the "r/m, imm" form.
+var aluOps = map[Opcode]struct {
+	opcode byte
+	ext    Reg
+}{
+	OP_ADD: {0x01, 0},
+	OP_SUB: {0x29, 5},
+	OP_CMP: {0x39, 7},
+}
+
+var setccOps = map[Opcode]byte{
+	OP_SETE:  0x94,
+	OP_SETNE: 0x95,
+	OP_SETL:  0x9C,
+	OP_SETGE: 0x9D,
+	OP_SETLE: 0x9E,
+	OP_SETG:  0x9F,
+}
+
+func (e *encoder) jump(opcode []byte, target string) {
+	e.byte(opcode...)
+	e.fixups = append(e.fixups, fixup{offset: len(e.code), label: target})
+	e.imm32(0)
+}
+
+func (e *encoder) encode(instr Instr) {
+	args := instr.Args
+	switch instr.Op {
+	case OP_LABEL:
+		{
+			e.labels[args[0].Sym] = len(e.code)
+		}
+	case OP_MOV:
+		{
+			src, dst := args[0], args[1]
+			opByte := byte(0x89)
+			if instr.Size == 1 {
+				opByte = 0x88
+			}
+			switch {
+			case src.Kind == OPERAND_IMM && dst.Kind == OPERAND_REG && instr.Size == 8 && !fitsInt32(src.Imm):
+				e.rex(true, 0, dst, false)
+				e.byte(0xB8 + byte(dst.Reg&7))
+				e.imm64(src.Imm)
+			case src.Kind == OPERAND_IMM:
+				e.op(instr.Size, []byte{opByte - 0x89 + 0xC7}, 0, dst)
+				switch instr.Size {
+				case 1:
+					e.byte(byte(src.Imm))
+				case 2:
+					e.code = binary.LittleEndian.AppendUint16(e.code, uint16(src.Imm))
+				default:
+					e.imm32(src.Imm)
+				}
+			case src.Kind == OPERAND_REG:
+				e.op(instr.Size, []byte{opByte}, src.Reg, dst)
+			default:
+				e.op(instr.Size, []byte{opByte + 2}, dst.Reg, src)
+			}
+		}
+	case OP_MOVSX:
+		{
+			src, dst := args[0], args[1]
+			switch instr.Size {
+			case 1:
+				e.op(8, []byte{0x0F, 0xBE}, dst.Reg, src)
+			case 2:
+				e.op(8, []byte{0x0F, 0xBF}, dst.Reg, src)
+			default:
+				e.op(8, []byte{0x63}, dst.Reg, src)
+			}
+		}
+	case OP_MOVZX:
+		{
+			src, dst := args[0], args[1]
+			switch instr.Size {
+			case 1:
+				e.op(8, []byte{0x0F, 0xB6}, dst.Reg, src)
+			case 2:
+				e.op(8, []byte{0x0F, 0xB7}, dst.Reg, src)
+			default:
+				e.op(4, []byte{0x8B}, dst.Reg, src)
+			}
+		}
+	case OP_LEA:
+		{
+			e.op(8, []byte{0x8D}, args[1].Reg, args[0])
+		}
+	case OP_ADD, OP_SUB, OP_CMP:
+		{
+			alu := aluOps[instr.Op]
+			src, dst := args[0], args[1]
+			if src.Kind == OPERAND_IMM {
+				if fitsInt8(src.Imm) {
+					e.op(instr.Size, []byte{0x83}, alu.ext, dst)
+					e.byte(byte(int8(src.Imm)))
+				} else {
+					e.op(instr.Size, []byte{0x81}, alu.ext, dst)
+					e.imm32(src.Imm)
+				}
+				return
+			}
+			e.op(instr.Size, []byte{alu.opcode}, src.Reg, dst)
+		}
+	case OP_IMUL:
+		{
+			e.op(instr.Size, []byte{0x0F, 0xAF}, args[1].Reg, args[0])
+		}
+	case OP_CQO:
+		{
+			e.byte(0x48, 0x99)
+		}
+	case OP_IDIV:
+		{
+			e.op(8, []byte{0xF7}, 7, args[0])
+		}
+	case OP_TEST:
+		{
+			e.op(instr.Size, []byte{0x85}, args[0].Reg, args[1])
+		}
+	case OP_SETE, OP_SETNE, OP_SETL, OP_SETLE, OP_SETG, OP_SETGE:
+		{
+			e.op(1, []byte{0x0F, setccOps[instr.Op]}, 0, args[0])
+		}
+	case OP_PUSH, OP_POP:
+		{
+			r := args[0].Reg
+			if r >= R8 {
+				e.byte(0x41)
+			}
+			base := byte(0x50)
+			if instr.Op == OP_POP {
+				base = 0x58
+			}
+			e.byte(base + byte(r&7))
+		}
+	case OP_CALL:
+		{
+			e.byte(0xE8)
+			e.relocs = append(e.relocs, codeReloc{Offset: len(e.code), Kind: RELOC_CALL, Sym: args[0].Sym})
+			e.imm32(0)
+		}
+	case OP_RET:
+		{
+			e.byte(0xC3)
+		}
+	case OP_LEAVE:
+		{
+			e.byte(0xC9)
+		}
+	case OP_JMP:
+		{
+			e.jump([]byte{0xE9}, args[0].Sym)
+		}
+	case OP_JE:
+		{
+			e.jump([]byte{0x0F, 0x84}, args[0].Sym)
+		}
+	case OP_JNE:
+		{
+			e.jump([]byte{0x0F, 0x85}, args[0].Sym)
+		}
+	case OP_REP_MOVSB:
+		{
+			e.byte(0xF3, 0xA4)
+		}
+	default:
+		{
+			panic(fmt.Sprintf("Unable to encode opcode '%d'", instr.Op))
+		}
+	}
+}
+
+// resolve patches branches to local labels once every label is known.
+func (e *encoder) resolve() {
+	for _, f := range e.fixups {
+		target, ok := e.labels[f.label]
+		if !ok {
+			panic(fmt.Sprintf("Undefined label '%s'", f.label))
+		}
+		binary.LittleEndian.PutUint32(e.code[f.offset:], uint32(int32(target-(f.offset+4))))
+	}
+	e.fixups = nil
+}
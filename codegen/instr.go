@@ -0,0 +1,143 @@
+package codegen
+
+import (
+	"github.com/s0h1s2/error"
+	"github.com/s0h1s2/types"
+)
+
+// Registers are numbered the way the hardware encodes them.
+type Reg int
+
+const (
+	RAX Reg = iota
+	RCX
+	RDX
+	RBX
+	RSP
+	RBP
+	RSI
+	RDI
+	R8
+	R9
+	R10
+	R11
+	R12
+	R13
+	R14
+	R15
+)
+
+// Registers from FIRST_VREG on are virtual until the register allocator maps them.
+const FIRST_VREG Reg = 16
+
+func (r Reg) IsVirtual() bool {
+	return r >= FIRST_VREG
+}
+
+// System V integer argument registers in order.
+var argRegs = []Reg{RDI, RSI, RDX, RCX, R8, R9}
+
+// System V registers a call may clobber and ones it must preserve.
+var callerSaved = []Reg{RAX, RCX, RDX, RSI, RDI, R8, R9, R10, R11}
+var calleeSaved = []Reg{RBX, R12, R13, R14, R15}
+
+type Opcode int
+
+const (
+	OP_LABEL Opcode = iota
+	OP_MOV
+	OP_MOVSX // sign extend Size bytes into 64 bits
+	OP_MOVZX // zero extend Size bytes into 64 bits
+	OP_LEA
+	OP_ADD
+	OP_SUB
+	OP_IMUL
+	OP_CQO  // sign extend %rax into %rdx
+	OP_IDIV // divide %rdx:%rax, quotient in %rax
+	OP_CMP
+	OP_TEST
+	OP_SETE
+	OP_SETNE
+	OP_SETL
+	OP_SETLE
+	OP_SETG
+	OP_SETGE
+	OP_PUSH
+	OP_POP
+	OP_CALL
+	OP_RET
+	OP_LEAVE
+	OP_JMP
+	OP_JE
+	OP_JNE
+	OP_REP_MOVSB
+)
+
+type OperandKind int
+
+const (
+	OPERAND_REG OperandKind = iota
+	OPERAND_IMM
+	OPERAND_MEM   // Disp(Reg)
+	OPERAND_SYM   // Sym(%rip)
+	OPERAND_LABEL // branch or call target, Imm counts the argument registers of a call
+)
+
+type Operand struct {
+	Kind OperandKind
+	Reg  Reg
+	Imm  int64
+	Sym  string
+}
+
+// Instr operands are in AT&T order, source first.
+type Instr struct {
+	Op   Opcode
+	Size int
+	Args []Operand
+	Pos  error.Position // source statement, zero for glue code
+}
+
+// Var is a source variable living in the frame of a function.
+type Var struct {
+	Name   string
+	Type   *types.Type
+	Offset int64 // from %rbp
+	Param  bool
+	Pos    error.Position
+}
+
+type Function struct {
+	Name    string // link name
+	Source  string // name in the source
+	Public  bool   // exported from the object, see ir.Function
+	Instrs  []Instr
+	RetType *types.Type
+	Vars    []Var
+	Pos     error.Position
+	End     error.Position
+}
+
+type Program struct {
+	Functions []*Function
+	Strings   []string // string literals, referenced as .LstrN
+	Externs   []string
+	Source    string // source file, debug information is emitted when set
+	Dir       string // directory the compiler ran in
+}
+
+func reg(r Reg) Operand {
+	return Operand{Kind: OPERAND_REG, Reg: r}
+}
+func imm(v int64) Operand {
+	return Operand{Kind: OPERAND_IMM, Imm: v}
+}
+func mem(base Reg, disp int64) Operand {
+	return Operand{Kind: OPERAND_MEM, Reg: base, Imm: disp}
+}
+func sym(name string) Operand {
+	return Operand{Kind: OPERAND_SYM, Sym: name}
+}
+func label(name string) Operand {
+	return Operand{Kind: OPERAND_LABEL, Sym: name}
+}
@@ -0,0 +1,92 @@
+package codegen
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/s0h1s2/elf"
+)
+
+// Object encodes the program into a relocatable ELF64 object file.
+func (p *Program) Object() []byte {
+	file := elf.New()
+	text := file.AddSection(".text", elf.SHT_PROGBITS, elf.SHF_ALLOC|elf.SHF_EXECINSTR, 16)
+	rodata := file.AddSection(".rodata", elf.SHT_PROGBITS, elf.SHF_ALLOC, 1)
+	file.AddSection(".note.GNU-stack", elf.SHT_PROGBITS, 0, 1)
+
+	strings := make(map[string]uint64)
+	for i, str := range p.Strings {
+		strings[fmt.Sprintf(".Lstr%d", i)] = uint64(len(rodata.Data))
+		rodata.Data = append(rodata.Data, str...)
+		rodata.Data = append(rodata.Data, 0)
+	}
+
+	enc := newEncoder()
+	rows := make(map[string][]lineRow)
+	sizes := make(map[string]int)
+	offsets := make(map[string]int)
+	files := p.sourceFiles()
+	for _, fn := range p.Functions {
+		start := len(enc.code)
+		line, column := 0, 0
+		for _, instr := range fn.Instrs {
+			if instr.Op != OP_LABEL && instr.Pos.Line != 0 && (instr.Pos.Line != line || instr.Pos.Column != column) {
+				line, column = instr.Pos.Line, instr.Pos.Column
+				rows[fn.Name] = append(rows[fn.Name], lineRow{Offset: len(enc.code) - start, File: files.of(instr.Pos.File), Line: line, Column: column})
+			}
+			enc.encode(instr)
+		}
+		sizes[fn.Name] = len(enc.code) - start
+		offsets[fn.Name], offsets[endLabel(fn.Name)] = start, len(enc.code)
+		bind := uint8(elf.STB_LOCAL)
+		if fn.Public {
+			bind = elf.STB_GLOBAL
+		}
+		file.AddSymbol(fn.Name, text, uint64(start), uint64(len(enc.code)-start), bind, elf.STT_FUNC)
+	}
+	enc.resolve()
+	text.Data = enc.code
+
+	for _, r := range enc.relocs {
+		switch r.Kind {
+		case RELOC_CALL:
+			{
+				target := file.Lookup(r.Sym)
+				if target == nil {
+					target = file.AddSymbol(r.Sym, nil, 0, 0, elf.STB_GLOBAL, elf.STT_NOTYPE)
+				}
+				text.Relocs = append(text.Relocs, elf.Reloc{Offset: uint64(r.Offset), Symbol: target, Type: elf.R_X86_64_PLT32, Addend: -4})
+			}
+		case RELOC_PC32:
+			{
+				offset, ok := strings[r.Sym]
+				if !ok {
+					panic(fmt.Sprintf("Unknown data symbol '%s'", r.Sym))
+				}
+				text.Relocs = append(text.Relocs, elf.Reloc{Offset: uint64(r.Offset), Symbol: rodata.Symbol, Type: elf.R_X86_64_PC32, Addend: int64(offset) - 4})
+			}
+		}
+	}
+	if p.Source != "" && len(p.Functions) > 0 {
+		debugSections := []*debugSection{debugAbbrev(), p.debugInfo(), p.debugLine(rows, sizes)}
+		sections := make(map[string]*elf.Section)
+		for _, d := range debugSections {
+			sections[d.Name] = file.AddSection(d.Name, elf.SHT_PROGBITS, 0, 1)
+		}
+		for _, d := range debugSections {
+			sec := sections[d.Name]
+			sec.Data = d.Data
+			for _, r := range d.Relocs {
+				switch r.Kind {
+				case DEBUG_ADDR:
+					sec.Relocs = append(sec.Relocs, elf.Reloc{Offset: uint64(r.Offset), Symbol: file.Lookup(r.Sym), Type: elf.R_X86_64_64})
+				case DEBUG_LENGTH:
+					binary.LittleEndian.PutUint64(sec.Data[r.Offset:], uint64(offsets[r.End]-offsets[r.Sym]))
+				case DEBUG_OFFSET:
+					sec.Relocs = append(sec.Relocs, elf.Reloc{Offset: uint64(r.Offset), Symbol: sections[r.Sym].Symbol, Type: elf.R_X86_64_32})
+				}
+			}
+		}
+	}
+	return file.Bytes()
+}
@@ -0,0 +1,379 @@
+package codegen
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Registers handed out by the allocator, caller saved ones first so that short
+// intervals leave the callee saved ones for values living across calls. %r10
+// and %r11 are kept free for reloading spilled values.
+var allocatable = []Reg{RSI, RDI, R8, R9, RCX, RDX, RAX, RBX, R12, R13, R14, R15}
+
+var spillScratch = []Reg{R10, R11}
+
+// interval is the range of positions a register is live in. An instruction
+// numbered n reads its operands at 2n and writes its results at 2n+1.
+type interval struct {
+	vreg       Reg
+	start, end int
+	assigned   Reg
+	spilled    bool
+}
+
+// defsUses lists the registers an instruction writes and reads, including the
+// ones it uses implicitly.
+func defsUses(instr Instr) (defs []Reg, uses []Reg) {
+	use := func(op Operand) {
+		if op.Kind == OPERAND_REG || op.Kind == OPERAND_MEM {
+			uses = append(uses, op.Reg)
+		}
+	}
+	def := func(op Operand) {
+		if op.Kind == OPERAND_REG {
+			defs = append(defs, op.Reg)
+		} else {
+			use(op)
+		}
+	}
+	args := instr.Args
+	switch instr.Op {
+	case OP_MOV, OP_MOVSX, OP_MOVZX, OP_LEA:
+		{
+			use(args[0])
+			def(args[1])
+		}
+	case OP_ADD, OP_SUB, OP_IMUL:
+		{
+			use(args[0])
+			use(args[1])
+			def(args[1])
+		}
+	case OP_CMP, OP_TEST:
+		{
+			use(args[0])
+			use(args[1])
+		}
+	case OP_SETE, OP_SETNE, OP_SETL, OP_SETLE, OP_SETG, OP_SETGE, OP_POP:
+		{
+			def(args[0])
+		}
+	case OP_PUSH:
+		{
+			use(args[0])
+		}
+	case OP_CQO:
+		{
+			uses = append(uses, RAX)
+			defs = append(defs, RDX)
+		}
+	case OP_IDIV:
+		{
+			use(args[0])
+			uses = append(uses, RAX, RDX)
+			defs = append(defs, RAX, RDX)
+		}
+	case OP_REP_MOVSB:
+		{
+			uses = append(uses, RDI, RSI, RCX)
+			defs = append(defs, RDI, RSI, RCX)
+		}
+	case OP_CALL:
+		{
+			uses = append(uses, argRegs[:args[0].Imm]...)
+			uses = append(uses, RAX)
+			defs = append(defs, callerSaved...)
+		}
+	}
+	return defs, uses
+}
+
+// liveness computes the virtual registers live on entry to each block.
+func liveness(blocks []*block) map[*block]map[Reg]bool {
+	gen := make(map[*block]map[Reg]bool)
+	kill := make(map[*block]map[Reg]bool)
+	for _, b := range blocks {
+		gen[b], kill[b] = map[Reg]bool{}, map[Reg]bool{}
+		for _, instr := range b.instrs {
+			defs, uses := defsUses(instr)
+			for _, r := range uses {
+				if r.IsVirtual() && !kill[b][r] {
+					gen[b][r] = true
+				}
+			}
+			for _, r := range defs {
+				if r.IsVirtual() {
+					kill[b][r] = true
+				}
+			}
+		}
+	}
+	liveIn := make(map[*block]map[Reg]bool)
+	for _, b := range blocks {
+		liveIn[b] = map[Reg]bool{}
+	}
+	for changed := true; changed; {
+		changed = false
+		for i := len(blocks) - 1; i >= 0; i-- {
+			b := blocks[i]
+			for _, succ := range b.succs {
+				for r := range liveIn[succ] {
+					if !kill[b][r] && !liveIn[b][r] {
+						liveIn[b][r] = true
+						changed = true
+					}
+				}
+			}
+			for r := range gen[b] {
+				if !liveIn[b][r] {
+					liveIn[b][r] = true
+					changed = true
+				}
+			}
+		}
+	}
+	return liveIn
+}
+
+type fixedRange struct {
+	start, end int
+}
+
+// allocate maps virtual registers with linear scan, spills the rest to the frame,
+// and wraps the function in its prologue and epilogue.
+func (s *selector) allocate() (*Function, int64) {
+	liveIn := liveness(s.blocks)
+	intervals := make(map[Reg]*interval)
+	touch := func(r Reg, pos int) {
+		it, ok := intervals[r]
+		if !ok {
+			intervals[r] = &interval{vreg: r, start: pos, end: pos}
+			return
+		}
+		if pos < it.start {
+			it.start = pos
+		}
+		if pos > it.end {
+			it.end = pos
+		}
+	}
+	fixed := make(map[Reg][]fixedRange)
+	n := 0
+	for _, b := range s.blocks {
+		start := 2 * n
+		end := 2*(n+len(b.instrs)) - 1
+		for r := range liveIn[b] {
+			touch(r, start)
+		}
+		for _, succ := range b.succs {
+			for r := range liveIn[succ] {
+				touch(r, end)
+			}
+		}
+		// Physical registers only carry values within a block.
+		liveUntil := make(map[Reg]int)
+		for i := len(b.instrs) - 1; i >= 0; i-- {
+			pos := 2 * (n + i)
+			defs, uses := defsUses(b.instrs[i])
+			for _, r := range defs {
+				if r.IsVirtual() {
+					touch(r, pos+1)
+					continue
+				}
+				until, ok := liveUntil[r]
+				if !ok {
+					until = pos + 1
+				}
+				fixed[r] = append(fixed[r], fixedRange{pos + 1, until})
+				delete(liveUntil, r)
+			}
+			for _, r := range uses {
+				if r.IsVirtual() {
+					touch(r, pos)
+					continue
+				}
+				if _, ok := liveUntil[r]; !ok {
+					liveUntil[r] = pos
+				}
+			}
+		}
+		for r, until := range liveUntil {
+			fixed[r] = append(fixed[r], fixedRange{start, until})
+		}
+		n += len(b.instrs)
+	}
+
+	sorted := make([]*interval, 0, len(intervals))
+	for _, it := range intervals {
+		sorted = append(sorted, it)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].start != sorted[j].start {
+			return sorted[i].start < sorted[j].start
+		}
+		return sorted[i].vreg < sorted[j].vreg
+	})
+	conflicts := func(r Reg, it *interval) bool {
+		for _, fr := range fixed[r] {
+			if fr.start <= it.end && it.start <= fr.end {
+				return true
+			}
+		}
+		return false
+	}
+	var active []*interval
+	for _, cur := range sorted {
+		kept := active[:0]
+		for _, it := range active {
+			if it.end >= cur.start {
+				kept = append(kept, it)
+			}
+		}
+		active = kept
+		used := make(map[Reg]bool)
+		for _, it := range active {
+			used[it.assigned] = true
+		}
+		found := false
+		for _, r := range allocatable {
+			if !used[r] && !conflicts(r, cur) {
+				cur.assigned = r
+				found = true
+				break
+			}
+		}
+		if !found {
+			// Spill whichever interval ends last, if its register suits 'cur'.
+			var victim *interval
+			for _, it := range active {
+				if it.end > cur.end && !conflicts(it.assigned, cur) && (victim == nil || it.end > victim.end) {
+					victim = it
+				}
+			}
+			if victim == nil {
+				cur.spilled = true
+				continue
+			}
+			cur.assigned = victim.assigned
+			victim.spilled = true
+			for i, it := range active {
+				if it == victim {
+					active = append(active[:i], active[i+1:]...)
+					break
+				}
+			}
+		}
+		active = append(active, cur)
+	}
+
+	slots := make(map[Reg]int64)
+	savedRegs := []Reg{}
+	usedRegs := make(map[Reg]bool)
+	for _, it := range intervals {
+		if it.spilled {
+			continue
+		}
+		usedRegs[it.assigned] = true
+	}
+	for _, r := range calleeSaved {
+		if usedRegs[r] {
+			savedRegs = append(savedRegs, r)
+		}
+	}
+	allocSlot := func() int64 {
+		s.frameSize += 8
+		s.frameSize = (s.frameSize + 7) / 8 * 8
+		return -s.frameSize
+	}
+	for _, it := range sorted {
+		if it.spilled {
+			slots[it.vreg] = allocSlot()
+		}
+	}
+	saveSlots := make([]int64, len(savedRegs))
+	for i := range savedRegs {
+		saveSlots[i] = allocSlot()
+	}
+	frameSize := (s.frameSize + 15) / 16 * 16
+
+	out := &Function{Name: s.fn.Name, Source: s.fn.Source, Public: s.fn.Public, RetType: s.fn.RetType, Vars: s.vars, Pos: s.fn.Pos, End: s.fn.End}
+	pos := s.fn.Pos
+	emit := func(op Opcode, size int, args ...Operand) {
+		out.Instrs = append(out.Instrs, Instr{Op: op, Size: size, Args: args, Pos: pos})
+	}
+	emit(OP_PUSH, 8, reg(RBP))
+	emit(OP_MOV, 8, reg(RSP), reg(RBP))
+	if frameSize > 0 {
+		emit(OP_SUB, 8, imm(frameSize), reg(RSP))
+	}
+	for i, r := range savedRegs {
+		emit(OP_MOV, 8, reg(r), mem(RBP, saveSlots[i]))
+	}
+	for i, b := range s.blocks {
+		emit(OP_LABEL, 0, label(b.label))
+		for j, instr := range b.instrs {
+			// A jump to the block laid out next is a fall through.
+			if instr.Op == OP_JMP && j == len(b.instrs)-1 && i+1 < len(s.blocks) && instr.Args[0].Sym == s.blocks[i+1].label {
+				continue
+			}
+			out.Instrs = append(out.Instrs, rewrite(instr, intervals, slots)...)
+		}
+	}
+	pos = s.fn.End
+	emit(OP_LABEL, 0, label(s.retLabel))
+	for i, r := range savedRegs {
+		emit(OP_MOV, 8, mem(RBP, saveSlots[i]), reg(r))
+	}
+	emit(OP_LEAVE, 0)
+	emit(OP_RET, 0)
+	return out, frameSize
+}
+
+// rewrite replaces the virtual registers of an instruction by their allocation,
+// going through scratch registers for spilled ones.
+func rewrite(instr Instr, intervals map[Reg]*interval, slots map[Reg]int64) []Instr {
+	defs, uses := defsUses(instr)
+	scratch := make(map[Reg]Reg)
+	var before, after []Instr
+	args := make([]Operand, len(instr.Args))
+	for i, arg := range instr.Args {
+		args[i] = arg
+		if arg.Kind != OPERAND_REG && arg.Kind != OPERAND_MEM || !arg.Reg.IsVirtual() {
+			continue
+		}
+		it := intervals[arg.Reg]
+		if !it.spilled {
+			args[i].Reg = it.assigned
+			continue
+		}
+		r, ok := scratch[arg.Reg]
+		if !ok {
+			if len(scratch) == len(spillScratch) {
+				panic(fmt.Sprintf("Instruction '%s' needs too many spilled registers", formatInstr(instr)))
+			}
+			r = spillScratch[len(scratch)]
+			scratch[arg.Reg] = r
+			if contains(uses, arg.Reg) {
+				before = append(before, Instr{Op: OP_MOV, Size: 8, Args: []Operand{mem(RBP, slots[arg.Reg]), reg(r)}})
+			}
+			if contains(defs, arg.Reg) {
+				after = append(after, Instr{Op: OP_MOV, Size: 8, Args: []Operand{reg(r), mem(RBP, slots[arg.Reg])}})
+			}
+		}
+		args[i].Reg = r
+	}
+	instr.Args = args
+	if instr.Op == OP_MOV && instr.Size == 8 && args[0].Kind == OPERAND_REG && args[1].Kind == OPERAND_REG && args[0].Reg == args[1].Reg {
+		return append(before, after...)
+	}
+	return append(append(before, instr), after...)
+}
+
+func contains(regs []Reg, r Reg) bool {
+	for _, reg := range regs {
+		if reg == r {
+			return true
+		}
+	}
+	return false
+}
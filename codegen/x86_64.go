@@ -0,0 +1,387 @@
+package codegen
+
+import (
+	"fmt"
+
+	"github.com/s0h1s2/error"
+	"github.com/s0h1s2/ir"
+	"github.com/s0h1s2/resolver"
+	"github.com/s0h1s2/types"
+)
+
+// block is a basic block of machine instructions operating on virtual registers.
+type block struct {
+	label  string
+	instrs []Instr
+	succs  []*block
+}
+
+type selector struct {
+	handler   *error.DiagnosticBag
+	program   *Program
+	strings   map[*ir.StringConst]string
+	fn        *ir.Function
+	blocks    []*block
+	blockOf   map[*ir.Block]*block
+	cur       *block
+	vregs     map[ir.Value]Reg
+	nextVreg  Reg
+	frame     map[ir.Value]int64 // allocas and fields of them as offsets from %rbp
+	frameSize int64
+	retLabel  string
+	vars      []Var
+	pos       error.Position
+}
+
+// Generate selects instructions for every function of the module and allocates
+// their registers. The final frame size of each function is reported back into
+// the StackSize of its declaration.
+func Generate(module *ir.Module, decls []resolver.DeclNode, handler *error.DiagnosticBag) *Program {
+	s := &selector{handler: handler, program: &Program{}, strings: make(map[*ir.StringConst]string)}
+	for i, str := range module.Strings {
+		s.strings[str] = fmt.Sprintf(".Lstr%d", i)
+		s.program.Strings = append(s.program.Strings, str.Value)
+	}
+	stackSizes := make(map[string]int64)
+	for _, fn := range module.Functions {
+		if fn.External {
+			s.program.Externs = append(s.program.Externs, fn.Name)
+			continue
+		}
+		out, stackSize := s.genFunction(fn)
+		s.program.Functions = append(s.program.Functions, out)
+		stackSizes[fn.Name] = stackSize
+	}
+	for _, decl := range decls {
+		if fun, ok := decl.(*resolver.DeclFunction); ok {
+			fun.StackSize = int(stackSizes[fun.Link])
+		}
+	}
+	return s.program
+}
+
+func (s *selector) emit(op Opcode, size int, args ...Operand) {
+	s.cur.instrs = append(s.cur.instrs, Instr{Op: op, Size: size, Args: args, Pos: s.pos})
+}
+func (s *selector) newVreg() Reg {
+	r := s.nextVreg
+	s.nextVreg++
+	return r
+}
+func (s *selector) newBlock(name string) *block {
+	b := &block{label: fmt.Sprintf(".L%s_%s", s.fn.Name, name)}
+	s.blocks = append(s.blocks, b)
+	return b
+}
+
+func (s *selector) genFunction(fn *ir.Function) (*Function, int64) {
+	s.fn = fn
+	s.blocks = nil
+	s.blockOf = make(map[*ir.Block]*block)
+	s.vregs = make(map[ir.Value]Reg)
+	s.nextVreg = FIRST_VREG
+	s.frame = make(map[ir.Value]int64)
+	s.frameSize = 0
+	s.retLabel = fmt.Sprintf(".L%s_ret", fn.Name)
+	s.vars = nil
+	s.pos = fn.Pos
+	for _, b := range fn.Blocks {
+		s.blockOf[b] = s.newBlock(b.Name)
+	}
+	for _, b := range fn.Blocks {
+		for _, instr := range b.Instrs {
+			if instr.HasResult() {
+				s.vregs[instr] = s.newVreg()
+			}
+		}
+	}
+
+	s.cur = s.blockOf[fn.Blocks[0]]
+	for i, param := range fn.Params {
+		s.vregs[param] = s.newVreg()
+		if i < len(argRegs) {
+			s.emit(OP_MOV, 8, reg(argRegs[i]), reg(s.vregs[param]))
+			continue
+		}
+		// The rest were pushed by the caller, above the return address.
+		s.emit(OP_MOV, 8, mem(RBP, 16+8*int64(i-len(argRegs))), reg(s.vregs[param]))
+	}
+	for _, b := range fn.Blocks {
+		s.cur = s.blockOf[b]
+		for _, instr := range b.Instrs {
+			s.pos = instr.Pos
+			s.genInstr(instr)
+		}
+	}
+	return s.allocate()
+}
+
+// value returns a register holding 'v', materializing constants and addresses.
+func (s *selector) value(v ir.Value) Reg {
+	if offset, ok := s.frame[v]; ok {
+		r := s.newVreg()
+		s.emit(OP_LEA, 8, mem(RBP, offset), reg(r))
+		return r
+	}
+	switch v := v.(type) {
+	case *ir.Const:
+		{
+			r := s.newVreg()
+			s.emit(OP_MOV, 8, imm(v.Value), reg(r))
+			return r
+		}
+	case *ir.StringConst:
+		{
+			r := s.newVreg()
+			s.emit(OP_LEA, 8, sym(s.strings[v]), reg(r))
+			return r
+		}
+	}
+	return s.vregs[v]
+}
+
+// operand returns 'v' as an immediate when it fits one, otherwise as a register.
+func (s *selector) operand(v ir.Value) Operand {
+	if c, ok := v.(*ir.Const); ok && fitsInt32(c.Value) {
+		return imm(c.Value)
+	}
+	return reg(s.value(v))
+}
+
+// address returns a memory operand for the location 'v' points to.
+func (s *selector) address(v ir.Value) Operand {
+	if offset, ok := s.frame[v]; ok {
+		return mem(RBP, offset)
+	}
+	return mem(s.value(v), 0)
+}
+
+// moveTo copies 'v' into the register 'dst'.
+func (s *selector) moveTo(v ir.Value, dst Reg) {
+	if offset, ok := s.frame[v]; ok {
+		s.emit(OP_LEA, 8, mem(RBP, offset), reg(dst))
+		return
+	}
+	switch v := v.(type) {
+	case *ir.Const:
+		s.emit(OP_MOV, 8, imm(v.Value), reg(dst))
+	case *ir.StringConst:
+		s.emit(OP_LEA, 8, sym(s.strings[v]), reg(dst))
+	default:
+		s.emit(OP_MOV, 8, reg(s.vregs[v]), reg(dst))
+	}
+}
+
+// extend re-establishes the invariant that registers hold values sign extended
+// to 64 bits, and booleans zero extended.
+func (s *selector) extend(typ *types.Type, src Operand, dst Reg) {
+	switch {
+	case typ.Kind == types.TYPE_BOOL:
+		s.emit(OP_MOVZX, 1, src, reg(dst))
+	case typ.Kind == types.TYPE_INT && typ.Size < 8:
+		s.emit(OP_MOVSX, int(typ.Size), src, reg(dst))
+	default:
+		if src.Kind != OPERAND_REG || src.Reg != dst {
+			s.emit(OP_MOV, 8, src, reg(dst))
+		}
+	}
+}
+
+var aluForOp = map[ir.Op]Opcode{
+	ir.OP_ADD: OP_ADD,
+	ir.OP_SUB: OP_SUB,
+	ir.OP_MUL: OP_IMUL,
+}
+
+var setForOp = map[ir.Op]Opcode{
+	ir.OP_EQ: OP_SETE,
+	ir.OP_NE: OP_SETNE,
+	ir.OP_LT: OP_SETL,
+	ir.OP_LE: OP_SETLE,
+	ir.OP_GT: OP_SETG,
+	ir.OP_GE: OP_SETGE,
+}
+
+func (s *selector) genInstr(instr *ir.Instr) {
+	dst := s.vregs[instr]
+	switch instr.Op {
+	case ir.OP_ALLOCA:
+		{
+			typ := instr.Typ.Base
+			align := int64(typ.Alignment)
+			if align == 0 {
+				align = 1
+			}
+			s.frameSize = (s.frameSize + int64(typ.Size) + align - 1) / align * align
+			s.frame[instr] = -s.frameSize
+			if instr.Name != "" {
+				s.vars = append(s.vars, Var{Name: instr.Name, Type: typ, Offset: -s.frameSize, Param: instr.Param, Pos: instr.Pos})
+			}
+		}
+	case ir.OP_FIELD:
+		{
+			base := instr.Args[0]
+			offset := int64(base.Type().Base.Fields[instr.Field].Offset)
+			if baseOffset, ok := s.frame[base]; ok {
+				s.frame[instr] = baseOffset + offset
+				return
+			}
+			s.emit(OP_LEA, 8, mem(s.value(base), offset), reg(dst))
+		}
+	case ir.OP_LOAD:
+		{
+			s.extend(instr.Typ, s.address(instr.Args[0]), dst)
+		}
+	case ir.OP_STORE:
+		{
+			typ := instr.Args[0].Type()
+			src := s.operand(instr.Args[0])
+			s.emit(OP_MOV, int(typ.Size), src, s.address(instr.Args[1]))
+		}
+	case ir.OP_COPYMEM:
+		{
+			s.moveTo(instr.Args[0], RDI)
+			s.moveTo(instr.Args[1], RSI)
+			s.emit(OP_MOV, 8, imm(int64(instr.Args[0].Type().Base.Size)), reg(RCX))
+			s.emit(OP_REP_MOVSB, 0)
+		}
+	case ir.OP_ADD, ir.OP_SUB, ir.OP_MUL:
+		{
+			s.moveTo(instr.Args[0], dst)
+			src := s.operand(instr.Args[1])
+			if instr.Op == ir.OP_MUL && src.Kind == OPERAND_IMM {
+				src = reg(s.value(instr.Args[1]))
+			}
+			s.emit(aluForOp[instr.Op], 8, src, reg(dst))
+			s.extend(instr.Typ, reg(dst), dst)
+		}
+	case ir.OP_DIV:
+		{
+			s.moveTo(instr.Args[0], RAX)
+			divisor := s.value(instr.Args[1])
+			s.emit(OP_CQO, 0)
+			s.emit(OP_IDIV, 8, reg(divisor))
+			s.extend(instr.Typ, reg(RAX), dst)
+		}
+	case ir.OP_EQ, ir.OP_NE, ir.OP_LT, ir.OP_LE, ir.OP_GT, ir.OP_GE:
+		{
+			left := s.value(instr.Args[0])
+			s.emit(OP_CMP, 8, s.operand(instr.Args[1]), reg(left))
+			s.emit(setForOp[instr.Op], 1, reg(dst))
+			s.emit(OP_MOVZX, 1, reg(dst), reg(dst))
+		}
+	case ir.OP_NOT:
+		{
+			operand := s.value(instr.Args[0])
+			s.emit(OP_TEST, 8, reg(operand), reg(operand))
+			s.emit(OP_SETE, 1, reg(dst))
+			s.emit(OP_MOVZX, 1, reg(dst), reg(dst))
+		}
+	case ir.OP_SEXT, ir.OP_COPY:
+		{
+			s.moveTo(instr.Args[0], dst)
+		}
+	case ir.OP_TRUNC:
+		{
+			s.extend(instr.Typ, reg(s.value(instr.Args[0])), dst)
+		}
+	case ir.OP_CALL:
+		{
+			regArgs := instr.Args
+			var stackArgs []ir.Value
+			if len(regArgs) > len(argRegs) {
+				regArgs, stackArgs = instr.Args[:len(argRegs)], instr.Args[len(argRegs):]
+			}
+			// Arguments past the registers are pushed last to first, keeping
+			// %rsp 16 byte aligned at the call.
+			pushed := int64(len(stackArgs)+1) / 2 * 16
+			if len(stackArgs)%2 != 0 {
+				s.emit(OP_SUB, 8, imm(8), reg(RSP))
+			}
+			for i := len(stackArgs) - 1; i >= 0; i-- {
+				s.emit(OP_PUSH, 8, reg(s.value(stackArgs[i])))
+			}
+			for i, arg := range regArgs {
+				s.moveTo(arg, argRegs[i])
+			}
+			// %al holds the number of vector registers for variadic callees.
+			s.emit(OP_MOV, 8, imm(0), reg(RAX))
+			target := label(instr.Callee)
+			target.Imm = int64(len(regArgs))
+			s.emit(OP_CALL, 0, target)
+			if pushed > 0 {
+				s.emit(OP_ADD, 8, imm(pushed), reg(RSP))
+			}
+			if instr.HasResult() {
+				s.extend(instr.Typ, reg(RAX), dst)
+			}
+		}
+	case ir.OP_PHI:
+		{
+			// Predecessors copy into the phi register, see phiCopies.
+		}
+	case ir.OP_JMP:
+		{
+			target := instr.Targets[0]
+			s.phiCopies(instr.Block, target)
+			s.emit(OP_JMP, 0, label(s.blockOf[target].label))
+			s.cur.succs = append(s.cur.succs, s.blockOf[target])
+		}
+	case ir.OP_BR:
+		{
+			cond := s.value(instr.Args[0])
+			s.emit(OP_TEST, 8, reg(cond), reg(cond))
+			from := s.cur
+			then := s.edge(instr.Block, instr.Targets[0])
+			els := s.edge(instr.Block, instr.Targets[1])
+			s.cur = from
+			s.emit(OP_JNE, 0, label(then.label))
+			s.emit(OP_JMP, 0, label(els.label))
+			s.cur.succs = append(s.cur.succs, then, els)
+		}
+	case ir.OP_RET:
+		{
+			if len(instr.Args) > 0 {
+				s.moveTo(instr.Args[0], RAX)
+			}
+			s.emit(OP_JMP, 0, label(s.retLabel))
+		}
+	}
+}
+
+// edge returns the block a branch from 'from' to 'to' should target. Edges into
+// blocks with phis get a block of their own holding the copies.
+func (s *selector) edge(from *ir.Block, to *ir.Block) *block {
+	target := s.blockOf[to]
+	if len(to.Instrs) == 0 || to.Instrs[0].Op != ir.OP_PHI {
+		return target
+	}
+	s.cur = s.newBlock(fmt.Sprintf("%s_%s", from.Name, to.Name))
+	s.phiCopies(from, to)
+	s.emit(OP_JMP, 0, label(target.label))
+	s.cur.succs = append(s.cur.succs, target)
+	return s.cur
+}
+
+// phiCopies moves the values flowing along 'from' -> 'to' into the phi registers
+// of 'to'. Going through fresh registers keeps the copies parallel.
+func (s *selector) phiCopies(from *ir.Block, to *ir.Block) {
+	pred := 0
+	for to.Preds[pred] != from {
+		pred++
+	}
+	var temps []Reg
+	var phis []*ir.Instr
+	for _, instr := range to.Instrs {
+		if instr.Op != ir.OP_PHI {
+			break
+		}
+		temp := s.newVreg()
+		s.moveTo(instr.Args[pred], temp)
+		temps = append(temps, temp)
+		phis = append(phis, instr)
+	}
+	for i, phi := range phis {
+		s.emit(OP_MOV, 8, reg(temps[i]), reg(s.vregs[phi]))
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/s0h1s2/ast"
+	"github.com/s0h1s2/bytecode"
+	"github.com/s0h1s2/compiler"
+	"github.com/s0h1s2/error"
+	"github.com/s0h1s2/interp"
+	"github.com/s0h1s2/lexer"
+	"github.com/s0h1s2/lsp"
+	"github.com/s0h1s2/repl"
+	"github.com/s0h1s2/resolver"
+	"github.com/s0h1s2/vm"
+)
+
+func tokensCmd(args []string) int {
+	filePath, ok := parseFile(newFlags("tokens"), args)
+	if !ok {
+		return 2
+	}
+	src, ok := readSource(filePath)
+	if !ok {
+		return 1
+	}
+	bag := error.New()
+	tokens := lexer.New(bag).GetTokens(src)
+	for _, token := range tokens {
+		fmt.Printf("%d:%d %s\n", token.Pos.Line, token.Pos.Column, token.String())
+	}
+	if bag.GotErrors() {
+		bag.PrintErrors()
+		return 1
+	}
+	return 0
+}
+
+func astCmd(args []string) int {
+	flags := newFlags("ast")
+	asJSON := flags.Bool("json", false, "print the syntax tree as JSON")
+	filePath, pkg, ok := parseSource(flags, args)
+	if !ok {
+		return 2
+	}
+	session := packageSession(pkg)
+	if !session.ParseFile(filePath) {
+		return failed(session)
+	}
+	if *asJSON {
+		return printJSON(ast.JSON(filePath, session.Tree))
+	}
+	ast.Fprint(os.Stdout, session.Tree)
+	return 0
+}
+
+func checkCmd(args []string) int {
+	flags := newFlags("check")
+	dump := flags.String("dump-symbols", "", "print the resolved program and its scopes in `format`, which must be 'json'")
+	filePath, pkg, ok := parseSource(flags, args)
+	if !ok {
+		return 2
+	}
+	if *dump != "" && *dump != "json" {
+		fmt.Fprintf(os.Stderr, "Unknown symbol dump format '%s'.\n", *dump)
+		return 2
+	}
+	session := packageSession(pkg)
+	if !session.ParseFile(filePath) || !session.Check() {
+		return failed(session)
+	}
+	if *dump == "json" {
+		return printJSON(resolver.JSON(session.Decls, session.Table))
+	}
+	return 0
+}
+
+func printJSON(v interface{}) int {
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to encode JSON: %s\n", err)
+		return 1
+	}
+	os.Stdout.Write(append(out, '\n'))
+	return 0
+}
+
+func irCmd(args []string) int {
+	flags := newFlags("ir")
+	optLevel := optFlags(flags)
+	filePath, pkg, ok := parseSource(flags, args)
+	if !ok {
+		return 2
+	}
+	session := packageSession(pkg)
+	session.OptLevel = optLevel()
+	if !session.ParseFile(filePath) {
+		return failed(session)
+	}
+	out, ok := session.Emit(compiler.OUTPUT_IR)
+	if !ok {
+		return failed(session)
+	}
+	os.Stdout.Write(out)
+	return 0
+}
+
+func runCmd(args []string) int {
+	flags := newFlags("run")
+	useVM := flags.Bool("vm", false, "execute the program on the bytecode VM")
+	filePath, pkg, ok := parseSource(flags, args)
+	if !ok {
+		return 2
+	}
+	if filepath.Ext(filePath) == ".dbc" {
+		src, ok := readSource(filePath)
+		if !ok {
+			return 1
+		}
+		program, err := bytecode.Read(src)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to load '%s': %s\n", filePath, err)
+			return 1
+		}
+		return runStatus(vm.Run(program, os.Stdout))
+	}
+	session := packageSession(pkg)
+	if !session.ParseFile(filePath) || !session.Check() {
+		return failed(session)
+	}
+	if *useVM {
+		program := bytecode.Compile(session.Decls, session.Table, session.Diagnostics)
+		if session.Diagnostics.GotErrors() {
+			return failed(session)
+		}
+		return runStatus(vm.Run(program, os.Stdout))
+	}
+	return runStatus(interp.Run(session.Decls, session.Table, os.Stdout))
+}
+
+// runStatus reports a runtime failure, the error package hides the builtin
+// error type here.
+func runStatus(code int, err interface{ Error() string }) int {
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Runtime error: %s\n", err)
+	}
+	return code
+}
+
+func lspCmd(args []string) int {
+	flags := newFlags("lsp")
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return 2
+	}
+	return lsp.Serve(os.Stdin, os.Stdout)
+}
+
+func replCmd(args []string) int {
+	flags := newFlags("repl")
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return 2
+	}
+	info, err := os.Stdin.Stat()
+	return repl.Run(os.Stdin, os.Stdout, err == nil && info.Mode()&os.ModeCharDevice != 0)
+}
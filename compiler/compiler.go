@@ -0,0 +1,245 @@
+package compiler
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/s0h1s2/ast"
+	"github.com/s0h1s2/bytecode"
+	"github.com/s0h1s2/cgen"
+	"github.com/s0h1s2/checker"
+	"github.com/s0h1s2/codegen"
+	"github.com/s0h1s2/error"
+	"github.com/s0h1s2/ir"
+	"github.com/s0h1s2/lexer"
+	"github.com/s0h1s2/llvm"
+	"github.com/s0h1s2/opt"
+	"github.com/s0h1s2/parser"
+	"github.com/s0h1s2/resolver"
+	"github.com/s0h1s2/wasm"
+)
+
+// Output is a kind of artifact a Session emits.
+type Output int
+
+const (
+	OUTPUT_OBJ  Output = iota // x86_64 ELF relocatable object
+	OUTPUT_ASM                // x86_64 GNU as assembly
+	OUTPUT_C                  // C source
+	OUTPUT_LLVM               // LLVM IR text
+	OUTPUT_IR                 // optimized SSA form
+	OUTPUT_BC                 // serialized bytecode program
+	OUTPUT_WAT                // WebAssembly text module
+)
+
+// Session compiles one program. Each session owns its diagnostics, symbol table
+// and type universe, so separate sessions may be used from parallel goroutines.
+type Session struct {
+	Diagnostics *error.DiagnosticBag
+	Tree        []ast.Decl          // set by Parse, the declarations of the parsed file
+	Modules     []*ast.Module       // set by Parse, every module after the ones it imports
+	Table       *resolver.Table     // set by Check
+	Decls       []resolver.DeclNode // set by Check
+	OptLevel    int
+	Debug       bool                // describe native code with DWARF
+	Trace       io.Writer           // receives the name of every stage as it starts
+	Roots       []string            // searched for imports after the directory of the importing file
+	Packages    map[string][]string // source roots of the packages that the first element of an import may name
+	path        string
+	loaded      map[string]*ast.Module // by absolute path, nil while its imports load
+}
+
+func NewSession() *Session {
+	return &Session{Diagnostics: error.New()}
+}
+
+func (s *Session) stage(name string) {
+	if s.Trace != nil {
+		fmt.Fprintf(s.Trace, "----%s----\n", name)
+	}
+}
+
+// ParseFile reads and parses the source file at 'path' and the modules it imports.
+func (s *Session) ParseFile(path string) bool {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		s.Diagnostics.ReportError(error.Position{}, "Unable to read '%s'", path)
+		return false
+	}
+	return s.Parse(path, src)
+}
+
+// Parse parses 'src' and the modules it imports, which are looked up relative
+// to 'path'. 'path' also names the source in diagnostics and debug information.
+func (s *Session) Parse(path string, src []byte) bool {
+	s.path = path
+	s.Modules = nil
+	s.loaded = make(map[string]*ast.Module)
+	if module := s.parseModule(path, src); module != nil {
+		s.Tree = module.Decls
+	}
+	return !s.Diagnostics.GotErrors()
+}
+
+func (s *Session) parseModule(path string, src []byte) *ast.Module {
+	key, _ := filepath.Abs(path)
+	s.loaded[key] = nil
+	module := &ast.Module{
+		Name:    strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)),
+		Path:    path,
+		Imports: make(map[string]*ast.Module),
+	}
+	s.stage("LEXER")
+	reported := len(s.Diagnostics.Errors())
+	lex := lexer.New(s.Diagnostics)
+	lex.SetFile(path)
+	tokens := lex.GetTokens(src)
+	if len(s.Diagnostics.Errors()) > reported {
+		s.loaded[key] = module
+		return nil
+	}
+	s.stage("PARSER")
+	p := parser.New(tokens, s.Diagnostics)
+	p.KeepComments(lex.Comments())
+	module.Decls = p.Parse()
+	for _, decl := range module.Decls {
+		if node, ok := decl.(*ast.DeclImport); ok {
+			if imported := s.importModule(path, node); imported != nil {
+				module.Imports[node.Path] = imported
+			}
+		}
+	}
+	s.loaded[key] = module
+	s.Modules = append(s.Modules, module)
+	return module
+}
+
+// importModule loads the module of an import declaration in the file at 'importer'.
+func (s *Session) importModule(importer string, node *ast.DeclImport) *ast.Module {
+	path, ok := s.findModule(importer, node.Path)
+	if !ok {
+		s.Diagnostics.ReportError(node.Pos, "Module '%s' not found", node.Path)
+		return nil
+	}
+	key, _ := filepath.Abs(path)
+	if module, ok := s.loaded[key]; ok {
+		if module == nil {
+			s.Diagnostics.ReportError(node.Pos, "Importing '%s' forms a cycle", node.Path)
+		}
+		return module
+	}
+	src, err := os.ReadFile(path)
+	if err != nil {
+		s.Diagnostics.ReportError(node.Pos, "Unable to read module '%s' from '%s'", node.Path, path)
+		return nil
+	}
+	return s.parseModule(path, src)
+}
+
+// findModule returns the file of the module imported as 'name' by 'importer'.
+// 'geo/vec' names 'geo/vec.des' next to the importer or in one of the Roots,
+// then 'vec.des' in the source roots of package 'geo'.
+func (s *Session) findModule(importer string, name string) (string, bool) {
+	file := filepath.FromSlash(name) + ".des"
+	candidates := []string{filepath.Join(filepath.Dir(importer), file)}
+	for _, root := range s.Roots {
+		candidates = append(candidates, filepath.Join(root, file))
+	}
+	if pkg, rest, ok := strings.Cut(name, "/"); ok {
+		for _, root := range s.Packages[pkg] {
+			candidates = append(candidates, filepath.Join(root, filepath.FromSlash(rest)+".des"))
+		}
+	}
+	for _, path := range candidates {
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path, true
+		}
+	}
+	return "", false
+}
+
+// Check resolves the names of the parsed program and checks its types.
+func (s *Session) Check() bool {
+	if s.Diagnostics.GotErrors() {
+		return false
+	}
+	s.stage("RESOLVER")
+	s.Table, s.Decls = resolver.Resolve(s.Modules, s.Diagnostics)
+	if s.Diagnostics.GotErrors() {
+		return false
+	}
+	s.stage("CHECKER")
+	checker.Check(s.Decls, s.Table, s.Diagnostics)
+	return !s.Diagnostics.GotErrors()
+}
+
+// Lower translates the checked program to SSA form and optimizes it at OptLevel.
+func (s *Session) Lower() (*ir.Module, bool) {
+	if s.Table == nil && !s.Check() {
+		return nil, false
+	}
+	s.stage("IR")
+	module := ir.Lower(s.Decls, s.Table, s.Diagnostics)
+	if s.Diagnostics.GotErrors() {
+		return nil, false
+	}
+	s.stage("OPTIMIZER")
+	opt.Optimize(module, s.OptLevel)
+	return module, true
+}
+
+// Emit generates the 'kind' output of the program, checking it first when
+// Check was not called.
+func (s *Session) Emit(kind Output) ([]byte, bool) {
+	if s.Table == nil && !s.Check() {
+		return nil, false
+	}
+	switch kind {
+	case OUTPUT_C:
+		{
+			s.stage("CGEN")
+			return []byte(cgen.Generate(s.Decls, s.Table)), true
+		}
+	case OUTPUT_WAT:
+		{
+			s.stage("WASM")
+			module := wasm.Generate(s.Decls, s.Table, s.Diagnostics)
+			return []byte(module), !s.Diagnostics.GotErrors()
+		}
+	case OUTPUT_BC:
+		{
+			s.stage("BYTECODE")
+			program := bytecode.Compile(s.Decls, s.Table, s.Diagnostics)
+			if s.Diagnostics.GotErrors() {
+				return nil, false
+			}
+			return program.Bytes(), true
+		}
+	}
+	module, ok := s.Lower()
+	if !ok {
+		return nil, false
+	}
+	switch kind {
+	case OUTPUT_IR:
+		return []byte(module.String()), true
+	case OUTPUT_LLVM:
+		return []byte(llvm.Generate(module)), true
+	}
+	s.stage("CODEGEN")
+	program := codegen.Generate(module, s.Decls, s.Diagnostics)
+	if s.Diagnostics.GotErrors() {
+		return nil, false
+	}
+	if s.Debug {
+		program.Source = s.path
+		program.Dir, _ = os.Getwd()
+	}
+	if kind == OUTPUT_ASM {
+		return []byte(program.Asm()), true
+	}
+	return program.Object(), true
+}
@@ -0,0 +1,235 @@
+package compiler
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// diagnostics checks the program at 'path' and lists its errors as "line: message".
+func diagnostics(t *testing.T, path string) []string {
+	t.Helper()
+	s := NewSession()
+	if !s.ParseFile(path) {
+		t.Fatalf("%s: unexpected syntax errors %v", path, s.Diagnostics.Errors())
+	}
+	return checked(s)
+}
+
+// sourceDiagnostics is diagnostics for the program 'src'.
+func sourceDiagnostics(t *testing.T, src string) []string {
+	t.Helper()
+	s := NewSession()
+	if !s.Parse("test.des", []byte(src)) {
+		t.Fatalf("unexpected syntax errors %v", s.Diagnostics.Errors())
+	}
+	return checked(s)
+}
+func checked(s *Session) []string {
+	s.Check()
+	list := []string{}
+	for _, err := range s.Diagnostics.Errors() {
+		list = append(list, fmt.Sprintf("%d: %s", err.Pos.Line, err.Msg))
+	}
+	return list
+}
+
+func TestIfDiagnostics(t *testing.T) {
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{"../examples/branches.des", []string{}},
+		{"../examples/if_names.des", []string{
+			"7: Variable 'missing' not found",
+			"8: Variable 'unknown' not found",
+			"11: Variable 'other' not found",
+			"13: Variable 'inner' not found",
+		}},
+		{"../examples/if_types.des", []string{
+			"7: Condition of 'if' must be 'bool' but got 'i32'",
+			"8: Expected 'bool' type but got 'i32' type",
+			"11: Expected 'i32' but got 'bool' in function return",
+			"5: Function 'pick' expected to return 'i32' type",
+		}},
+	}
+	for _, test := range tests {
+		if got := diagnostics(t, test.path); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s:\n got %q\nwant %q", test.path, got, test.want)
+		}
+	}
+}
+
+func TestLoopReturns(t *testing.T) {
+	got := sourceDiagnostics(t, `
+fn forever():i32 {
+  for ;; {
+    return 1;
+  }
+}
+fn always(x:i32):i32 {
+  while true {
+    for ;; {
+      break;
+    }
+    return x;
+  }
+}
+fn broken():i32 {
+  while true {
+    break;
+  }
+}
+fn conditional(b:bool):i32 {
+  while b {
+    return 1;
+  }
+}
+fn counted():i32 {
+  for let i:i32 = 0; i < 3; i = i + 1 {
+    return i;
+  }
+}
+`)
+	want := []string{
+		"15: Function 'broken' expected to return 'i32' type",
+		"20: Function 'conditional' expected to return 'i32' type",
+		"25: Function 'counted' expected to return 'i32' type",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("\n got %q\nwant %q", got, want)
+	}
+}
+
+// module writes the source files of a program into a temporary directory and
+// returns the path of the first.
+func module(t *testing.T, files ...string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for i := 0; i < len(files); i += 2 {
+		path := filepath.Join(dir, filepath.FromSlash(files[i]))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(files[i+1]), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return filepath.Join(dir, files[0])
+}
+
+// Private names of different modules don't clash, in the program or in the
+// generated code.
+func TestModuleNamespaces(t *testing.T) {
+	path := module(t, "main.des", `
+import "util/a";
+struct Pair {
+  a:i64;
+}
+fn helper():i32 {
+  let p:Pair = Pair{a: 30};
+  return 30;
+}
+fn main():i32 {
+  return helper() + a.seven();
+}
+`, "util/a.des", `
+struct Pair {
+  x:i32;
+  y:i32;
+}
+fn helper():i32 {
+  let p:Pair = Pair{x: 3, y: 4};
+  return p.x + p.y;
+}
+pub fn seven():i32 {
+  return helper();
+}
+`)
+	if got := diagnostics(t, path); len(got) != 0 {
+		t.Fatalf("unexpected errors %q", got)
+	}
+	s := NewSession()
+	s.ParseFile(path)
+	out, ok := s.Emit(OUTPUT_LLVM)
+	if !ok {
+		t.Fatalf("errors %v", s.Diagnostics.Errors())
+	}
+	for _, want := range []string{
+		"%a_Pair = type { i32, i32 }",
+		"%Pair = type { i64 }",
+		"define internal i32 @a_helper()",
+		"define i32 @a_seven()",
+		"define internal i32 @helper()",
+		"define i32 @main()",
+		"call i32 @a_helper()",
+	} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("missing %q in\n%s", want, out)
+		}
+	}
+}
+
+// Modules may declare the same public name, it is qualified where used and
+// linked under the name of its module.
+func TestPublicNames(t *testing.T) {
+	path := module(t, "main.des", `
+import "a/m";
+import "a/s";
+import "b/m" as bm;
+fn main():i32 {
+  return m.copy() + s.copy() + bm.copy();
+}
+`, "a/m.des", `
+pub fn copy():i32 {
+  return 1;
+}
+`, "a/s.des", `
+pub fn copy():i32 {
+  return 2;
+}
+`, "b/m.des", `
+pub fn copy():i32 {
+  return 4;
+}
+`)
+	if got := diagnostics(t, path); len(got) != 0 {
+		t.Fatalf("unexpected errors %q", got)
+	}
+	s := NewSession()
+	s.ParseFile(path)
+	out, ok := s.Emit(OUTPUT_LLVM)
+	if !ok {
+		t.Fatalf("errors %v", s.Diagnostics.Errors())
+	}
+	for _, want := range []string{
+		"define i32 @m_copy()",
+		"define i32 @s_copy()",
+		"define i32 @m_copy_1()",
+		"call i32 @m_copy()",
+		"call i32 @s_copy()",
+		"call i32 @m_copy_1()",
+	} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("missing %q in\n%s", want, out)
+		}
+	}
+}
+
+// Modules named alike must be renamed with 'as'.
+func TestImportNames(t *testing.T) {
+	path := module(t, "main.des", `
+import "a/m";
+import "b/m";
+fn main():i32 {
+  return 0;
+}
+`, "a/m.des", "", "b/m.des", "")
+	want := []string{"3: Module 'm' is imported more than once, rename one with 'as'"}
+	if got := diagnostics(t, path); !reflect.DeepEqual(got, want) {
+		t.Errorf("\n got %q\nwant %q", got, want)
+	}
+}
@@ -0,0 +1,271 @@
+package elf
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Values from the System V ABI and its x86_64 supplement.
+const (
+	SHT_NULL     = 0
+	SHT_PROGBITS = 1
+	SHT_SYMTAB   = 2
+	SHT_STRTAB   = 3
+	SHT_RELA     = 4
+	SHT_NOBITS   = 8
+
+	SHF_WRITE     = 0x1
+	SHF_ALLOC     = 0x2
+	SHF_EXECINSTR = 0x4
+	SHF_INFO_LINK = 0x40
+
+	STB_LOCAL  = 0
+	STB_GLOBAL = 1
+
+	STT_NOTYPE  = 0
+	STT_OBJECT  = 1
+	STT_FUNC    = 2
+	STT_SECTION = 3
+	STT_FILE    = 4
+
+	R_X86_64_64    = 1
+	R_X86_64_PC32  = 2
+	R_X86_64_PLT32 = 4
+	R_X86_64_32    = 10
+
+	SHN_UNDEF = 0
+	SHN_ABS   = 0xfff1
+)
+
+const (
+	headerSize        = 64
+	sectionHeaderSize = 64
+	symbolSize        = 24
+	relaSize          = 24
+)
+
+type Reloc struct {
+	Offset uint64
+	Symbol *Symbol
+	Type   uint32
+	Addend int64
+}
+
+type Section struct {
+	Name   string
+	Type   uint32
+	Flags  uint64
+	Align  uint64
+	Data   []byte
+	Relocs []Reloc
+	Symbol *Symbol // section symbol, used as a relocation target
+	index  int
+}
+
+type Symbol struct {
+	Name    string
+	Section *Section // nil for undefined symbols
+	Value   uint64
+	Size    uint64
+	Bind    uint8
+	Type    uint8
+	index   int
+}
+
+// File is a relocatable ELF64 object for x86_64.
+type File struct {
+	Sections []*Section
+	Symbols  []*Symbol
+}
+
+func New() *File {
+	return &File{}
+}
+
+func (f *File) AddSection(name string, typ uint32, flags uint64, align uint64) *Section {
+	sec := &Section{Name: name, Type: typ, Flags: flags, Align: align}
+	sec.Symbol = &Symbol{Section: sec, Bind: STB_LOCAL, Type: STT_SECTION}
+	f.Sections = append(f.Sections, sec)
+	f.Symbols = append(f.Symbols, sec.Symbol)
+	return sec
+}
+func (f *File) AddSymbol(name string, sec *Section, value uint64, size uint64, bind uint8, typ uint8) *Symbol {
+	sym := &Symbol{Name: name, Section: sec, Value: value, Size: size, Bind: bind, Type: typ}
+	f.Symbols = append(f.Symbols, sym)
+	return sym
+}
+
+// Lookup returns the symbol named 'name' or nil.
+func (f *File) Lookup(name string) *Symbol {
+	for _, sym := range f.Symbols {
+		if sym.Type != STT_SECTION && sym.Name == name {
+			return sym
+		}
+	}
+	return nil
+}
+
+type stringTable struct {
+	data    []byte
+	offsets map[string]uint32
+}
+
+func newStringTable() *stringTable {
+	return &stringTable{data: []byte{0}, offsets: map[string]uint32{"": 0}}
+}
+func (st *stringTable) add(s string) uint32 {
+	if off, ok := st.offsets[s]; ok {
+		return off
+	}
+	off := uint32(len(st.data))
+	st.data = append(st.data, s...)
+	st.data = append(st.data, 0)
+	st.offsets[s] = off
+	return off
+}
+
+type sectionHeader struct {
+	Name      uint32
+	Type      uint32
+	Flags     uint64
+	Addr      uint64
+	Offset    uint64
+	Size      uint64
+	Link      uint32
+	Info      uint32
+	AddrAlign uint64
+	EntSize   uint64
+}
+
+// Bytes serializes the object file.
+func (f *File) Bytes() []byte {
+	shstrtab := newStringTable()
+	strtab := newStringTable()
+
+	// Locals must precede globals in the symbol table.
+	symbols := []*Symbol{}
+	for _, sym := range f.Symbols {
+		if sym.Bind == STB_LOCAL {
+			symbols = append(symbols, sym)
+		}
+	}
+	firstGlobal := len(symbols) + 1
+	for _, sym := range f.Symbols {
+		if sym.Bind != STB_LOCAL {
+			symbols = append(symbols, sym)
+		}
+	}
+	for i, sym := range symbols {
+		sym.index = i + 1
+	}
+	for i, sec := range f.Sections {
+		sec.index = i + 1
+	}
+
+	var symtab bytes.Buffer
+	symtab.Write(make([]byte, symbolSize))
+	for _, sym := range symbols {
+		shndx := uint16(SHN_UNDEF)
+		if sym.Section != nil {
+			shndx = uint16(sym.Section.index)
+		}
+		binary.Write(&symtab, binary.LittleEndian, struct {
+			Name  uint32
+			Info  uint8
+			Other uint8
+			Shndx uint16
+			Value uint64
+			Size  uint64
+		}{strtab.add(sym.Name), sym.Bind<<4 | sym.Type, 0, shndx, sym.Value, sym.Size})
+	}
+
+	headers := []sectionHeader{{}}
+	var body bytes.Buffer
+	place := func(data []byte, align uint64) uint64 {
+		if align == 0 {
+			align = 1
+		}
+		for uint64(headerSize+body.Len())%align != 0 {
+			body.WriteByte(0)
+		}
+		off := uint64(headerSize + body.Len())
+		body.Write(data)
+		return off
+	}
+	for _, sec := range f.Sections {
+		off := place(sec.Data, sec.Align)
+		headers = append(headers, sectionHeader{
+			Name: shstrtab.add(sec.Name), Type: sec.Type, Flags: sec.Flags,
+			Offset: off, Size: uint64(len(sec.Data)), AddrAlign: sec.Align,
+		})
+	}
+	symtabIndex := len(headers) + countRela(f.Sections)
+	for _, sec := range f.Sections {
+		if len(sec.Relocs) == 0 {
+			continue
+		}
+		var rela bytes.Buffer
+		for _, r := range sec.Relocs {
+			binary.Write(&rela, binary.LittleEndian, struct {
+				Offset uint64
+				Info   uint64
+				Addend int64
+			}{r.Offset, uint64(r.Symbol.index)<<32 | uint64(r.Type), r.Addend})
+		}
+		off := place(rela.Bytes(), 8)
+		headers = append(headers, sectionHeader{
+			Name: shstrtab.add(".rela" + sec.Name), Type: SHT_RELA, Flags: SHF_INFO_LINK,
+			Offset: off, Size: uint64(rela.Len()), Link: uint32(symtabIndex),
+			Info: uint32(sec.index), AddrAlign: 8, EntSize: relaSize,
+		})
+	}
+	off := place(symtab.Bytes(), 8)
+	headers = append(headers, sectionHeader{
+		Name: shstrtab.add(".symtab"), Type: SHT_SYMTAB, Offset: off, Size: uint64(symtab.Len()),
+		Link: uint32(symtabIndex + 1), Info: uint32(firstGlobal), AddrAlign: 8, EntSize: symbolSize,
+	})
+	off = place(strtab.data, 1)
+	headers = append(headers, sectionHeader{
+		Name: shstrtab.add(".strtab"), Type: SHT_STRTAB, Offset: off, Size: uint64(len(strtab.data)), AddrAlign: 1,
+	})
+	shstrtabName := shstrtab.add(".shstrtab")
+	off = place(shstrtab.data, 1)
+	headers = append(headers, sectionHeader{
+		Name: shstrtabName, Type: SHT_STRTAB, Offset: off, Size: uint64(len(shstrtab.data)), AddrAlign: 1,
+	})
+	shoff := place(nil, 8)
+
+	var out bytes.Buffer
+	ident := [16]byte{0x7f, 'E', 'L', 'F', 2 /* 64 bit */, 1 /* little endian */, 1 /* version */}
+	out.Write(ident[:])
+	binary.Write(&out, binary.LittleEndian, struct {
+		Type      uint16
+		Machine   uint16
+		Version   uint32
+		Entry     uint64
+		Phoff     uint64
+		Shoff     uint64
+		Flags     uint32
+		Ehsize    uint16
+		Phentsize uint16
+		Phnum     uint16
+		Shentsize uint16
+		Shnum     uint16
+		Shstrndx  uint16
+	}{1 /* ET_REL */, 62 /* EM_X86_64 */, 1, 0, 0, shoff, 0, headerSize, 0, 0, sectionHeaderSize, uint16(len(headers)), uint16(len(headers) - 1)})
+	out.Write(body.Bytes())
+	for _, h := range headers {
+		binary.Write(&out, binary.LittleEndian, h)
+	}
+	return out.Bytes()
+}
+
+func countRela(sections []*Section) int {
+	count := 0
+	for _, sec := range sections {
+		if len(sec.Relocs) > 0 {
+			count++
+		}
+	}
+	return count
+}
@@ -27,6 +27,11 @@ func (bag DiagnosticBag) PrintErrors() {
 	}
 	print(colorReset)
 }
+
+// Errors returns the diagnostics reported so far.
+func (bag DiagnosticBag) Errors() []Error {
+	return bag.errors
+}
 func (bag DiagnosticBag) GotErrors() bool {
 	return len(bag.errors) > 0
 }
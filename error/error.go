@@ -8,5 +8,11 @@ type Error struct {
 }
 
 func (e Error) Error() string {
+	if e.Pos.Line == 0 {
+		return fmt.Sprintf("ERROR:%s.", e.Msg)
+	}
+	if e.Pos.File != "" {
+		return fmt.Sprintf("ERROR:(%d,%d):%s at line %d in '%s'.", e.Pos.Start, e.Pos.End, e.Msg, e.Pos.Line, e.Pos.File)
+	}
 	return fmt.Sprintf("ERROR:(%d,%d):%s at line %d.", e.Pos.Start, e.Pos.End, e.Msg, e.Pos.Line)
 }
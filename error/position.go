@@ -1,7 +1,9 @@
 package error
 
 type Position struct {
-	Start int
-	End   int
-	Line  int
+	File   string // source file, empty for a single unnamed source
+	Start  int
+	End    int
+	Line   int
+	Column int // 1-based column of Start
 }
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// newFlags creates the flag set of a subcommand, every one of them accepts --verbose.
+func newFlags(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.BoolVar(&verbose, "verbose", verbose, "print the compiler stages as they run")
+	for i := range commands {
+		if cmd := &commands[i]; cmd.name == name {
+			flags.Usage = func() {
+				fmt.Fprintf(flags.Output(), "Usage: %s\n\n%s.\n\nFlags:\n", strings.Join(strings.Fields(strings.Join([]string{os.Args[0], cmd.name, cmd.args, cmd.file}, " ")), " "), cmd.short)
+				flags.PrintDefaults()
+			}
+		}
+	}
+	return flags
+}
+
+// parseFile parses the flags of a subcommand, which takes exactly one file.
+func parseFile(flags *flag.FlagSet, args []string) (string, bool) {
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return "", false
+	}
+	return flags.Arg(0), true
+}
+
+// optFlags registers -O0, -O1 and -O2 and returns the highest level given.
+func optFlags(flags *flag.FlagSet) func() int {
+	levels := [...]*bool{
+		flags.Bool("O0", false, "disable optimizations"),
+		flags.Bool("O1", false, "promote locals to registers and fold constants"),
+		flags.Bool("O2", false, "run -O1 passes to a fixed point and simplify the control flow graph"),
+	}
+	return func() int {
+		optLevel := 0
+		for level, set := range levels {
+			if *set {
+				optLevel = level
+			}
+		}
+		return optLevel
+	}
+}
+
+// listFlag collects every value of a flag that may be repeated.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// splitLibFlags accepts the "-lm" and "-L/dir" spellings of C compilers by
+// separating the value from the flag.
+func splitLibFlags(args []string) []string {
+	result := make([]string, 0, len(args))
+	for _, arg := range args {
+		if len(arg) > 2 && (arg[:2] == "-l" || arg[:2] == "-L") && arg[2] != '=' {
+			result = append(result, arg[:2], arg[2:])
+			continue
+		}
+		result = append(result, arg)
+	}
+	return result
+}
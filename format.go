@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/s0h1s2/error"
+	"github.com/s0h1s2/format"
+	"github.com/s0h1s2/manifest"
+)
+
+func fmtCmd(args []string) int {
+	flags := newFlags("fmt")
+	write := flags.Bool("w", false, "write the result to the source files instead of stdout")
+	diff := flags.Bool("d", false, "print diffs of the files that aren't formatted and fail")
+	flags.Parse(args)
+	paths := flags.Args()
+	if len(paths) == 0 {
+		path, ok := manifest.Find(".")
+		if !ok {
+			fmt.Fprintf(os.Stderr, "No file given and no %s in the current directory or its parents.\n", manifest.FILE_NAME)
+			return 2
+		}
+		bag := error.New()
+		pkg := manifest.Load(path, bag)
+		if bag.GotErrors() {
+			bag.PrintErrors()
+			return 1
+		}
+		paths = pkg.Roots()
+	}
+	files, ok := sourceFiles(paths)
+	if !ok {
+		return 1
+	}
+	status := 0
+	for _, file := range files {
+		src, ok := readSource(file)
+		if !ok {
+			status = 1
+			continue
+		}
+		bag := error.New()
+		out := format.Source(file, src, bag)
+		if bag.GotErrors() {
+			bag.PrintErrors()
+			status = 1
+			continue
+		}
+		if *diff && !bytes.Equal(src, out) {
+			fmt.Print(format.Diff(file, src, out))
+			status = 1
+		}
+		if *write {
+			if !bytes.Equal(src, out) {
+				if err := os.WriteFile(file, out, 0644); err != nil {
+					fmt.Fprintf(os.Stderr, "Unable to write '%s'\n", file)
+					status = 1
+				}
+			}
+		} else if !*diff {
+			os.Stdout.Write(out)
+		}
+	}
+	return status
+}
+
+// sourceFiles lists the files in 'paths', the .des files of the directories among
+// them included.
+func sourceFiles(paths []string) ([]string, bool) {
+	files := []string{}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to read '%s'\n", path)
+			return nil, false
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		files = appendDir(files, path)
+	}
+	return files, true
+}
+func appendDir(files []string, dir string) []string {
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if entry.IsDir() {
+			files = appendDir(files, path)
+		} else if filepath.Ext(path) == ".des" {
+			files = append(files, path)
+		}
+	}
+	return files
+}
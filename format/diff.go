@@ -0,0 +1,114 @@
+package format
+
+import (
+	"fmt"
+	"strings"
+)
+
+const CONTEXT = 3 // unchanged lines around every change
+
+type edit struct {
+	kind byte // ' ', '-' or '+'
+	line string
+	a, b int // line numbers before and after, counted from 0
+}
+
+func lines(text []byte) []string {
+	list := strings.SplitAfter(string(text), "\n")
+	if list[len(list)-1] == "" {
+		list = list[:len(list)-1]
+	}
+	return list
+}
+
+// script lists the edits turning 'a' into 'b' along a longest common subsequence.
+func script(a []string, b []string) []edit {
+	common := make([][]int, len(a)+1)
+	for i := range common {
+		common[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else {
+				common[i][j] = max(common[i+1][j], common[i][j+1])
+			}
+		}
+	}
+	edits := []edit{}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			{
+				edits = append(edits, edit{' ', a[i], i, j})
+				i++
+				j++
+			}
+		case j == len(b) || i < len(a) && common[i+1][j] >= common[i][j+1]:
+			{
+				edits = append(edits, edit{'-', a[i], i, j})
+				i++
+			}
+		default:
+			{
+				edits = append(edits, edit{'+', b[j], i, j})
+				j++
+			}
+		}
+	}
+	return edits
+}
+
+// Diff returns the unified diff from 'before' to 'after' of the file at 'path',
+// or "" when they are the same.
+func Diff(path string, before []byte, after []byte) string {
+	edits := script(lines(before), lines(after))
+	var sb strings.Builder
+	for start := 0; start < len(edits); {
+		if edits[start].kind == ' ' {
+			start++
+			continue
+		}
+		// Grow the hunk while the next change is close enough to share context.
+		end := start
+		for k := start; k < len(edits) && k <= end+2*CONTEXT; k++ {
+			if edits[k].kind != ' ' {
+				end = k
+			}
+		}
+		from, to := max(start-CONTEXT, 0), min(end+CONTEXT+1, len(edits))
+		if sb.Len() == 0 {
+			fmt.Fprintf(&sb, "--- %s.orig\n+++ %s\n", path, path)
+		}
+		removed, added := 0, 0
+		for _, e := range edits[from:to] {
+			if e.kind != '+' {
+				removed++
+			}
+			if e.kind != '-' {
+				added++
+			}
+		}
+		fmt.Fprintf(&sb, "@@ -%s +%s @@\n", hunkRange(edits[from].a, removed), hunkRange(edits[from].b, added))
+		for _, e := range edits[from:to] {
+			sb.WriteByte(e.kind)
+			sb.WriteString(e.line)
+			if !strings.HasSuffix(e.line, "\n") {
+				sb.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		start = to
+	}
+	return sb.String()
+}
+
+// hunkRange prints the lines a hunk covers on one side, an empty side is
+// numbered after the line it follows.
+func hunkRange(line int, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", line)
+	}
+	return fmt.Sprintf("%d,%d", line+1, count)
+}
@@ -0,0 +1,321 @@
+package format
+
+import (
+	"strings"
+
+	"github.com/s0h1s2/ast"
+	"github.com/s0h1s2/error"
+	"github.com/s0h1s2/lexer"
+	"github.com/s0h1s2/parser"
+	"github.com/s0h1s2/token"
+)
+
+const INDENT = "  "
+
+// Precedence of the expressions, the operands of an operator bind tighter than
+// the operator itself or are parenthesized.
+const (
+	PREC_ASSIGN = iota + 1
+	PREC_COMPARE
+	PREC_TERM
+	PREC_FACTOR
+	PREC_UNARY
+	PREC_BASE
+)
+
+// Source reprints the source file at 'path' in the canonical layout, keeping
+// its comments. Syntax errors are reported to 'bag' and leave the result nil.
+func Source(path string, src []byte, bag *error.DiagnosticBag) []byte {
+	reported := len(bag.Errors())
+	lex := lexer.New(bag)
+	lex.SetFile(path)
+	tokens := lex.GetTokens(src)
+	if len(bag.Errors()) > reported {
+		return nil
+	}
+	p := parser.New(tokens, bag)
+	p.KeepComments(lex.Comments())
+	decls := p.Parse()
+	if len(bag.Errors()) > reported {
+		return nil
+	}
+	return []byte(Decls(decls, p.Footer()))
+}
+
+// Decls prints declarations in the canonical layout, followed by the comments
+// that end the file.
+func Decls(decls []ast.Decl, footer []*ast.Comment) string {
+	pr := &printer{}
+	for i, decl := range decls {
+		node, ok := decl.(ast.Commented)
+		if !ok {
+			continue
+		}
+		pr.leading(node.GetComments(), i == 0, decl.GetPos().Line)
+		pr.decl(decl)
+		pr.trailing(node.GetComments())
+	}
+	pr.comments(footer)
+	return pr.sb.String()
+}
+
+type printer struct {
+	sb    strings.Builder
+	depth int
+}
+
+func (p *printer) indent() {
+	p.sb.WriteString(strings.Repeat(INDENT, p.depth))
+}
+func (p *printer) write(text string) {
+	p.sb.WriteString(text)
+}
+
+// leading prints the comments before a node on the line at 'line', keeping a
+// single empty line where the source has any.
+func (p *printer) leading(c *ast.Comments, first bool, line int) {
+	if c.Blank && !first {
+		p.write("\n")
+	}
+	for i, comment := range c.Leading {
+		p.indent()
+		p.write(comment.Text + "\n")
+		next := line
+		if i+1 < len(c.Leading) {
+			next = c.Leading[i+1].Pos.Line
+		}
+		if next > comment.Pos.Line+1 {
+			p.write("\n")
+		}
+	}
+	p.indent()
+}
+
+// trailing ends the line of a node with its trailing comment.
+func (p *printer) trailing(c *ast.Comments) {
+	if c.Trailing != nil {
+		p.write(" " + c.Trailing.Text)
+	}
+	p.write("\n")
+}
+func (p *printer) comments(list []*ast.Comment) {
+	for _, comment := range list {
+		p.indent()
+		p.write(comment.Text + "\n")
+	}
+}
+func pub(isPub bool) string {
+	if isPub {
+		return "pub "
+	}
+	return ""
+}
+func header(name string, params []ast.Field, ret ast.TypeSpec) string {
+	list := make([]string, len(params))
+	for i, param := range params {
+		list[i] = param.Name + ":" + ast.TypeString(param.Type)
+	}
+	return name + "(" + strings.Join(list, ", ") + "):" + ast.TypeString(ret)
+}
+
+func (p *printer) decl(decl ast.Decl) {
+	switch node := decl.(type) {
+	case *ast.DeclImport:
+		{
+			if node.Alias {
+				p.write("import \"" + node.Path + "\" as " + node.Name + ";")
+			} else {
+				p.write("import \"" + node.Path + "\";")
+			}
+		}
+	case *ast.DeclExternalFunction:
+		{
+			p.write(pub(node.Pub) + "extern fn " + header(node.Name, node.Parameters, node.ReturnType) + ";")
+		}
+	case *ast.DeclFunction:
+		{
+			p.write(pub(node.Pub) + "fn " + header(node.Name, node.Parameters, node.RetType) + " ")
+			p.block(node.Body)
+		}
+	case *ast.DeclStruct:
+		{
+			p.write(pub(node.Pub) + "struct " + node.Name + " {")
+			if len(node.Fields) == 0 && len(node.Footer) == 0 {
+				p.write("}")
+				return
+			}
+			p.write("\n")
+			p.depth++
+			for i, field := range node.Fields {
+				p.leading(&field.Comments, i == 0, field.Pos.Line)
+				p.write(field.Name + ":" + ast.TypeString(field.Type) + ";")
+				p.trailing(&field.Comments)
+			}
+			p.comments(node.Footer)
+			p.depth--
+			p.indent()
+			p.write("}")
+		}
+	}
+}
+
+// block prints a block from its opening brace, which the caller places, to its
+// closing brace.
+func (p *printer) block(block *ast.StmtBlock) {
+	p.write("{")
+	if len(block.Block) == 0 && len(block.Footer) == 0 {
+		p.write("}")
+		return
+	}
+	p.write("\n")
+	p.depth++
+	for i, stmt := range block.Block {
+		c := stmt.(ast.Commented).GetComments()
+		p.leading(c, i == 0, stmt.GetPos().Line)
+		p.stmt(stmt)
+		p.trailing(c)
+	}
+	p.comments(block.Footer)
+	p.depth--
+	p.indent()
+	p.write("}")
+}
+
+func (p *printer) stmt(stmt ast.Stmt) {
+	switch node := stmt.(type) {
+	case *ast.StmtLet:
+		{
+			p.write("let " + node.Name + ":" + ast.TypeString(node.Type))
+			if node.Init != nil {
+				p.write(" = " + p.expr(node.Init, PREC_ASSIGN))
+			}
+			p.write(";")
+		}
+	case *ast.StmtReturn:
+		{
+			p.write("return")
+			if node.Result != nil {
+				p.write(" " + p.expr(node.Result, PREC_ASSIGN))
+			}
+			p.write(";")
+		}
+	case *ast.StmtExpr:
+		{
+			p.write(p.expr(node.Expr, PREC_ASSIGN) + ";")
+		}
+	case *ast.StmtBlock:
+		{
+			p.block(node)
+		}
+	case *ast.StmtWhile:
+		{
+			p.write("while " + p.expr(node.Cond, PREC_ASSIGN) + " ")
+			p.block(node.Body)
+		}
+	case *ast.StmtFor:
+		{
+			p.write("for ")
+			if node.Init != nil {
+				p.stmt(node.Init)
+			} else {
+				p.write(";")
+			}
+			if node.Cond != nil {
+				p.write(" " + p.expr(node.Cond, PREC_ASSIGN))
+			}
+			p.write(";")
+			if node.Post != nil {
+				p.write(" " + p.expr(node.Post, PREC_ASSIGN))
+			}
+			p.write(" ")
+			p.block(node.Body)
+		}
+	case *ast.StmtBreak:
+		{
+			p.write("break;")
+		}
+	case *ast.StmtContinue:
+		{
+			p.write("continue;")
+		}
+	case *ast.StmtIf:
+		{
+			p.write("if " + p.expr(node.Cond, PREC_ASSIGN) + " ")
+			p.block(node.Then)
+			if node.Else != nil {
+				p.write(" else ")
+				p.stmt(node.Else)
+			}
+		}
+	}
+}
+
+func precedence(op token.TokenKind) int {
+	switch op {
+	case token.TK_PLUS:
+		return PREC_TERM
+	case token.TK_STAR:
+		return PREC_FACTOR
+	}
+	return PREC_COMPARE
+}
+
+// expr prints an expression that must bind at least as tight as 'prec'.
+func (p *printer) expr(expr ast.Expr, prec int) string {
+	text, own := p.operation(expr)
+	if own < prec {
+		return "(" + text + ")"
+	}
+	return text
+}
+
+// operation prints an expression and returns the precedence it binds with.
+func (p *printer) operation(expr ast.Expr) (string, int) {
+	switch node := expr.(type) {
+	case *ast.ExprAssign:
+		return p.expr(node.Left, PREC_COMPARE) + " = " + p.expr(node.Right, PREC_ASSIGN), PREC_ASSIGN
+	case *ast.ExprBinary:
+		{
+			prec := precedence(node.Op)
+			return p.expr(node.Left, prec) + " " + node.Op.String() + " " + p.expr(node.Right, prec+1), prec
+		}
+	case *ast.ExprUnary:
+		return node.Op.String() + p.expr(node.Right, PREC_UNARY), PREC_UNARY
+	case *ast.ExprCall:
+		{
+			args := make([]string, len(node.Args))
+			for i, arg := range node.Args {
+				args[i] = p.expr(arg, PREC_ASSIGN)
+			}
+			name := node.Name
+			if node.Module != "" {
+				name = node.Module + "." + name
+			}
+			return name + "(" + strings.Join(args, ", ") + ")", PREC_BASE
+		}
+	case *ast.ExprField:
+		return p.expr(node.Expr, PREC_BASE) + "." + node.Name, PREC_BASE
+	case *ast.ExprCompound:
+		{
+			fields := make([]string, len(node.Fields))
+			for i, field := range node.Fields {
+				fields[i] = field.Name + ": " + p.expr(field.Init, PREC_ASSIGN)
+			}
+			return ast.TypeString(node.Type) + "{" + strings.Join(fields, ", ") + "}", PREC_BASE
+		}
+	case *ast.ExprIdent:
+		return node.Name, PREC_BASE
+	case *ast.ExprInt:
+		return node.Value, PREC_BASE
+	case *ast.ExprBoolean:
+		{
+			if node.Value {
+				return "true", PREC_BASE
+			}
+			return "false", PREC_BASE
+		}
+	case *ast.ExprString:
+		return "\"" + node.Value + "\"", PREC_BASE
+	}
+	return "", PREC_BASE
+}
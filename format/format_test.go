@@ -0,0 +1,54 @@
+package format
+
+import (
+	"testing"
+
+	"github.com/s0h1s2/error"
+)
+
+// A comment after a closing brace stays after it, comments inside the block
+// stay with their statements.
+func TestCommentsAfterBraces(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{
+			"fn main():i32 {\n  let a:i32 = 1;\n  if a == 1 { a = 2; } // after if\n  return a;\n}\n",
+			"fn main():i32 {\n  let a:i32 = 1;\n  if a == 1 {\n    a = 2;\n  } // after if\n  return a;\n}\n",
+		},
+		{
+			"fn main():i32 {\n  let a:i32 = 1;\n  if a == 1 { a = 2; } else { a = 3; } // after else\n  return a;\n}\n",
+			"fn main():i32 {\n  let a:i32 = 1;\n  if a == 1 {\n    a = 2;\n  } else {\n    a = 3;\n  } // after else\n  return a;\n}\n",
+		},
+		{
+			"fn main():i32 {\n  let a:i32 = 1;\n  { a = 4; } // after block\n  return a;\n}\n",
+			"fn main():i32 {\n  let a:i32 = 1;\n  {\n    a = 4;\n  } // after block\n  return a;\n}\n",
+		},
+		{
+			"fn main():i32 {\n  let a:i32 = 1;\n  while a < 3 { a = a + 1; } // after while\n  return a;\n}\n",
+			"fn main():i32 {\n  let a:i32 = 1;\n  while a < 3 {\n    a = a + 1;\n  } // after while\n  return a;\n}\n",
+		},
+		{
+			"fn main():i32 {\n  let a:i32 = 1;\n  for let i:i32 = 0; i < 3; i = i + 1 { a = a + i; } // after for\n  return a;\n}\n",
+			"fn main():i32 {\n  let a:i32 = 1;\n  for let i:i32 = 0; i < 3; i = i + 1 {\n    a = a + i;\n  } // after for\n  return a;\n}\n",
+		},
+		{
+			"fn main():i32 {\n  let a:i32 = 1;\n  if a == 1 {\n    a = 2; // inside\n  }\n  return a; // after return\n} // after main\n",
+			"fn main():i32 {\n  let a:i32 = 1;\n  if a == 1 {\n    a = 2; // inside\n  }\n  return a; // after return\n} // after main\n",
+		},
+	}
+	for _, test := range tests {
+		bag := error.New()
+		got := string(Source("test.des", []byte(test.src), bag))
+		if errors := bag.Errors(); len(errors) > 0 {
+			t.Fatalf("%q: %v", test.src, errors)
+		}
+		if got != test.want {
+			t.Errorf("%q:\ngot\n%s\nwant\n%s", test.src, got, test.want)
+		}
+		if again := string(Source("test.des", []byte(got), bag)); again != got {
+			t.Errorf("%q: not stable, reprinted as\n%s", test.src, again)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package interp
+
+import (
+	"fmt"
+	"strings"
+)
+
+// builtins stand in for the C functions programs commonly declare with 'extern fn'.
+// The extern may declare any parameters, the arguments are checked here.
+var builtins = map[string]func(in *Interpreter, args []Value) Value{
+	"puts": func(in *Interpreter, args []Value) Value {
+		n, _ := fmt.Fprintln(in.out, stringArg("puts", args, 0))
+		return int64(n)
+	},
+	"putchar": func(in *Interpreter, args []Value) Value {
+		c := intArg("putchar", args, 0)
+		in.out.Write([]byte{byte(c)})
+		return c
+	},
+	"printf": func(in *Interpreter, args []Value) Value {
+		n, _ := fmt.Fprint(in.out, CFormat(stringArg("printf", args, 0), args[1:]))
+		return int64(n)
+	},
+	"exit": func(in *Interpreter, args []Value) Value {
+		panic(exitError(intArg("exit", args, 0)))
+	},
+}
+
+// intArg returns argument 'i' of the builtin 'name' as an integer.
+func intArg(name string, args []Value, i int) int64 {
+	if i < len(args) {
+		switch v := args[i].(type) {
+		case int64:
+			return v
+		case bool:
+			if v {
+				return 1
+			}
+			return 0
+		}
+	}
+	panic(runtimeError(fmt.Sprintf("argument %d of '%s' must be an integer", i+1, name)))
+}
+
+// stringArg returns argument 'i' of the builtin 'name' as a string.
+func stringArg(name string, args []Value, i int) string {
+	if i < len(args) {
+		if s, ok := args[i].(string); ok {
+			return s
+		}
+	}
+	panic(runtimeError(fmt.Sprintf("argument %d of '%s' must be a string", i+1, name)))
+}
+
+// exitError unwinds the interpreter when the program calls exit.
+type exitError int64
+
+func (e exitError) Error() string {
+	return fmt.Sprintf("exit status %d", int64(e))
+}
+
+// ExitStatus returns the status passed to exit when that is what ended a call.
+func ExitStatus(err error) (int, bool) {
+	code, ok := err.(exitError)
+	return int(uint8(code)), ok
+}
+
+// CFormat implements the subset of printf conversions Dennis values can satisfy.
+func CFormat(format string, args []Value) string {
+	var sb strings.Builder
+	next := 0
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			sb.WriteByte(format[i])
+			continue
+		}
+		j := i + 1
+		for j < len(format) && strings.IndexByte("-+ #0123456789.", format[j]) >= 0 {
+			j++
+		}
+		flags := format[i+1 : j]
+		for j < len(format) && strings.IndexByte("hlqjzt", format[j]) >= 0 {
+			j++
+		}
+		if j >= len(format) {
+			sb.WriteString(format[i:])
+			break
+		}
+		verb := format[j]
+		i = j
+		if verb == '%' {
+			sb.WriteByte('%')
+			continue
+		}
+		if next >= len(args) {
+			sb.WriteString("%!" + string(verb) + "(MISSING)")
+			continue
+		}
+		arg := args[next]
+		next++
+		switch verb {
+		case 'd', 'i', 'u':
+			verb = 'd'
+		case 'c':
+			if n, ok := arg.(int64); ok {
+				arg = rune(n)
+			}
+		case 'p':
+			verb = 'v'
+			arg = Format(arg)
+		}
+		if b, ok := arg.(bool); ok {
+			arg = 0
+			if b {
+				arg = 1
+			}
+		}
+		fmt.Fprintf(&sb, "%"+flags+string(verb), arg)
+	}
+	return sb.String()
+}
@@ -0,0 +1,33 @@
+package interp_test
+
+import (
+	"io"
+	"testing"
+
+	"github.com/s0h1s2/compiler"
+	"github.com/s0h1s2/interp"
+)
+
+// Externs may declare a builtin with any parameters, arguments it can't use
+// are runtime errors rather than a crash of the interpreter.
+func TestBuiltinArguments(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"extern fn printf(x:i64):i32;\nfn main():i32 {\n  printf(5);\n  return 0;\n}\n", "argument 1 of 'printf' must be a string"},
+		{"extern fn putchar(s:string):i32;\nfn main():i32 {\n  putchar(\"a\");\n  return 0;\n}\n", "argument 1 of 'putchar' must be an integer"},
+		{"extern fn exit():void;\nfn main():i32 {\n  exit();\n  return 0;\n}\n", "argument 1 of 'exit' must be an integer"},
+		{"extern fn puts(n:i32):i32;\nfn main():i32 {\n  puts(1);\n  return 0;\n}\n", "argument 1 of 'puts' must be a string"},
+	}
+	for _, test := range tests {
+		s := compiler.NewSession()
+		if !s.Parse("test.des", []byte(test.src)) || !s.Check() {
+			t.Fatalf("%q: errors %v", test.src, s.Diagnostics.Errors())
+		}
+		_, err := interp.Run(s.Decls, s.Table, io.Discard)
+		if err == nil || err.Error() != test.want {
+			t.Errorf("%q: got error %v, want %q", test.src, err, test.want)
+		}
+	}
+}
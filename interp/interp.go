@@ -0,0 +1,359 @@
+package interp
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/s0h1s2/resolver"
+	"github.com/s0h1s2/scope"
+	"github.com/s0h1s2/types"
+)
+
+type runtimeError string
+
+func (e runtimeError) Error() string {
+	return string(e)
+}
+
+// control tells enclosing statements how execution left a statement.
+type control int
+
+const (
+	CONTROL_NEXT control = iota
+	CONTROL_RETURN
+	CONTROL_BREAK
+	CONTROL_CONTINUE
+)
+
+type frame struct {
+	cells map[*scope.Object]*Cell
+}
+
+type Interpreter struct {
+	table     *resolver.Table
+	functions map[string]*resolver.DeclFunction
+	externs   map[string]*resolver.DeclExternalFunction
+	out       io.Writer
+	frame     *frame
+	top       *frame // variables of the statements Exec runs
+	depth     int
+}
+
+const maxCallDepth = 10000
+
+func New(decls []resolver.DeclNode, table *resolver.Table, out io.Writer) *Interpreter {
+	in := &Interpreter{
+		table:     table,
+		functions: make(map[string]*resolver.DeclFunction),
+		externs:   make(map[string]*resolver.DeclExternalFunction),
+		out:       out,
+		top:       &frame{cells: make(map[*scope.Object]*Cell)},
+	}
+	in.Declare(decls)
+	return in
+}
+
+// Declare makes functions callable by later evaluations.
+func (in *Interpreter) Declare(decls []resolver.DeclNode) {
+	for _, decl := range decls {
+		switch node := decl.(type) {
+		case *resolver.DeclFunction:
+			in.functions[node.Link] = node
+		case *resolver.DeclExternalFunction:
+			in.externs[node.Name] = node
+		}
+	}
+}
+
+// Run calls 'main' and returns its result as the process exit code.
+func Run(decls []resolver.DeclNode, table *resolver.Table, out io.Writer) (int, error) {
+	in := New(decls, table, out)
+	if _, ok := in.functions["main"]; !ok {
+		return 1, fmt.Errorf("function 'main' not found")
+	}
+	result, err := in.Call("main", nil)
+	if code, ok := err.(exitError); ok {
+		return int(uint8(code)), nil
+	}
+	if err != nil {
+		return 1, err
+	}
+	if code, ok := result.(int64); ok {
+		return int(uint8(code)), nil
+	}
+	return 0, nil
+}
+
+// Call invokes a function by name and converts runtime failures into errors.
+func (in *Interpreter) Call(name string, args []Value) (result Value, err error) {
+	defer in.recover(&err)
+	return in.call(name, args), nil
+}
+
+// Exec runs a statement outside of any function, in a frame that outlives it,
+// and returns the value of an expression statement.
+func (in *Interpreter) Exec(stmt resolver.StmtNode) (result Value, err error) {
+	defer in.recover(&err)
+	in.frame = in.top
+	if node, ok := stmt.(*resolver.StmtExpr); ok {
+		return in.evalExpr(node.Expr), nil
+	}
+	in.execStmt(stmt)
+	return nil, nil
+}
+
+// recover turns the panics that unwind a failing program into '*err'.
+func (in *Interpreter) recover(err *error) {
+	if r := recover(); r != nil {
+		in.frame = nil
+		in.depth = 0
+		switch e := r.(type) {
+		case runtimeError:
+			*err = e
+		case exitError:
+			*err = e
+		default:
+			panic(r)
+		}
+	}
+}
+
+func (in *Interpreter) call(name string, args []Value) Value {
+	if ext, ok := in.externs[name]; ok {
+		builtin, ok := builtins[name]
+		if !ok {
+			panic(runtimeError(fmt.Sprintf("external function '%s' is not available in the interpreter", name)))
+		}
+		return truncate(builtin(in, args), ext.ReturnType)
+	}
+	fun, ok := in.functions[name]
+	if !ok {
+		panic(runtimeError(fmt.Sprintf("function '%s' not found", name)))
+	}
+	in.depth++
+	if in.depth > maxCallDepth {
+		panic(runtimeError("stack overflow"))
+	}
+	caller := in.frame
+	in.frame = &frame{cells: make(map[*scope.Object]*Cell)}
+	for i, param := range fun.Params {
+		in.frame.cells[fun.Scope.GetObj(param.Name)] = &Cell{Value: truncate(copyValue(args[i]), param.Type)}
+	}
+	ctl, result := in.execStmt(fun.Body)
+	in.frame = caller
+	in.depth--
+	if ctl != CONTROL_RETURN {
+		return zeroValue(fun.ReturnType)
+	}
+	return truncate(result, fun.ReturnType)
+}
+
+func (in *Interpreter) execStmt(stmt resolver.StmtNode) (control, Value) {
+	switch node := stmt.(type) {
+	case *resolver.StmtBlock:
+		{
+			for _, stmt := range node.Body {
+				if ctl, result := in.execStmt(stmt); ctl != CONTROL_NEXT {
+					return ctl, result
+				}
+			}
+		}
+	case *resolver.StmtLet:
+		{
+			value := zeroValue(node.Type)
+			if node.Init != nil {
+				value = truncate(copyValue(in.evalExpr(node.Init)), node.Type)
+			}
+			in.frame.cells[node.Scope.GetObj(node.Name)] = &Cell{Value: value}
+		}
+	case *resolver.StmtIf:
+		{
+			if in.evalExpr(node.Cond).(bool) {
+				return in.execStmt(node.Then)
+			}
+			if node.Else != nil {
+				return in.execStmt(node.Else)
+			}
+		}
+	case *resolver.StmtWhile:
+		{
+			for in.evalExpr(node.Cond).(bool) {
+				if ctl, result := in.execStmt(node.Body); ctl == CONTROL_BREAK {
+					break
+				} else if ctl == CONTROL_RETURN {
+					return ctl, result
+				}
+			}
+		}
+	case *resolver.StmtFor:
+		{
+			in.execStmt(node.Init)
+			for node.Cond == nil || in.evalExpr(node.Cond).(bool) {
+				if ctl, result := in.execStmt(node.Body); ctl == CONTROL_BREAK {
+					break
+				} else if ctl == CONTROL_RETURN {
+					return ctl, result
+				}
+				if node.Post != nil {
+					in.evalExpr(node.Post)
+				}
+			}
+		}
+	case *resolver.StmtBreak:
+		{
+			return CONTROL_BREAK, nil
+		}
+	case *resolver.StmtContinue:
+		{
+			return CONTROL_CONTINUE, nil
+		}
+	case *resolver.StmtReturn:
+		{
+			var result Value
+			if node.Result != nil {
+				result = copyValue(in.evalExpr(node.Result))
+			}
+			return CONTROL_RETURN, result
+		}
+	case *resolver.StmtExpr:
+		{
+			in.evalExpr(node.Expr)
+		}
+	}
+	return CONTROL_NEXT, nil
+}
+
+func (in *Interpreter) evalExpr(expr resolver.ExprNode) Value {
+	switch node := expr.(type) {
+	case *resolver.ExprInt:
+		{
+			val, err := strconv.ParseInt(node.Value, 10, 64)
+			if err != nil {
+				panic(runtimeError(fmt.Sprintf("integer literal '%s' is out of range", node.Value)))
+			}
+			return truncate(val, in.table.TypeOf(node))
+		}
+	case *resolver.ExprBool:
+		{
+			return node.Value
+		}
+	case *resolver.ExprString:
+		{
+			return node.Value
+		}
+	case *resolver.ExprIdentifier, *resolver.ExprField:
+		{
+			return in.evalAddr(node).Value
+		}
+	case *resolver.ExprUnary:
+		{
+			switch node.Op {
+			case resolver.REFER:
+				return Pointer{Cell: in.evalAddr(node.Right)}
+			case resolver.DEREF:
+				return in.evalAddr(node).Value
+			case resolver.NOT:
+				return !in.evalExpr(node.Right).(bool)
+			}
+		}
+	case *resolver.ExprBinary:
+		{
+			left := in.evalExpr(node.Left).(int64)
+			right := in.evalExpr(node.Right).(int64)
+			return in.binary(node, left, right)
+		}
+	case *resolver.ExprAssign:
+		{
+			cell := in.evalAddr(node.Left)
+			cell.Value = truncate(copyValue(in.evalExpr(node.Right)), in.table.TypeOf(node.Left))
+			return cell.Value
+		}
+	case *resolver.ExprCall:
+		{
+			args := make([]Value, 0, len(node.Args))
+			for _, arg := range node.Args {
+				args = append(args, in.evalExpr(arg.Expr))
+			}
+			return in.call(node.Obj.Link, args)
+		}
+	case *resolver.ExprCompound:
+		{
+			s := zeroValue(node.Type).(*Struct)
+			for _, init := range node.Fields {
+				cell := s.field(init.Name)
+				cell.Value = truncate(copyValue(in.evalExpr(init.Expr)), node.Type.Field(init.Name).Type)
+			}
+			return s
+		}
+	}
+	panic(fmt.Sprintf("Unhandled %T or unreachable", expr))
+}
+
+func (in *Interpreter) binary(node *resolver.ExprBinary, left int64, right int64) Value {
+	switch node.Op {
+	case resolver.ADD:
+		return truncate(left+right, node.Type)
+	case resolver.SUB:
+		return truncate(left-right, node.Type)
+	case resolver.MUL:
+		return truncate(left*right, node.Type)
+	case resolver.DIV:
+		if right == 0 {
+			panic(runtimeError("division by zero"))
+		}
+		return truncate(left/right, node.Type)
+	case resolver.EQ:
+		return left == right
+	case resolver.NE:
+		return left != right
+	case resolver.LT:
+		return left < right
+	case resolver.LE:
+		return left <= right
+	case resolver.GT:
+		return left > right
+	case resolver.GE:
+		return left >= right
+	}
+	panic(fmt.Sprintf("Unhandled binary operator '%d'", node.Op))
+}
+
+// evalAddr returns the cell an lvalue designates.
+func (in *Interpreter) evalAddr(expr resolver.ExprNode) *Cell {
+	switch node := expr.(type) {
+	case *resolver.ExprIdentifier:
+		{
+			cell, ok := in.frame.cells[node.Obj]
+			if !ok {
+				panic(runtimeError(fmt.Sprintf("variable '%s' used before its declaration", node.Name)))
+			}
+			return cell
+		}
+	case *resolver.ExprField:
+		{
+			var base Value
+			if in.table.TypeOf(node.Expr).Kind == types.TYPE_PTR {
+				base = in.deref(in.evalExpr(node.Expr)).Value
+			} else {
+				base = in.evalAddr(node.Expr).Value
+			}
+			return base.(*Struct).field(node.Name)
+		}
+	case *resolver.ExprUnary:
+		{
+			if node.Op == resolver.DEREF {
+				return in.deref(in.evalExpr(node.Right))
+			}
+		}
+	}
+	// Temporaries such as compound literals live in a fresh cell.
+	return &Cell{Value: in.evalExpr(expr)}
+}
+func (in *Interpreter) deref(v Value) *Cell {
+	ptr := v.(Pointer)
+	if ptr.Cell == nil {
+		panic(runtimeError("null pointer dereference"))
+	}
+	return ptr.Cell
+}
@@ -0,0 +1,108 @@
+package interp
+
+import (
+	"fmt"
+
+	"github.com/s0h1s2/types"
+)
+
+// Value is one of int64, bool, string, *Struct, Pointer or nil for void.
+type Value interface{}
+
+// Cell is a storage location that pointers can refer to.
+type Cell struct {
+	Value Value
+}
+
+type Struct struct {
+	Type   *types.Type
+	Fields []*Cell
+}
+
+type Pointer struct {
+	Cell *Cell
+}
+
+func zeroValue(typ *types.Type) Value {
+	switch typ.Kind {
+	case types.TYPE_INT:
+		return int64(0)
+	case types.TYPE_BOOL:
+		return false
+	case types.TYPE_STRING:
+		return ""
+	case types.TYPE_PTR:
+		return Pointer{}
+	case types.TYPE_STRUCT:
+		s := &Struct{Type: typ, Fields: make([]*Cell, len(typ.Fields))}
+		for i, field := range typ.Fields {
+			s.Fields[i] = &Cell{Value: zeroValue(field.Type)}
+		}
+		return s
+	}
+	return nil
+}
+
+// copyValue gives struct values their by-value semantics.
+func copyValue(v Value) Value {
+	s, ok := v.(*Struct)
+	if !ok {
+		return v
+	}
+	c := &Struct{Type: s.Type, Fields: make([]*Cell, len(s.Fields))}
+	for i, field := range s.Fields {
+		c.Fields[i] = &Cell{Value: copyValue(field.Value)}
+	}
+	return c
+}
+
+// truncate wraps an integer to the width of 'typ'.
+func truncate(v Value, typ *types.Type) Value {
+	n, ok := v.(int64)
+	if !ok || typ.Kind != types.TYPE_INT {
+		return v
+	}
+	switch typ.Size {
+	case 1:
+		return int64(int8(n))
+	case 2:
+		return int64(int16(n))
+	case 4:
+		return int64(int32(n))
+	}
+	return n
+}
+
+func (s *Struct) field(name string) *Cell {
+	for i, field := range s.Type.Fields {
+		if field.Name == name {
+			return s.Fields[i]
+		}
+	}
+	panic(runtimeError(fmt.Sprintf("'%s' doesn't have '%s' field", s.Type.TypeName, name)))
+}
+
+// Format renders a value in a Dennis like syntax.
+func Format(v Value) string {
+	switch val := v.(type) {
+	case nil:
+		return "void"
+	case string:
+		return fmt.Sprintf("%q", val)
+	case Pointer:
+		if val.Cell == nil {
+			return "null"
+		}
+		return fmt.Sprintf("%p", val.Cell)
+	case *Struct:
+		str := val.Type.TypeName + "{"
+		for i, field := range val.Type.Fields {
+			if i > 0 {
+				str += ", "
+			}
+			str += field.Name + ": " + Format(val.Fields[i].Value)
+		}
+		return str + "}"
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,228 @@
+package ir
+
+import (
+	"fmt"
+
+	"github.com/s0h1s2/error"
+	"github.com/s0h1s2/types"
+)
+
+type Op int
+
+const (
+	OP_ALLOCA Op = iota
+	OP_LOAD
+	OP_STORE
+	OP_COPYMEM // copies a struct from Args[1] to Args[0]
+	OP_FIELD   // address of field Field of the struct Args[0] points to
+	OP_ADD
+	OP_SUB
+	OP_MUL
+	OP_DIV
+	OP_EQ
+	OP_NE
+	OP_LT
+	OP_LE
+	OP_GT
+	OP_GE
+	OP_NOT
+	OP_SEXT
+	OP_TRUNC
+	OP_COPY
+	OP_CALL
+	OP_PHI
+	OP_JMP
+	OP_BR
+	OP_RET
+)
+
+var opNames = [...]string{
+	OP_ALLOCA:  "alloca",
+	OP_LOAD:    "load",
+	OP_STORE:   "store",
+	OP_COPYMEM: "copymem",
+	OP_FIELD:   "field",
+	OP_ADD:     "add",
+	OP_SUB:     "sub",
+	OP_MUL:     "mul",
+	OP_DIV:     "div",
+	OP_EQ:      "eq",
+	OP_NE:      "ne",
+	OP_LT:      "lt",
+	OP_LE:      "le",
+	OP_GT:      "gt",
+	OP_GE:      "ge",
+	OP_NOT:     "not",
+	OP_SEXT:    "sext",
+	OP_TRUNC:   "trunc",
+	OP_COPY:    "copy",
+	OP_CALL:    "call",
+	OP_PHI:     "phi",
+	OP_JMP:     "jmp",
+	OP_BR:      "br",
+	OP_RET:     "ret",
+}
+
+func (op Op) String() string {
+	return opNames[op]
+}
+func (op Op) IsTerminator() bool {
+	return op == OP_JMP || op == OP_BR || op == OP_RET
+}
+func (op Op) IsCompare() bool {
+	return op >= OP_EQ && op <= OP_GE
+}
+func (op Op) IsArithmetic() bool {
+	return op >= OP_ADD && op <= OP_DIV
+}
+
+// Value is anything an instruction can use as an operand.
+type Value interface {
+	Type() *types.Type
+	String() string
+}
+
+// Const is an integer or boolean constant.
+type Const struct {
+	Typ   *types.Type
+	Value int64
+}
+
+type StringConst struct {
+	Name  string
+	Value string
+	Typ   *types.Type
+}
+
+type Param struct {
+	Name  string
+	Index int
+	Typ   *types.Type
+}
+
+type Instr struct {
+	Id      int
+	Op      Op
+	Typ     *types.Type // result type, nil when nothing is produced
+	Args    []Value
+	Callee  string   // OP_CALL
+	Field   int      // OP_FIELD
+	Targets []*Block // OP_JMP and OP_BR, the true target first
+	Name    string   // source variable of an OP_ALLOCA
+	Param   bool     // OP_ALLOCA holding an incoming parameter
+	Pos     error.Position
+	Block   *Block
+}
+
+type Block struct {
+	Id     int
+	Name   string
+	Instrs []*Instr
+	Preds  []*Block
+	Succs  []*Block
+}
+
+type Function struct {
+	Name     string // link name, unique in the program
+	Source   string // name in the source
+	Params   []*Param
+	RetType  *types.Type
+	Blocks   []*Block
+	External bool
+	Public   bool // visible to other objects, private functions are local to theirs
+	Pos      error.Position
+	End      error.Position // closing brace of the body
+	nextId   int
+	nextBlk  int
+}
+
+type Module struct {
+	Structs   []*types.Type
+	Functions []*Function
+	Strings   []*StringConst
+}
+
+func (c *Const) Type() *types.Type {
+	return c.Typ
+}
+func (c *Const) String() string {
+	if c.Typ.Kind == types.TYPE_BOOL {
+		return fmt.Sprint(c.Value != 0)
+	}
+	return fmt.Sprint(c.Value)
+}
+func (s *StringConst) Type() *types.Type {
+	return s.Typ
+}
+func (s *StringConst) String() string {
+	return "@" + s.Name
+}
+func (p *Param) Type() *types.Type {
+	return p.Typ
+}
+func (p *Param) String() string {
+	return "%" + p.Name
+}
+func (i *Instr) Type() *types.Type {
+	return i.Typ
+}
+func (i *Instr) String() string {
+	return fmt.Sprintf("%%%d", i.Id)
+}
+
+// HasResult reports whether the instruction defines a value.
+func (i *Instr) HasResult() bool {
+	return i.Typ != nil && i.Typ.Kind != types.TYPE_VOID
+}
+
+// Terminator returns the last instruction when it ends the block.
+func (b *Block) Terminator() *Instr {
+	if len(b.Instrs) == 0 {
+		return nil
+	}
+	last := b.Instrs[len(b.Instrs)-1]
+	if !last.Op.IsTerminator() {
+		return nil
+	}
+	return last
+}
+
+func (f *Function) NewBlock(name string) *Block {
+	b := &Block{Id: f.nextBlk, Name: fmt.Sprintf("%s%d", name, f.nextBlk)}
+	if f.nextBlk == 0 {
+		b.Name = name
+	}
+	f.nextBlk++
+	f.Blocks = append(f.Blocks, b)
+	return b
+}
+
+// NewInstr creates an instruction with a fresh id that is not yet placed in a block.
+func (f *Function) NewInstr(op Op, typ *types.Type, args ...Value) *Instr {
+	instr := &Instr{Id: -1, Op: op, Typ: typ, Args: args}
+	if instr.HasResult() {
+		instr.Id = f.nextId
+		f.nextId++
+	}
+	return instr
+}
+
+// Append places 'instr' at the end of 'b' and records control flow edges.
+func (b *Block) Append(instr *Instr) *Instr {
+	instr.Block = b
+	b.Instrs = append(b.Instrs, instr)
+	for _, target := range instr.Targets {
+		b.Succs = append(b.Succs, target)
+		target.Preds = append(target.Preds, b)
+	}
+	return instr
+}
+
+// Insert places 'instr' before position 'index' of 'b'.
+func (b *Block) Insert(index int, instr *Instr) *Instr {
+	instr.Block = b
+	b.Instrs = append(b.Instrs, nil)
+	copy(b.Instrs[index+1:], b.Instrs[index:])
+	b.Instrs[index] = instr
+	return instr
+}
@@ -0,0 +1,441 @@
+package ir
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/s0h1s2/error"
+	"github.com/s0h1s2/resolver"
+	"github.com/s0h1s2/scope"
+	"github.com/s0h1s2/types"
+)
+
+type lowerer struct {
+	table   *resolver.Table
+	handler *error.DiagnosticBag
+	module  *Module
+	fn      *Function
+	block   *Block
+	vars    map[*scope.Object]Value
+	allocas int            // allocas are kept at the start of the entry block
+	pos     error.Position // statement being lowered
+	loops   []loopTargets  // around the statement being lowered, innermost last
+}
+
+// loopTargets are the blocks a break and a continue jump to.
+type loopTargets struct {
+	exit, next *Block
+}
+
+// Lower translates checked declarations into SSA form. Locals live in allocas
+// until the optimizer promotes them to registers.
+func Lower(decls []resolver.DeclNode, table *resolver.Table, handler *error.DiagnosticBag) *Module {
+	l := &lowerer{table: table, handler: handler, module: &Module{}}
+	for _, decl := range decls {
+		switch node := decl.(type) {
+		case *resolver.DeclStruct:
+			{
+				l.module.Structs = append(l.module.Structs, node.Type)
+			}
+		case *resolver.DeclExternalFunction:
+			{
+				l.module.Functions = append(l.module.Functions, &Function{Name: node.Name, RetType: node.ReturnType, Params: params(node.Params), External: true})
+			}
+		case *resolver.DeclFunction:
+			{
+				l.lowerFunction(node)
+			}
+		}
+	}
+	return l.module
+}
+
+func params(fields []resolver.Field) []*Param {
+	result := make([]*Param, 0, len(fields))
+	for i, field := range fields {
+		result = append(result, &Param{Name: field.Name, Index: i, Typ: field.Type})
+	}
+	return result
+}
+
+func (l *lowerer) emit(op Op, typ *types.Type, args ...Value) *Instr {
+	instr := l.fn.NewInstr(op, typ, args...)
+	instr.Pos = l.pos
+	return l.block.Append(instr)
+}
+func (l *lowerer) typeByName(name string) *types.Type {
+	return l.table.Symbols.GetObj(name).Type
+}
+func (l *lowerer) alloca(typ *types.Type, name string) *Instr {
+	instr := l.fn.NewInstr(OP_ALLOCA, l.table.PointerTo(typ))
+	instr.Name = name
+	instr.Pos = l.pos
+	l.fn.Blocks[0].Insert(l.allocas, instr)
+	l.allocas++
+	return instr
+}
+func (l *lowerer) terminated() bool {
+	return l.block.Terminator() != nil
+}
+
+// branch ends the current block with a jump or a conditional branch.
+func (l *lowerer) branch(op Op, targets []*Block, args ...Value) {
+	instr := l.fn.NewInstr(op, nil, args...)
+	instr.Targets = targets
+	instr.Pos = l.pos
+	l.block.Append(instr)
+}
+
+// jump ends the current block with a jump to 'to', unless a return ended it.
+func (l *lowerer) jump(to *Block) {
+	if !l.terminated() {
+		l.branch(OP_JMP, []*Block{to})
+	}
+}
+
+func (l *lowerer) lowerFunction(fun *resolver.DeclFunction) {
+	l.fn = &Function{Name: fun.Link, Source: fun.Name, Public: fun.Public || fun.Link == "main", RetType: fun.ReturnType, Params: params(fun.Params), Pos: fun.Pos, End: fun.End}
+	l.module.Functions = append(l.module.Functions, l.fn)
+	l.vars = make(map[*scope.Object]Value)
+	l.allocas = 0
+	l.pos = fun.Pos
+	l.block = l.fn.NewBlock("entry")
+	if fun.ReturnType.Kind == types.TYPE_STRUCT {
+		l.handler.ReportError(fun.GetPos(), "Returning struct '%s' by value is not supported yet", fun.ReturnType.TypeName)
+		return
+	}
+	for i, param := range l.fn.Params {
+		if param.Typ.Kind == types.TYPE_STRUCT {
+			l.handler.ReportError(fun.GetPos(), "Passing struct '%s' by value is not supported yet", param.Typ.TypeName)
+			return
+		}
+		l.pos = fun.Params[i].Pos
+		slot := l.alloca(param.Typ, param.Name)
+		slot.Param = true
+		l.emit(OP_STORE, nil, param, slot)
+		l.vars[fun.Scope.GetObj(param.Name)] = slot
+	}
+	l.lowerStmt(fun.Body)
+	l.pos = fun.End
+	if !l.terminated() {
+		if fun.ReturnType.Kind == types.TYPE_VOID {
+			l.emit(OP_RET, nil)
+		} else {
+			l.emit(OP_RET, nil, &Const{Typ: fun.ReturnType})
+		}
+	}
+}
+
+func (l *lowerer) lowerStmt(stmt resolver.StmtNode) {
+	switch stmt.(type) {
+	case nil, *resolver.StmtBlock:
+	default:
+		l.pos = stmt.GetPos()
+	}
+	switch node := stmt.(type) {
+	case *resolver.StmtBlock:
+		{
+			for _, stmt := range node.Body {
+				l.lowerStmt(stmt)
+			}
+		}
+	case *resolver.StmtLet:
+		{
+			slot := l.alloca(node.Type, node.Name)
+			l.vars[node.Scope.GetObj(node.Name)] = slot
+			if node.Init == nil {
+				return
+			}
+			if node.Type.Kind == types.TYPE_STRUCT {
+				l.emit(OP_COPYMEM, nil, slot, l.lowerAddr(node.Init))
+				return
+			}
+			l.emit(OP_STORE, nil, l.convert(l.lowerExpr(node.Init), node.Type), slot)
+		}
+	case *resolver.StmtIf:
+		{
+			cond := l.lowerExpr(node.Cond)
+			then := l.fn.NewBlock("then")
+			var els *Block
+			if node.Else != nil {
+				els = l.fn.NewBlock("else")
+			}
+			end := l.fn.NewBlock("endif")
+			if els == nil {
+				els = end
+			}
+			l.branch(OP_BR, []*Block{then, els}, cond)
+			l.block = then
+			l.lowerStmt(node.Then)
+			l.jump(end)
+			if node.Else != nil {
+				l.block = els
+				l.lowerStmt(node.Else)
+				l.jump(end)
+			}
+			l.block = end
+		}
+	case *resolver.StmtWhile:
+		{
+			l.lowerLoop(node.Cond, nil, node.Body)
+		}
+	case *resolver.StmtFor:
+		{
+			l.lowerStmt(node.Init)
+			var post resolver.StmtNode
+			if node.Post != nil {
+				post = &resolver.StmtExpr{Expr: node.Post, Scope: node.Scope, Pos: node.Pos}
+			}
+			l.lowerLoop(node.Cond, post, node.Body)
+		}
+	case *resolver.StmtBreak:
+		{
+			l.jump(l.loops[len(l.loops)-1].exit)
+			l.block = l.fn.NewBlock("dead")
+		}
+	case *resolver.StmtContinue:
+		{
+			l.jump(l.loops[len(l.loops)-1].next)
+			l.block = l.fn.NewBlock("dead")
+		}
+	case *resolver.StmtReturn:
+		{
+			if node.Result == nil {
+				l.emit(OP_RET, nil)
+			} else {
+				l.emit(OP_RET, nil, l.convert(l.lowerExpr(node.Result), l.fn.RetType))
+			}
+			// Anything after a return lands in a block without predecessors.
+			l.block = l.fn.NewBlock("dead")
+		}
+	case *resolver.StmtExpr:
+		{
+			_, isAssign := node.Expr.(*resolver.ExprAssign)
+			if !isAssign && l.table.TypeOf(node.Expr).Kind == types.TYPE_STRUCT {
+				l.lowerAddr(node.Expr)
+				return
+			}
+			l.lowerExpr(node.Expr)
+		}
+	}
+}
+
+// lowerLoop runs 'body' then 'post' while 'cond', which may be nil, holds.
+func (l *lowerer) lowerLoop(cond resolver.ExprNode, post resolver.StmtNode, body resolver.StmtNode) {
+	head := l.fn.NewBlock("loop")
+	start := l.fn.NewBlock("body")
+	next := head
+	if post != nil {
+		next = l.fn.NewBlock("next")
+	}
+	exit := l.fn.NewBlock("endloop")
+	l.jump(head)
+	l.block = head
+	if cond != nil {
+		l.branch(OP_BR, []*Block{start, exit}, l.lowerExpr(cond))
+	} else {
+		l.jump(start)
+	}
+	l.block = start
+	l.loops = append(l.loops, loopTargets{exit: exit, next: next})
+	l.lowerStmt(body)
+	l.loops = l.loops[:len(l.loops)-1]
+	l.jump(next)
+	if post != nil {
+		l.block = next
+		l.lowerStmt(post)
+		l.jump(head)
+	}
+	l.block = exit
+}
+
+var binaryOps = map[resolver.BinaryOperator]Op{
+	resolver.ADD: OP_ADD,
+	resolver.SUB: OP_SUB,
+	resolver.MUL: OP_MUL,
+	resolver.DIV: OP_DIV,
+	resolver.EQ:  OP_EQ,
+	resolver.NE:  OP_NE,
+	resolver.LT:  OP_LT,
+	resolver.LE:  OP_LE,
+	resolver.GT:  OP_GT,
+	resolver.GE:  OP_GE,
+}
+
+func (l *lowerer) lowerExpr(expr resolver.ExprNode) Value {
+	switch node := expr.(type) {
+	case *resolver.ExprInt:
+		{
+			val, err := strconv.ParseInt(node.Value, 10, 64)
+			if err != nil {
+				l.handler.ReportError(node.GetPos(), "Integer literal '%s' is out of range", node.Value)
+			}
+			return &Const{Typ: l.table.TypeOf(node), Value: val}
+		}
+	case *resolver.ExprBool:
+		{
+			val := int64(0)
+			if node.Value {
+				val = 1
+			}
+			return &Const{Typ: l.typeByName("bool"), Value: val}
+		}
+	case *resolver.ExprString:
+		{
+			str := &StringConst{Name: fmt.Sprintf(".str%d", len(l.module.Strings)), Value: node.Value, Typ: l.typeByName("string")}
+			l.module.Strings = append(l.module.Strings, str)
+			return str
+		}
+	case *resolver.ExprIdentifier, *resolver.ExprField:
+		{
+			return l.emit(OP_LOAD, l.table.TypeOf(expr), l.lowerAddr(expr))
+		}
+	case *resolver.ExprUnary:
+		{
+			switch node.Op {
+			case resolver.REFER:
+				return l.lowerAddr(node.Right)
+			case resolver.DEREF:
+				return l.emit(OP_LOAD, l.table.TypeOf(node), l.lowerExpr(node.Right))
+			case resolver.NOT:
+				return l.emit(OP_NOT, l.typeByName("bool"), l.lowerExpr(node.Right))
+			}
+		}
+	case *resolver.ExprBinary:
+		{
+			left := l.lowerExpr(node.Left)
+			right := l.lowerExpr(node.Right)
+			operandType := node.Type
+			if resolver.IsCompare(node.Op) {
+				operandType = left.Type()
+				if right.Type().Size > operandType.Size {
+					operandType = right.Type()
+				}
+			}
+			return l.emit(binaryOps[node.Op], node.Type, l.convert(left, operandType), l.convert(right, operandType))
+		}
+	case *resolver.ExprAssign:
+		{
+			typ := l.table.TypeOf(node.Left)
+			addr := l.lowerAddr(node.Left)
+			if typ.Kind == types.TYPE_STRUCT {
+				l.emit(OP_COPYMEM, nil, addr, l.lowerAddr(node.Right))
+				return addr
+			}
+			value := l.convert(l.lowerExpr(node.Right), typ)
+			l.emit(OP_STORE, nil, value, addr)
+			return value
+		}
+	case *resolver.ExprCall:
+		{
+			return l.lowerCall(node)
+		}
+	case *resolver.ExprCompound:
+		{
+			return l.lowerAddr(node)
+		}
+	}
+	panic(fmt.Sprintf("Unhandled %T or unreachable", expr))
+}
+
+func (l *lowerer) lowerCall(node *resolver.ExprCall) Value {
+	fnObj := node.Obj
+	params := fnObj.Scope.QueryObjByKind(scope.PARAM)
+	args := make([]Value, 0, len(node.Args))
+	for i, arg := range node.Args {
+		if typ := l.table.TypeOf(arg.Expr); typ.Kind == types.TYPE_STRUCT {
+			l.handler.ReportError(node.Pos, "Passing struct '%s' by value is not supported yet", typ.TypeName)
+			return &Const{Typ: fnObj.Type}
+		}
+		args = append(args, l.convert(l.lowerExpr(arg.Expr), params[i].Type))
+	}
+	if fnObj.Type.Kind == types.TYPE_STRUCT {
+		l.handler.ReportError(node.Pos, "Returning struct '%s' by value is not supported yet", fnObj.Type.TypeName)
+	}
+	call := l.emit(OP_CALL, fnObj.Type, args...)
+	call.Callee = fnObj.Link
+	return call
+}
+
+// lowerAddr returns a pointer to an lvalue or to a struct value.
+func (l *lowerer) lowerAddr(expr resolver.ExprNode) Value {
+	switch node := expr.(type) {
+	case *resolver.ExprIdentifier:
+		{
+			return l.vars[node.Obj]
+		}
+	case *resolver.ExprField:
+		{
+			var base Value
+			structType := l.table.TypeOf(node.Expr)
+			if structType.Kind == types.TYPE_PTR {
+				base = l.lowerExpr(node.Expr)
+				structType = structType.Base
+			} else {
+				base = l.lowerAddr(node.Expr)
+			}
+			return l.field(base, structType, node.Name)
+		}
+	case *resolver.ExprUnary:
+		{
+			if node.Op == resolver.DEREF {
+				return l.lowerExpr(node.Right)
+			}
+		}
+	case *resolver.ExprCompound:
+		{
+			slot := l.alloca(node.Type, "")
+			for _, init := range node.Fields {
+				addr := l.field(slot, node.Type, init.Name)
+				fieldType := addr.Type().Base
+				if fieldType.Kind == types.TYPE_STRUCT {
+					l.emit(OP_COPYMEM, nil, addr, l.lowerAddr(init.Expr))
+					continue
+				}
+				l.emit(OP_STORE, nil, l.convert(l.lowerExpr(init.Expr), fieldType), addr)
+			}
+			return slot
+		}
+	}
+	l.handler.ReportError(expr.GetPos(), "Expression is not addressable")
+	return &Const{Typ: l.table.PointerTo(l.table.TypeOf(expr))}
+}
+
+func (l *lowerer) field(base Value, structType *types.Type, name string) *Instr {
+	for i, field := range structType.Fields {
+		if field.Name == name {
+			instr := l.emit(OP_FIELD, l.table.PointerTo(field.Type), base)
+			instr.Field = i
+			return instr
+		}
+	}
+	panic(fmt.Sprintf("'%s' doesn't have '%s' field", structType.TypeName, name))
+}
+
+// convert makes integer widths explicit.
+func (l *lowerer) convert(v Value, to *types.Type) Value {
+	from := v.Type()
+	if from.Kind != types.TYPE_INT || to.Kind != types.TYPE_INT || from.Size == to.Size {
+		return v
+	}
+	if c, ok := v.(*Const); ok {
+		return &Const{Typ: to, Value: Truncate(c.Value, to)}
+	}
+	if to.Size > from.Size {
+		return l.emit(OP_SEXT, to, v)
+	}
+	return l.emit(OP_TRUNC, to, v)
+}
+
+// Truncate wraps an integer constant to the width of 'typ'.
+func Truncate(v int64, typ *types.Type) int64 {
+	switch typ.Size {
+	case 1:
+		return int64(int8(v))
+	case 2:
+		return int64(int16(v))
+	case 4:
+		return int64(int32(v))
+	}
+	return v
+}
@@ -0,0 +1,110 @@
+package ir
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+func (m *Module) String() string {
+	var sb strings.Builder
+	for _, s := range m.Structs {
+		fields := make([]string, 0, len(s.Fields))
+		for _, field := range s.Fields {
+			fields = append(fields, field.Name+": "+field.Type.Link)
+		}
+		fmt.Fprintf(&sb, "struct %s { %s }\n", s.Link, strings.Join(fields, ", "))
+	}
+	for _, str := range m.Strings {
+		fmt.Fprintf(&sb, "@%s = %s\n", str.Name, strconv.Quote(str.Value))
+	}
+	for _, fn := range m.Functions {
+		sb.WriteByte('\n')
+		sb.WriteString(fn.String())
+	}
+	return sb.String()
+}
+
+func (f *Function) String() string {
+	var sb strings.Builder
+	params := make([]string, 0, len(f.Params))
+	for _, param := range f.Params {
+		params = append(params, fmt.Sprintf("%s: %s", param, param.Typ.Link))
+	}
+	header := fmt.Sprintf("fn %s(%s): %s", f.Name, strings.Join(params, ", "), f.RetType.Link)
+	if f.External {
+		return "extern " + header + "\n"
+	}
+	sb.WriteString(header + " {\n")
+	for _, b := range f.Blocks {
+		fmt.Fprintf(&sb, "%s:", b.Name)
+		if len(b.Preds) > 0 {
+			preds := make([]string, 0, len(b.Preds))
+			for _, pred := range b.Preds {
+				preds = append(preds, pred.Name)
+			}
+			fmt.Fprintf(&sb, " ; preds %s", strings.Join(preds, ", "))
+		}
+		sb.WriteByte('\n')
+		for _, instr := range b.Instrs {
+			sb.WriteString("  " + instr.Format() + "\n")
+		}
+	}
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+func joinValues(values []Value) string {
+	strs := make([]string, 0, len(values))
+	for _, v := range values {
+		strs = append(strs, v.String())
+	}
+	return strings.Join(strs, ", ")
+}
+
+// Format renders one instruction in the textual dump syntax.
+func (i *Instr) Format() string {
+	var body string
+	switch i.Op {
+	case OP_ALLOCA:
+		body = "alloca " + i.Typ.Base.Link
+		if i.Name != "" {
+			body += " ; " + i.Name
+		}
+	case OP_LOAD:
+		body = fmt.Sprintf("load %s, %s", i.Typ.Link, i.Args[0])
+	case OP_STORE:
+		body = fmt.Sprintf("store %s %s, %s", i.Args[0].Type().Link, i.Args[0], i.Args[1])
+	case OP_COPYMEM:
+		body = fmt.Sprintf("copymem %s %s, %s", i.Args[0].Type().Base.Link, i.Args[0], i.Args[1])
+	case OP_FIELD:
+		structType := i.Args[0].Type().Base
+		body = fmt.Sprintf("field %s, %s.%s", i.Args[0], structType.Link, structType.Fields[i.Field].Name)
+	case OP_CALL:
+		body = fmt.Sprintf("call %s %s(%s)", i.Typ.Link, i.Callee, joinValues(i.Args))
+	case OP_PHI:
+		edges := make([]string, 0, len(i.Args))
+		for n, arg := range i.Args {
+			edges = append(edges, fmt.Sprintf("[%s, %s]", arg, i.Block.Preds[n].Name))
+		}
+		body = fmt.Sprintf("phi %s %s", i.Typ.Link, strings.Join(edges, ", "))
+	case OP_JMP:
+		body = "jmp " + i.Targets[0].Name
+	case OP_BR:
+		body = fmt.Sprintf("br %s, %s, %s", i.Args[0], i.Targets[0].Name, i.Targets[1].Name)
+	case OP_RET:
+		body = "ret"
+		if len(i.Args) > 0 {
+			body = fmt.Sprintf("ret %s %s", i.Args[0].Type().Link, i.Args[0])
+		}
+	default:
+		body = fmt.Sprintf("%s %s %s", i.Op, i.Args[0].Type().Link, joinValues(i.Args))
+		if i.Op == OP_SEXT || i.Op == OP_TRUNC {
+			body += " to " + i.Typ.Link
+		}
+	}
+	if i.HasResult() {
+		return fmt.Sprintf("%s = %s", i, body)
+	}
+	return body
+}
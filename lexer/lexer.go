@@ -9,12 +9,15 @@ import (
 )
 
 type Lexer struct {
-	src     []byte
-	start   int
-	current int
-	ch      byte
-	line    int
-	errors  *error.DiagnosticBag
+	src      []byte
+	start    int
+	current  int
+	ch       byte
+	line     int
+	lineAt   int // offset where the current line begins
+	file     string
+	comments []token.Token
+	errors   *error.DiagnosticBag
 }
 
 const (
@@ -50,11 +53,18 @@ func New(bag *error.DiagnosticBag) *Lexer {
 		errors:  bag,
 	}
 }
+
+// SetFile names the source file in the positions of the following tokens.
+func (lex *Lexer) SetFile(path string) {
+	lex.file = path
+}
 func (lex *Lexer) reset() {
 	lex.src = nil
 	lex.start = 0
 	lex.current = 0
+	lex.lineAt = 0
 	lex.ch = 0
+	lex.comments = nil
 }
 func (lex *Lexer) next() {
 	if lex.current < len(lex.src) {
@@ -70,9 +80,12 @@ func (lex *Lexer) makeToken(kind token.TokenKind, literal string) token.Token {
 	return token.Token{
 		Kind:    kind,
 		Literal: literal,
-		Pos:     error.Position{Start: lex.start, End: lex.current, Line: lex.line},
+		Pos:     lex.pos(),
 	}
 }
+func (lex *Lexer) pos() error.Position {
+	return error.Position{File: lex.file, Start: lex.start, End: lex.current, Line: lex.line, Column: lex.start - lex.lineAt + 1}
+}
 func (lex *Lexer) atEnd() bool {
 	return lex.current >= len(lex.src)
 }
@@ -85,6 +98,7 @@ func (lex *Lexer) updateLine() {
 	for !lex.atEnd() && lex.ch == '\n' {
 		lex.line += 1
 		lex.next()
+		lex.lineAt = lex.current
 	}
 }
 func (lex *Lexer) scanInt() string {
@@ -110,9 +124,9 @@ func (lex *Lexer) scanSingleLineComment() {
 }
 func (lex *Lexer) getToken() token.Token {
 start:
-	lex.start = lex.current
 	lex.updateLine()
 	lex.skipWhitespace()
+	lex.start = lex.current
 	if lex.atEnd() {
 		return token.Token{Kind: token.TK_EOF}
 	}
@@ -217,6 +231,8 @@ start:
 				lex.next()
 				if lex.ch == '/' {
 					lex.scanSingleLineComment()
+					lex.comments = append(lex.comments, lex.makeToken(token.TK_COMMENT, strings.TrimRight(string(lex.src[lex.start:lex.current]), " \t\r")))
+					goto start
 				}
 			}
 		case '"':
@@ -224,14 +240,15 @@ start:
 				// TODO: implemented escape sequences.
 				lex.next()
 				var sb strings.Builder
-				for lex.ch != '\n' && lex.ch != '"' {
+				for !lex.atEnd() && lex.ch != '\n' && lex.ch != '"' {
 					sb.WriteByte(lex.ch)
 					lex.next()
 				}
-				if lex.ch != '"' {
-					lex.errors.ReportError(error.Position{Line: lex.line, Start: lex.start, End: lex.current}, "Unterminated string")
+				if lex.ch == '"' {
+					lex.next()
+				} else {
+					lex.errors.ReportError(lex.pos(), "Unterminated string")
 				}
-				lex.next()
 				return lex.makeToken(token.TK_STRING, sb.String())
 			}
 		default:
@@ -245,13 +262,19 @@ start:
 				} else if lex.ch == '\n' {
 					goto start
 				}
-				lex.errors.ReportError(error.Position{Line: lex.line, Start: lex.start, End: lex.current}, "Illegal token '%c' with ascii code of '%d'", lex.ch, lex.ch)
+				lex.errors.ReportError(lex.pos(), "Illegal token '%c' with ascii code of '%d'", lex.ch, lex.ch)
 				lex.next()
 				return lex.makeToken(token.TK_ILLEGAL, "")
 			}
 		}
 	}
 }
+
+// Comments returns the comments in the source GetTokens scanned last, they
+// aren't part of the tokens.
+func (lex *Lexer) Comments() []token.Token {
+	return lex.comments
+}
 func (lex *Lexer) GetTokens(src []byte) []token.Token {
 	lex.reset()
 	lex.src = src
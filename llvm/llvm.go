@@ -0,0 +1,192 @@
+package llvm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/s0h1s2/ir"
+	"github.com/s0h1s2/types"
+)
+
+var predicates = map[ir.Op]string{
+	ir.OP_EQ: "eq",
+	ir.OP_NE: "ne",
+	ir.OP_LT: "slt",
+	ir.OP_LE: "sle",
+	ir.OP_GT: "sgt",
+	ir.OP_GE: "sge",
+}
+
+var arithmetic = map[ir.Op]string{
+	ir.OP_ADD: "add",
+	ir.OP_SUB: "sub",
+	ir.OP_MUL: "mul",
+	ir.OP_DIV: "sdiv",
+}
+
+// Generate prints a module as textual LLVM IR using opaque pointers.
+func Generate(module *ir.Module) string {
+	var sb strings.Builder
+	for _, s := range module.Structs {
+		fields := make([]string, 0, len(s.Fields))
+		for _, field := range s.Fields {
+			fields = append(fields, typeName(field.Type))
+		}
+		fmt.Fprintf(&sb, "%%%s = type { %s }\n", s.Link, strings.Join(fields, ", "))
+	}
+	for _, str := range module.Strings {
+		fmt.Fprintf(&sb, "@%s = private unnamed_addr constant [%d x i8] c\"%s\\00\"\n", str.Name, len(str.Value)+1, escape(str.Value))
+	}
+	usesMemcpy := false
+	for _, fn := range module.Functions {
+		sb.WriteByte('\n')
+		params := make([]string, 0, len(fn.Params))
+		for _, param := range fn.Params {
+			if fn.External {
+				params = append(params, typeName(param.Typ))
+			} else {
+				params = append(params, typeName(param.Typ)+" "+param.String())
+			}
+		}
+		header := fmt.Sprintf("%s @%s(%s)", typeName(fn.RetType), fn.Name, strings.Join(params, ", "))
+		if fn.External {
+			sb.WriteString("declare " + header + "\n")
+			continue
+		}
+		if fn.Public {
+			sb.WriteString("define " + header + " {\n")
+		} else {
+			sb.WriteString("define internal " + header + " {\n")
+		}
+		for i, b := range fn.Blocks {
+			if i > 0 {
+				sb.WriteByte('\n')
+			}
+			sb.WriteString(blockName(b) + ":\n")
+			for _, instr := range b.Instrs {
+				if instr.Op == ir.OP_COPYMEM {
+					usesMemcpy = true
+				}
+				sb.WriteString("  " + formatInstr(instr) + "\n")
+			}
+			if b.Terminator() == nil {
+				sb.WriteString("  unreachable\n")
+			}
+		}
+		sb.WriteString("}\n")
+	}
+	if usesMemcpy {
+		sb.WriteString("\ndeclare void @llvm.memcpy.p0.p0.i64(ptr, ptr, i64, i1)\n")
+	}
+	return sb.String()
+}
+
+func typeName(typ *types.Type) string {
+	switch typ.Kind {
+	case types.TYPE_VOID:
+		return "void"
+	case types.TYPE_BOOL:
+		return "i1"
+	case types.TYPE_INT:
+		return fmt.Sprintf("i%d", typ.Size*8)
+	case types.TYPE_STRUCT:
+		return "%" + typ.Link
+	}
+	return "ptr"
+}
+
+// blockName prefixes labels with a dot so they can't clash with parameter names.
+func blockName(b *ir.Block) string {
+	return "." + b.Name
+}
+
+func value(v ir.Value) string {
+	switch v := v.(type) {
+	case *ir.Instr:
+		return fmt.Sprintf("%%.%d", v.Id)
+	case *ir.Const:
+		if v.Typ.Kind == types.TYPE_PTR || v.Typ.Kind == types.TYPE_STRING {
+			return "null"
+		}
+	}
+	return v.String()
+}
+
+// typed renders an operand preceded by its type, as most instructions expect.
+func typed(v ir.Value) string {
+	return typeName(v.Type()) + " " + value(v)
+}
+
+func formatInstr(i *ir.Instr) string {
+	var body string
+	switch i.Op {
+	case ir.OP_ALLOCA:
+		body = "alloca " + typeName(i.Typ.Base)
+	case ir.OP_LOAD:
+		body = fmt.Sprintf("load %s, %s", typeName(i.Typ), typed(i.Args[0]))
+	case ir.OP_STORE:
+		body = fmt.Sprintf("store %s, %s", typed(i.Args[0]), typed(i.Args[1]))
+	case ir.OP_COPYMEM:
+		body = fmt.Sprintf("call void @llvm.memcpy.p0.p0.i64(%s, %s, i64 %d, i1 false)", typed(i.Args[0]), typed(i.Args[1]), i.Args[0].Type().Base.Size)
+	case ir.OP_FIELD:
+		body = fmt.Sprintf("getelementptr inbounds %s, %s, i32 0, i32 %d", typeName(i.Args[0].Type().Base), typed(i.Args[0]), i.Field)
+	case ir.OP_ADD, ir.OP_SUB, ir.OP_MUL, ir.OP_DIV:
+		body = fmt.Sprintf("%s %s, %s", arithmetic[i.Op], typed(i.Args[0]), value(i.Args[1]))
+	case ir.OP_EQ, ir.OP_NE, ir.OP_LT, ir.OP_LE, ir.OP_GT, ir.OP_GE:
+		body = fmt.Sprintf("icmp %s %s, %s", predicates[i.Op], typed(i.Args[0]), value(i.Args[1]))
+	case ir.OP_NOT:
+		body = fmt.Sprintf("xor %s, true", typed(i.Args[0]))
+	case ir.OP_SEXT, ir.OP_TRUNC:
+		body = fmt.Sprintf("%s %s to %s", i.Op, typed(i.Args[0]), typeName(i.Typ))
+	case ir.OP_COPY:
+		// LLVM has no copy instruction; adding zero has the same meaning.
+		if i.Typ.Kind == types.TYPE_PTR || i.Typ.Kind == types.TYPE_STRING {
+			body = fmt.Sprintf("getelementptr i8, %s, i64 0", typed(i.Args[0]))
+		} else {
+			body = fmt.Sprintf("add %s, 0", typed(i.Args[0]))
+		}
+	case ir.OP_CALL:
+		{
+			args := make([]string, 0, len(i.Args))
+			for _, arg := range i.Args {
+				args = append(args, typed(arg))
+			}
+			body = fmt.Sprintf("call %s @%s(%s)", typeName(i.Typ), i.Callee, strings.Join(args, ", "))
+		}
+	case ir.OP_PHI:
+		{
+			edges := make([]string, 0, len(i.Args))
+			for n, arg := range i.Args {
+				edges = append(edges, fmt.Sprintf("[ %s, %%%s ]", value(arg), blockName(i.Block.Preds[n])))
+			}
+			body = fmt.Sprintf("phi %s %s", typeName(i.Typ), strings.Join(edges, ", "))
+		}
+	case ir.OP_JMP:
+		body = "br label %" + blockName(i.Targets[0])
+	case ir.OP_BR:
+		body = fmt.Sprintf("br %s, label %%%s, label %%%s", typed(i.Args[0]), blockName(i.Targets[0]), blockName(i.Targets[1]))
+	case ir.OP_RET:
+		body = "ret void"
+		if len(i.Args) > 0 {
+			body = "ret " + typed(i.Args[0])
+		}
+	}
+	if i.HasResult() {
+		return value(i) + " = " + body
+	}
+	return body
+}
+
+// escape writes a string in the c"..." syntax, hex escaping anything unprintable.
+func escape(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < ' ' || c > '~' || c == '"' || c == '\\' {
+			fmt.Fprintf(&sb, "\\%02X", c)
+			continue
+		}
+		sb.WriteByte(c)
+	}
+	return sb.String()
+}
@@ -0,0 +1,131 @@
+package lsp
+
+import (
+	"net/url"
+	"path/filepath"
+	"unicode/utf8"
+
+	"github.com/s0h1s2/compiler"
+	"github.com/s0h1s2/error"
+	"github.com/s0h1s2/manifest"
+	"github.com/s0h1s2/resolver"
+)
+
+type document struct {
+	uri       string
+	path      string
+	text      []byte
+	session   *compiler.Session // checks the current text
+	resolved  *compiler.Session // latest session that got through name resolution
+	published map[string]bool   // URIs of imported files with diagnostics from this document
+}
+
+func uriToPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	return filepath.FromSlash(u.Path)
+}
+
+func pathToURI(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
+
+// analyze checks the document the way 'dennis check' would, finding imports
+// through the manifest of the package it belongs to.
+func (d *document) analyze() {
+	session := compiler.NewSession()
+	if path, ok := manifest.Find(filepath.Dir(d.path)); ok {
+		bag := error.New()
+		pkg := manifest.Load(path, bag)
+		if !bag.GotErrors() {
+			session.Roots = pkg.Roots()
+			session.Packages = pkg.Packages()
+		}
+	}
+	defer func() {
+		// Half written code must not take the server down with it.
+		if recover() != nil {
+			session.Diagnostics.ReportError(error.Position{}, "Internal compiler error while checking '%s'", d.path)
+		}
+		d.session = session
+		if session.Table != nil {
+			d.resolved = session
+		}
+	}()
+	session.Parse(d.path, d.text)
+	session.Check()
+}
+
+// offset converts a position in the document to a byte offset.
+func (d *document) offset(pos Position) int {
+	offset, line := 0, 0
+	for line < pos.Line && offset < len(d.text) {
+		if d.text[offset] == '\n' {
+			line++
+		}
+		offset++
+	}
+	for units := 0; units < pos.Character && offset < len(d.text) && d.text[offset] != '\n'; {
+		r, size := utf8.DecodeRune(d.text[offset:])
+		offset += size
+		units++
+		if r >= 0x10000 {
+			units++
+		}
+	}
+	return offset
+}
+
+// position converts a byte offset in the document to a position.
+func (d *document) position(offset int) Position {
+	pos := Position{}
+	for i := 0; i < offset && i < len(d.text); {
+		if d.text[i] == '\n' {
+			pos.Line++
+			pos.Character = 0
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRune(d.text[i:])
+		i += size
+		pos.Character++
+		if r >= 0x10000 {
+			pos.Character++
+		}
+	}
+	return pos
+}
+
+// rangeOf converts a source position to a range, measured in the text of the
+// file when it is open and in bytes from the line and column otherwise.
+func (s *Server) rangeOf(pos error.Position) Range {
+	if pos.Line == 0 {
+		return Range{}
+	}
+	for _, doc := range s.docs {
+		if doc.path == pos.File {
+			return Range{doc.position(pos.Start), doc.position(pos.End)}
+		}
+	}
+	start := Position{Line: pos.Line - 1, Character: pos.Column - 1}
+	return Range{start, Position{Line: start.Line, Character: start.Character + pos.End - pos.Start}}
+}
+
+// refAt returns the name at 'offset' in the latest resolution of the document.
+func (d *document) refAt(offset int) *resolver.Ref {
+	if d.resolved == nil {
+		return nil
+	}
+	refs := d.resolved.Table.Refs
+	for i := range refs {
+		if refs[i].Pos.File == d.path && refs[i].Pos.Start <= offset && offset <= refs[i].Pos.End {
+			return &refs[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,235 @@
+package lsp
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/s0h1s2/ast"
+	"github.com/s0h1s2/scope"
+	"github.com/s0h1s2/types"
+)
+
+// describe renders the declaration of a symbol the way it is written in source.
+func describe(name string, obj *scope.Object) string {
+	typeName := func(t *types.Type) string {
+		if t == nil {
+			return "?"
+		}
+		return t.TypeName
+	}
+	switch obj.Kind {
+	case scope.FN:
+		{
+			params := []string{}
+			names := obj.Scope.QueryByKind(scope.PARAM)
+			for i, param := range obj.Scope.QueryObjByKind(scope.PARAM) {
+				params = append(params, fmt.Sprintf("%s: %s", names[i], typeName(param.Type)))
+			}
+			return fmt.Sprintf("fn %s(%s): %s", name, strings.Join(params, ", "), typeName(obj.Type))
+		}
+	case scope.VAR:
+		return fmt.Sprintf("let %s: %s", name, typeName(obj.Type))
+	case scope.PARAM:
+		return fmt.Sprintf("param %s: %s", name, typeName(obj.Type))
+	case scope.FIELD:
+		return fmt.Sprintf("field %s: %s", name, typeName(obj.Type))
+	case scope.MODULE:
+		return fmt.Sprintf("module %s", name)
+	case scope.TYPE:
+		{
+			if obj.Type.Kind != types.TYPE_STRUCT {
+				return fmt.Sprintf("type %s", name)
+			}
+			var sb strings.Builder
+			fmt.Fprintf(&sb, "struct %s {\n", name)
+			names := obj.Scope.QueryByKind(scope.FIELD)
+			for i, field := range obj.Scope.QueryObjByKind(scope.FIELD) {
+				fmt.Fprintf(&sb, "  %s: %s;\n", names[i], typeName(field.Type))
+			}
+			sb.WriteString("}")
+			return sb.String()
+		}
+	}
+	return name
+}
+
+func (s *Server) hover(doc *document, pos Position) *Hover {
+	ref := doc.refAt(doc.offset(pos))
+	if ref == nil {
+		return nil
+	}
+	return &Hover{
+		Contents: MarkupContent{Kind: "markdown", Value: "```dennis\n" + describe(ref.Name, ref.Obj) + "\n```"},
+		Range:    s.rangeOf(ref.Pos),
+	}
+}
+
+func (s *Server) definition(doc *document, pos Position) *Location {
+	ref := doc.refAt(doc.offset(pos))
+	if ref == nil || ref.Obj.Pos.Line == 0 {
+		return nil
+	}
+	file := ref.Obj.Pos.File
+	if file == "" {
+		file = doc.path
+	}
+	return &Location{URI: pathToURI(file), Range: s.rangeOf(ref.Obj.Pos)}
+}
+
+func (s *Server) symbols(doc *document) []DocumentSymbol {
+	symbols := []DocumentSymbol{}
+	if doc.session == nil {
+		return symbols
+	}
+	for _, decl := range doc.session.Tree {
+		switch node := decl.(type) {
+		case *ast.DeclFunction:
+			{
+				if node == nil {
+					continue
+				}
+				symbols = append(symbols, DocumentSymbol{
+					Name:           node.Name,
+					Detail:         signature(node.Parameters, node.RetType),
+					Kind:           SYMBOL_FUNCTION,
+					Range:          Range{s.rangeOf(node.Pos).Start, s.rangeOf(node.End).End},
+					SelectionRange: s.rangeOf(node.Pos),
+				})
+			}
+		case *ast.DeclExternalFunction:
+			{
+				if node == nil {
+					continue
+				}
+				symbols = append(symbols, DocumentSymbol{
+					Name:           node.Name,
+					Detail:         "extern " + signature(node.Parameters, node.ReturnType),
+					Kind:           SYMBOL_FUNCTION,
+					Range:          s.rangeOf(node.Pos),
+					SelectionRange: s.rangeOf(node.Pos),
+				})
+			}
+		case *ast.DeclStruct:
+			{
+				if node == nil {
+					continue
+				}
+				fields := []DocumentSymbol{}
+				for _, field := range node.Fields {
+					if field == nil {
+						continue
+					}
+					fields = append(fields, DocumentSymbol{
+						Name:           field.Name,
+						Detail:         ast.TypeString(field.Type),
+						Kind:           SYMBOL_FIELD,
+						Range:          s.rangeOf(field.Pos),
+						SelectionRange: s.rangeOf(field.Pos),
+					})
+				}
+				symbols = append(symbols, DocumentSymbol{
+					Name:           node.Name,
+					Kind:           SYMBOL_STRUCT,
+					Range:          Range{s.rangeOf(node.Pos).Start, s.rangeOf(node.End).End},
+					SelectionRange: s.rangeOf(node.Pos),
+					Children:       fields,
+				})
+			}
+		}
+	}
+	return symbols
+}
+
+func signature(params []ast.Field, ret ast.TypeSpec) string {
+	list := make([]string, len(params))
+	for i, param := range params {
+		list[i] = param.Name + ": " + ast.TypeString(param.Type)
+	}
+	return fmt.Sprintf("fn(%s): %s", strings.Join(list, ", "), ast.TypeString(ret))
+}
+
+func isNameChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// completion offers the fields of the struct, or the public declarations of the
+// module, named by the 'a.b.' chain before the cursor. The text being typed
+// rarely parses, so names are bound from the latest resolution of the document.
+func (s *Server) completion(doc *document, pos Position) []CompletionItem {
+	items := []CompletionItem{}
+	offset := doc.offset(pos)
+	i := offset
+	for i > 0 && isNameChar(doc.text[i-1]) {
+		i--
+	}
+	var chain []string
+	for i > 0 && doc.text[i-1] == '.' {
+		end := i - 1
+		i = end
+		for i > 0 && isNameChar(doc.text[i-1]) {
+			i--
+		}
+		if i == end {
+			return items
+		}
+		chain = append([]string{string(doc.text[i:end])}, chain...)
+	}
+	if len(chain) == 0 || doc.resolved == nil {
+		return items
+	}
+	table := doc.resolved.Table
+	var obj *scope.Object
+	for _, ref := range table.Refs {
+		if ref.Pos.File == doc.path && ref.Name == chain[0] && ref.Pos.Start < i {
+			obj = ref.Obj
+		}
+	}
+	if obj == nil {
+		return items
+	}
+	if obj.Kind == scope.MODULE {
+		if len(chain) > 1 {
+			return items
+		}
+		for _, kind := range []scope.ObjectKind{scope.FN, scope.TYPE} {
+			names := obj.Scope.QueryByKind(kind)
+			for j, member := range obj.Scope.QueryObjByKind(kind) {
+				if !member.Public {
+					continue
+				}
+				item := CompletionItem{Label: names[j], Kind: COMPLETION_FUNCTION, Detail: describe(names[j], member)}
+				if kind == scope.TYPE {
+					item.Kind = COMPLETION_STRUCT
+				}
+				items = append(items, item)
+			}
+		}
+		return items
+	}
+	fields := func(t *types.Type) *scope.Scope {
+		if t != nil && t.Kind == types.TYPE_PTR {
+			t = t.Base
+		}
+		if t == nil || t.Kind != types.TYPE_STRUCT {
+			return nil
+		}
+		return table.Struct(t).Scope
+	}
+	typ := obj.Type
+	for _, name := range chain[1:] {
+		structScope := fields(typ)
+		if structScope == nil || !structScope.LookupOnce(name) {
+			return items
+		}
+		typ = structScope.GetObj(name).Type
+	}
+	structScope := fields(typ)
+	if structScope == nil || obj.Kind == scope.FN || obj.Kind == scope.TYPE {
+		return items
+	}
+	names := structScope.QueryByKind(scope.FIELD)
+	for j, field := range structScope.QueryObjByKind(scope.FIELD) {
+		items = append(items, CompletionItem{Label: names[j], Kind: COMPLETION_FIELD, Detail: field.Type.TypeName})
+	}
+	return items
+}
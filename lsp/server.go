@@ -0,0 +1,196 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Server answers the requests of one editor. Documents are synchronized whole
+// and checked again on every change.
+type Server struct {
+	in       *bufio.Reader
+	out      io.Writer
+	docs     map[string]*document // by URI
+	shutdown bool
+}
+
+// Serve speaks LSP over 'in' and 'out' until the client sends exit and returns
+// the exit code the protocol asks for.
+func Serve(in io.Reader, out io.Writer) int {
+	s := &Server{in: bufio.NewReader(in), out: out, docs: make(map[string]*document)}
+	for {
+		body, err := readMessage(s.in)
+		if err != nil {
+			return 1
+		}
+		var msg message
+		if err := json.Unmarshal(body, &msg); err != nil {
+			s.write(errorResponse{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: responseError{ERR_PARSE, err.Error()}})
+			continue
+		}
+		if msg.Method == "exit" {
+			if s.shutdown {
+				return 0
+			}
+			return 1
+		}
+		result, failure := s.handle(msg)
+		if len(msg.ID) == 0 {
+			continue
+		}
+		if failure != nil {
+			s.write(errorResponse{JSONRPC: "2.0", ID: msg.ID, Error: *failure})
+			continue
+		}
+		s.write(response{JSONRPC: "2.0", ID: msg.ID, Result: result})
+	}
+}
+
+func (s *Server) write(v interface{}) {
+	writeMessage(s.out, v)
+}
+
+func (s *Server) notify(method string, params interface{}) {
+	s.write(notification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+var capabilities = map[string]interface{}{
+	"capabilities": map[string]interface{}{
+		"textDocumentSync":       1, // full
+		"hoverProvider":          true,
+		"definitionProvider":     true,
+		"documentSymbolProvider": true,
+		"completionProvider":     map[string]interface{}{"triggerCharacters": []string{"."}},
+	},
+	"serverInfo": map[string]string{"name": "dennis"},
+}
+
+func (s *Server) handle(msg message) (interface{}, *responseError) {
+	switch msg.Method {
+	case "initialize":
+		return capabilities, nil
+	case "shutdown":
+		{
+			s.shutdown = true
+			return nil, nil
+		}
+	case "textDocument/didOpen":
+		{
+			var params DidOpenParams
+			if failure := decode(msg, &params); failure != nil {
+				return nil, failure
+			}
+			s.update(params.TextDocument.URI, params.TextDocument.Text)
+			return nil, nil
+		}
+	case "textDocument/didChange":
+		{
+			var params DidChangeParams
+			if failure := decode(msg, &params); failure != nil {
+				return nil, failure
+			}
+			if n := len(params.ContentChanges); n > 0 {
+				s.update(params.TextDocument.URI, params.ContentChanges[n-1].Text)
+			}
+			return nil, nil
+		}
+	case "textDocument/didClose":
+		{
+			var params DidCloseParams
+			if failure := decode(msg, &params); failure != nil {
+				return nil, failure
+			}
+			if doc, ok := s.docs[params.TextDocument.URI]; ok {
+				delete(s.docs, doc.uri)
+				for uri := range doc.published {
+					s.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{URI: uri, Diagnostics: []Diagnostic{}})
+				}
+				s.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{URI: doc.uri, Diagnostics: []Diagnostic{}})
+			}
+			return nil, nil
+		}
+	case "textDocument/hover", "textDocument/definition", "textDocument/completion":
+		{
+			var params TextDocumentPositionParams
+			if failure := decode(msg, &params); failure != nil {
+				return nil, failure
+			}
+			doc, ok := s.docs[params.TextDocument.URI]
+			if !ok {
+				return nil, nil
+			}
+			switch msg.Method {
+			case "textDocument/hover":
+				return s.hover(doc, params.Position), nil
+			case "textDocument/definition":
+				return s.definition(doc, params.Position), nil
+			}
+			return s.completion(doc, params.Position), nil
+		}
+	case "textDocument/documentSymbol":
+		{
+			var params DocumentSymbolParams
+			if failure := decode(msg, &params); failure != nil {
+				return nil, failure
+			}
+			doc, ok := s.docs[params.TextDocument.URI]
+			if !ok {
+				return []DocumentSymbol{}, nil
+			}
+			return s.symbols(doc), nil
+		}
+	}
+	if len(msg.ID) == 0 {
+		// Notifications nobody handles, 'initialized' and '$/cancelRequest' among them.
+		return nil, nil
+	}
+	return nil, &responseError{ERR_METHOD_NOT_FOUND, fmt.Sprintf("Method '%s' isn't supported", msg.Method)}
+}
+
+func decode(msg message, params interface{}) *responseError {
+	if err := json.Unmarshal(msg.Params, params); err != nil {
+		return &responseError{ERR_INVALID_PARAMS, err.Error()}
+	}
+	return nil
+}
+
+// update replaces the text of a document, checks it and publishes its
+// diagnostics, including the ones found in the modules it imports.
+func (s *Server) update(uri string, text string) {
+	doc, ok := s.docs[uri]
+	if !ok {
+		doc = &document{uri: uri, path: uriToPath(uri), published: make(map[string]bool)}
+		s.docs[uri] = doc
+	}
+	doc.text = []byte(text)
+	doc.analyze()
+
+	byURI := map[string][]Diagnostic{uri: {}}
+	for _, e := range doc.session.Diagnostics.Errors() {
+		target := uri
+		if e.Pos.File != "" && e.Pos.File != doc.path {
+			target = pathToURI(e.Pos.File)
+		}
+		byURI[target] = append(byURI[target], Diagnostic{Range: s.rangeOf(e.Pos), Severity: DIAGNOSTIC_ERROR, Source: "dennis", Message: e.Msg})
+	}
+	for other := range doc.published {
+		if _, ok := byURI[other]; !ok {
+			byURI[other] = []Diagnostic{}
+		}
+	}
+	uris := make([]string, 0, len(byURI))
+	for target := range byURI {
+		uris = append(uris, target)
+	}
+	sort.Strings(uris)
+	doc.published = make(map[string]bool)
+	for _, target := range uris {
+		if target != uri && len(byURI[target]) > 0 {
+			doc.published[target] = true
+		}
+		s.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{URI: target, Diagnostics: byURI[target]})
+	}
+}
@@ -0,0 +1,63 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+// serve runs the server on the messages in 'msgs' and returns the messages it wrote.
+func serve(t *testing.T, msgs ...interface{}) []message {
+	t.Helper()
+	var in, out bytes.Buffer
+	for _, msg := range msgs {
+		writeMessage(&in, msg)
+	}
+	if code := Serve(&in, &out); code != 0 {
+		t.Fatalf("server exited with %d", code)
+	}
+	list := []message{}
+	r := bufio.NewReader(&out)
+	for {
+		body, err := readMessage(r)
+		if err != nil {
+			return list
+		}
+		var msg message
+		if err := json.Unmarshal(body, &msg); err != nil {
+			t.Fatalf("bad message %s: %s", body, err)
+		}
+		list = append(list, msg)
+	}
+}
+
+func TestUnterminatedStringAtEnd(t *testing.T) {
+	uri := "file:///tmp/typing.des"
+	text := "fn main():i32 {\n  let s:string = \""
+	open := DidOpenParams{TextDocument: TextDocumentItem{URI: uri, LanguageID: "dennis", Version: 1, Text: text}}
+	msgs := serve(t,
+		notification{JSONRPC: "2.0", Method: "textDocument/didOpen", Params: open},
+		message{JSONRPC: "2.0", ID: json.RawMessage("1"), Method: "shutdown"},
+		notification{JSONRPC: "2.0", Method: "exit"},
+	)
+	for _, msg := range msgs {
+		if msg.Method != "textDocument/publishDiagnostics" {
+			continue
+		}
+		var params PublishDiagnosticsParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			t.Fatal(err)
+		}
+		if params.URI != uri {
+			continue
+		}
+		for _, diagnostic := range params.Diagnostics {
+			if diagnostic.Message == "Unterminated string" {
+				return
+			}
+		}
+		t.Fatalf("diagnostics %+v don't report the unterminated string", params.Diagnostics)
+	}
+	t.Fatalf("no diagnostics published for %s", uri)
+}
@@ -2,99 +2,103 @@ package main
 
 import (
 	"fmt"
-	"io"
 	"os"
 
-	"github.com/s0h1s2/ast"
-	"github.com/s0h1s2/checker"
-	"github.com/s0h1s2/error"
-	"github.com/s0h1s2/lexer"
-	"github.com/s0h1s2/parser"
-	"github.com/s0h1s2/resolver"
+	"github.com/s0h1s2/compiler"
+	"github.com/s0h1s2/manifest"
 )
 
-func printAst(expr ast.Expr) {
-	switch e := expr.(type) {
-	case *ast.ExprAssign:
-		{
-			printAst(e.Left)
-			print("=")
-			printAst(e.Right)
-		}
-	case *ast.ExprInt:
-		{
-			println(e.Value)
-		}
+// command is a subcommand of the dennis tool.
+type command struct {
+	name  string
+	args  string
+	short string
+	run   func(args []string) int
+	file  string // the file argument, in brackets when the package around the working directory may be compiled instead
+}
 
-	case *ast.ExprBinary:
-		{
-			printAst(e.Left)
-			printAst(e.Right)
-		}
+var commands []command
 
-	case *ast.ExprIdent:
-		{
-			println(e.Name)
-		}
-	default:
-		{
-			panic("UnReachable")
-		}
+func init() {
+	commands = []command{
+		{"build", "[-o file] [-c|-S|-emit=kind] [-target=x86_64|wasm32] [-l lib] [-L dir] [-O0|-O1|-O2] [-g]", "compile a program into an executable", build, "[path-to-file]"},
+		{"run", "[-vm]", "interpret a program or run a .dbc file", runCmd, "[path-to-file]"},
+		{"check", "[-dump-symbols=json]", "report diagnostics without generating code", checkCmd, "[path-to-file]"},
+		{"tokens", "", "print the tokens of a file", tokensCmd, "path-to-file"},
+		{"ast", "[-json]", "print the syntax tree of a file", astCmd, "[path-to-file]"},
+		{"ir", "[-O0|-O1|-O2]", "print the optimized SSA form of a program", irCmd, "[path-to-file]"},
+		{"lsp", "", "serve the Language Server Protocol over stdin and stdout", lspCmd, ""},
+		{"repl", "", "evaluate declarations, statements and expressions interactively", replCmd, ""},
+		{"fmt", "[-w] [-d]", "reprint source files in the canonical layout", fmtCmd, "[path ...]"},
 	}
 }
-func hello(n ast.Node) bool {
-	println(n)
-	return false
-}
+
+// verbose prints the compiler stages as they run.
+var verbose bool
+
 func main() {
-	if len(os.Args) < 2 {
-		fmt.Printf("Usage: %s path-to-file\n", os.Args[0])
-		return
-	}
-	filePath := os.Args[1]
-	_, err := os.Stat(filePath)
-	if os.IsNotExist(err) {
-		fmt.Printf("Provided file '%s' doesn't exist.", filePath)
-		return
+	args := os.Args[1:]
+	if len(args) > 0 && (args[0] == "--verbose" || args[0] == "-verbose") {
+		verbose = true
+		args = args[1:]
 	}
-	file, err := os.Open(filePath)
-	if err != nil {
-		println("Unable to open file.")
-		return
+	if len(args) == 0 {
+		usage()
+		os.Exit(2)
 	}
-	src, err := io.ReadAll(file)
-	if err != nil {
-		println("Unable to read file.")
-		return
+	for _, cmd := range commands {
+		if cmd.name == args[0] {
+			os.Exit(cmd.run(args[1:]))
+		}
 	}
-	file.Close()
-	bag := error.New()
-	lex := lexer.New(bag)
-	tokens := lex.GetTokens([]byte(src))
-	for _, token := range tokens {
-		fmt.Println(token.String())
+	if args[0] != "help" && args[0] != "-h" && args[0] != "--help" {
+		fmt.Fprintf(os.Stderr, "Unknown command '%s'.\n", args[0])
 	}
-	if bag.GotErrors() {
-		bag.PrintErrors()
-		return
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags] [path-to-file]\n\nCommands:\n", os.Args[0])
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", cmd.name, cmd.short)
 	}
-	// Passes
-	parser := parser.New(tokens, bag)
-	tree := parser.Parse()
-	if bag.GotErrors() {
-		bag.PrintErrors()
-		return
+	fmt.Fprintf(os.Stderr, "\nWithout a file, build, run, check, ast and ir compile the package described\nby the %s found in the working directory or its parents.\n", manifest.FILE_NAME)
+	fmt.Fprintf(os.Stderr, "\nRun '%s <command> -h' for the flags of a command.\n", os.Args[0])
+}
+
+// newSession creates a compiler session that traces its stages under --verbose.
+func newSession() *compiler.Session {
+	session := compiler.NewSession()
+	if verbose {
+		session.Trace = os.Stderr
 	}
+	return session
+}
 
-	table, resolvedDecls := resolver.Resolve(tree, bag)
-	if bag.GotErrors() {
-		bag.PrintErrors()
-		return
+// failed prints the diagnostics of a session and returns the exit code for them.
+func failed(session *compiler.Session) int {
+	session.Diagnostics.PrintErrors()
+	return 1
+}
+
+func readSource(filePath string) ([]byte, bool) {
+	src, err := os.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "Provided file '%s' doesn't exist.\n", filePath)
+		return nil, false
 	}
-	checker.Check(resolvedDecls, table, bag)
-	if bag.GotErrors() {
-		bag.PrintErrors()
-		return
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to read '%s'.\n", filePath)
+		return nil, false
 	}
+	return src, true
+}
 
+func writeOutput(path string, data []byte) bool {
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to write '%s'.\n", path)
+		return false
+	}
+	return true
 }
@@ -0,0 +1,306 @@
+package manifest
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/s0h1s2/error"
+)
+
+// FILE_NAME is the name of the manifest at the root of a package.
+const FILE_NAME = "dennis.toml"
+
+// Manifest describes a package:
+//
+//	[package]
+//	name = "shapes"
+//	entry = "src/main.des"
+//	sources = ["src"]
+//	target = "x86_64"
+//
+//	[link]
+//	libs = ["m"]
+//	dirs = ["/opt/lib"]
+//
+//	[dependencies]
+//	geo = { path = "../geo" }
+//
+// Paths are relative to the directory of the manifest.
+type Manifest struct {
+	Dir          string
+	Name         string // defaults to the base name of Dir
+	Entry        string // defaults to main.des in the first source root
+	Sources      []string
+	Target       string
+	Libs         []string
+	LibDirs      []string
+	Dependencies []Dependency
+}
+
+// Dependency is a package living in a local directory. Imports whose first
+// element is Name are looked up in its source roots.
+type Dependency struct {
+	Name     string
+	Pos      error.Position
+	Manifest *Manifest
+}
+
+// Find looks for a manifest in 'dir' and then in its parents.
+func Find(dir string) (string, bool) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", false
+	}
+	for {
+		path := filepath.Join(dir, FILE_NAME)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path, true
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		dir = parent
+	}
+}
+
+type loader struct {
+	bag      *error.DiagnosticBag
+	packages map[string]*Manifest // by directory, nil while its dependencies load
+}
+
+// Load reads the manifest at 'path' and those of its dependencies.
+func Load(path string, bag *error.DiagnosticBag) *Manifest {
+	l := &loader{bag: bag, packages: make(map[string]*Manifest)}
+	m := l.load(path, error.Position{})
+	if m != nil {
+		m.checkNames(bag)
+	}
+	return m
+}
+
+func (l *loader) load(path string, pos error.Position) *Manifest {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		l.bag.ReportError(pos, "Unable to read '%s'", path)
+		return nil
+	}
+	dir, _ := filepath.Abs(filepath.Dir(path))
+	l.packages[dir] = nil
+	root := parseTOML(path, src, l.bag)
+	m := &Manifest{Dir: dir, Name: filepath.Base(dir), Target: "x86_64"}
+	d := &decoder{bag: l.bag}
+	for _, name := range root.keys {
+		v := root.values[name]
+		section := d.table(v, name)
+		if section == nil {
+			continue
+		}
+		switch name {
+		case "package":
+			{
+				for _, key := range section.keys {
+					v := section.values[key]
+					switch key {
+					case "name":
+						m.Name = d.str(v, key)
+					case "entry":
+						m.Entry = d.str(v, key)
+					case "sources":
+						m.Sources = d.strs(v, key)
+					case "target":
+						m.Target = d.str(v, key)
+					default:
+						l.bag.ReportError(v.Pos, "Unknown key '%s' in [package]", key)
+					}
+				}
+			}
+		case "link":
+			{
+				for _, key := range section.keys {
+					v := section.values[key]
+					switch key {
+					case "libs":
+						m.Libs = d.strs(v, key)
+					case "dirs":
+						m.LibDirs = d.strs(v, key)
+					default:
+						l.bag.ReportError(v.Pos, "Unknown key '%s' in [link]", key)
+					}
+				}
+			}
+		case "dependencies":
+			{
+				for _, key := range section.keys {
+					if dep := l.dependency(m, key, section.values[key], d); dep != nil {
+						m.Dependencies = append(m.Dependencies, Dependency{Name: key, Pos: section.values[key].Pos, Manifest: dep})
+					}
+				}
+			}
+		default:
+			l.bag.ReportError(v.Pos, "Unknown table [%s]", name)
+		}
+	}
+	if len(m.Sources) == 0 {
+		m.Sources = []string{"."}
+		if info, err := os.Stat(filepath.Join(dir, "src")); err == nil && info.IsDir() {
+			m.Sources = []string{"src"}
+		}
+	}
+	if m.Entry == "" {
+		m.Entry = filepath.Join(m.Sources[0], "main.des")
+	}
+	l.packages[dir] = m
+	return m
+}
+
+// dependency loads the package a '[dependencies]' entry names, written either
+// as 'geo = { path = "../geo" }' or as 'geo = "../geo"'.
+func (l *loader) dependency(m *Manifest, name string, v *value, d *decoder) *Manifest {
+	path := ""
+	switch val := v.Val.(type) {
+	case string:
+		path = val
+	case *table:
+		{
+			for _, key := range val.keys {
+				if key != "path" {
+					l.bag.ReportError(val.values[key].Pos, "Unknown key '%s' in dependency '%s'", key, name)
+					continue
+				}
+				path = d.str(val.values[key], key)
+			}
+			if path == "" {
+				l.bag.ReportError(v.Pos, "Dependency '%s' needs a path", name)
+				return nil
+			}
+		}
+	default:
+		l.bag.ReportError(v.Pos, "Dependency '%s' must be a path or a table", name)
+		return nil
+	}
+	dir := m.resolve(path)
+	if dep, ok := l.packages[dir]; ok {
+		if dep == nil {
+			l.bag.ReportError(v.Pos, "Dependency '%s' depends on '%s' in turn", name, m.Name)
+		}
+		return dep
+	}
+	return l.load(filepath.Join(dir, FILE_NAME), v.Pos)
+}
+
+// checkNames reports dependencies sharing a name while being different
+// packages, imports couldn't tell them apart.
+func (m *Manifest) checkNames(bag *error.DiagnosticBag) {
+	seen := make(map[string]*Manifest)
+	m.walk(func(owner *Manifest, dep Dependency) {
+		if prev, ok := seen[dep.Name]; ok && prev != dep.Manifest {
+			bag.ReportError(dep.Pos, "Dependency '%s' of '%s' isn't the package '%s' refers to elsewhere", dep.Name, owner.Name, dep.Name)
+			return
+		}
+		seen[dep.Name] = dep.Manifest
+	})
+}
+
+// walk calls 'visit' for the dependencies of the package and of every package it
+// depends on, each package is visited once.
+func (m *Manifest) walk(visit func(owner *Manifest, dep Dependency)) {
+	visited := map[*Manifest]bool{m: true}
+	queue := []*Manifest{m}
+	for len(queue) > 0 {
+		owner := queue[0]
+		queue = queue[1:]
+		for _, dep := range owner.Dependencies {
+			visit(owner, dep)
+			if !visited[dep.Manifest] {
+				visited[dep.Manifest] = true
+				queue = append(queue, dep.Manifest)
+			}
+		}
+	}
+}
+
+func (m *Manifest) resolve(path string) string {
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path)
+	}
+	return filepath.Join(m.Dir, path)
+}
+
+// EntryPath is the file the package is compiled from.
+func (m *Manifest) EntryPath() string {
+	return m.resolve(m.Entry)
+}
+
+// Roots lists the source roots of the package.
+func (m *Manifest) Roots() []string {
+	roots := make([]string, len(m.Sources))
+	for i, source := range m.Sources {
+		roots[i] = m.resolve(source)
+	}
+	return roots
+}
+
+// Packages maps the names of the dependencies, direct or not, to their
+// source roots.
+func (m *Manifest) Packages() map[string][]string {
+	packages := make(map[string][]string)
+	m.walk(func(owner *Manifest, dep Dependency) {
+		if _, ok := packages[dep.Name]; !ok {
+			packages[dep.Name] = dep.Manifest.Roots()
+		}
+	})
+	return packages
+}
+
+// Link returns the libraries the package and its dependencies link against
+// and the directories to search for them.
+func (m *Manifest) Link() ([]string, []string) {
+	libs, dirs := []string{}, []string{}
+	add := func(pkg *Manifest) {
+		libs = append(libs, pkg.Libs...)
+		for _, dir := range pkg.LibDirs {
+			dirs = append(dirs, pkg.resolve(dir))
+		}
+	}
+	add(m)
+	visited := map[*Manifest]bool{m: true}
+	m.walk(func(owner *Manifest, dep Dependency) {
+		if !visited[dep.Manifest] {
+			visited[dep.Manifest] = true
+			add(dep.Manifest)
+		}
+	})
+	return libs, dirs
+}
+
+type decoder struct {
+	bag *error.DiagnosticBag
+}
+
+func (d *decoder) table(v *value, key string) *table {
+	t, ok := v.Val.(*table)
+	if !ok {
+		d.bag.ReportError(v.Pos, "'%s' must be a table", key)
+	}
+	return t
+}
+func (d *decoder) str(v *value, key string) string {
+	s, ok := v.Val.(string)
+	if !ok {
+		d.bag.ReportError(v.Pos, "'%s' must be a string", key)
+	}
+	return s
+}
+func (d *decoder) strs(v *value, key string) []string {
+	items, ok := v.Val.([]*value)
+	if !ok {
+		d.bag.ReportError(v.Pos, "'%s' must be an array of strings", key)
+		return nil
+	}
+	result := make([]string, 0, len(items))
+	for _, item := range items {
+		result = append(result, d.str(item, key))
+	}
+	return result
+}
@@ -0,0 +1,291 @@
+package manifest
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/s0h1s2/error"
+)
+
+// value is a parsed TOML value, Val holds a string, int64, bool, []*value or
+// *table.
+type value struct {
+	Pos error.Position
+	Val interface{}
+}
+
+// table keeps its keys in the order they were written.
+type table struct {
+	keys   []string
+	values map[string]*value
+}
+
+func newTable() *table {
+	return &table{values: make(map[string]*value)}
+}
+
+// tomlParser reads the subset of TOML manifests need: [table] headers, bare
+// keys, basic and literal strings, integers, booleans, arrays and inline tables.
+type tomlParser struct {
+	src    []byte
+	file   string
+	pos    int
+	line   int
+	lineAt int
+	bag    *error.DiagnosticBag
+}
+
+func parseTOML(file string, src []byte, bag *error.DiagnosticBag) *table {
+	p := &tomlParser{src: src, file: file, line: 1, bag: bag}
+	root := newTable()
+	current := root
+	for {
+		p.skipSpace(true)
+		if p.atEnd() {
+			return root
+		}
+		start := p.pos
+		if p.peek() == '[' {
+			p.pos++
+			p.skipSpace(false)
+			name := p.key()
+			p.skipSpace(false)
+			if p.peek() == '.' {
+				p.report(start, "Nested table '%s' isn't supported", name)
+				return root
+			}
+			if !p.expect(']') {
+				return root
+			}
+			if _, ok := root.values[name]; ok {
+				p.report(start, "Table '%s' is defined more than once", name)
+				return root
+			}
+			current = newTable()
+			p.set(root, name, &value{Pos: p.position(start), Val: current})
+		} else {
+			if !p.keyValue(current) {
+				return root
+			}
+		}
+		p.skipSpace(false)
+		if !p.atEnd() && p.peek() != '\n' {
+			p.report(p.pos, "Expected end of line but got '%c'", p.peek())
+			return root
+		}
+	}
+}
+
+func (p *tomlParser) atEnd() bool {
+	return p.pos >= len(p.src)
+}
+func (p *tomlParser) peek() byte {
+	if p.atEnd() {
+		return 0
+	}
+	return p.src[p.pos]
+}
+func (p *tomlParser) position(start int) error.Position {
+	return error.Position{File: p.file, Start: start, End: p.pos, Line: p.line, Column: start - p.lineAt + 1}
+}
+func (p *tomlParser) report(start int, format string, args ...interface{}) {
+	p.bag.ReportError(p.position(start), format, args...)
+}
+
+// skipSpace skips blanks and comments, and line breaks too when 'newlines' is set.
+func (p *tomlParser) skipSpace(newlines bool) {
+	for !p.atEnd() {
+		switch c := p.peek(); {
+		case c == ' ' || c == '\t' || c == '\r':
+			p.pos++
+		case c == '#':
+			for !p.atEnd() && p.peek() != '\n' {
+				p.pos++
+			}
+		case c == '\n' && newlines:
+			p.pos++
+			p.line++
+			p.lineAt = p.pos
+		default:
+			return
+		}
+	}
+}
+func (p *tomlParser) expect(c byte) bool {
+	if p.peek() != c {
+		if p.atEnd() {
+			p.report(p.pos, "Expected '%c' but got end of file", c)
+		} else {
+			p.report(p.pos, "Expected '%c' but got '%c'", c, p.peek())
+		}
+		return false
+	}
+	p.pos++
+	return true
+}
+func isKeyChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+func (p *tomlParser) key() string {
+	start := p.pos
+	for !p.atEnd() && isKeyChar(p.peek()) {
+		p.pos++
+	}
+	if start == p.pos {
+		p.report(start, "Expected a key")
+	}
+	return string(p.src[start:p.pos])
+}
+func (p *tomlParser) set(t *table, name string, v *value) {
+	if _, ok := t.values[name]; !ok {
+		t.keys = append(t.keys, name)
+	}
+	t.values[name] = v
+}
+
+// keyValue parses 'key = value' into 't'.
+func (p *tomlParser) keyValue(t *table) bool {
+	start := p.pos
+	name := p.key()
+	if name == "" {
+		return false
+	}
+	p.skipSpace(false)
+	if !p.expect('=') {
+		return false
+	}
+	p.skipSpace(false)
+	if _, ok := t.values[name]; ok {
+		p.report(start, "Key '%s' is defined more than once", name)
+		return false
+	}
+	v := p.value()
+	if v == nil {
+		return false
+	}
+	p.set(t, name, v)
+	return true
+}
+
+func (p *tomlParser) value() *value {
+	start := p.pos
+	switch c := p.peek(); {
+	case c == '"' || c == '\'':
+		{
+			str, ok := p.str(c)
+			if !ok {
+				return nil
+			}
+			return &value{Pos: p.position(start), Val: str}
+		}
+	case c == '[':
+		{
+			p.pos++
+			items := []*value{}
+			for {
+				p.skipSpace(true)
+				if p.peek() == ']' {
+					break
+				}
+				item := p.value()
+				if item == nil {
+					return nil
+				}
+				items = append(items, item)
+				p.skipSpace(true)
+				if p.peek() != ',' {
+					break
+				}
+				p.pos++
+			}
+			if !p.expect(']') {
+				return nil
+			}
+			return &value{Pos: p.position(start), Val: items}
+		}
+	case c == '{':
+		{
+			p.pos++
+			inline := newTable()
+			p.skipSpace(false)
+			for p.peek() != '}' {
+				if !p.keyValue(inline) {
+					return nil
+				}
+				p.skipSpace(false)
+				if p.peek() != ',' {
+					break
+				}
+				p.pos++
+				p.skipSpace(false)
+			}
+			if !p.expect('}') {
+				return nil
+			}
+			return &value{Pos: p.position(start), Val: inline}
+		}
+	case c == '-' || c == '+' || c >= '0' && c <= '9':
+		{
+			p.pos++
+			for !p.atEnd() && (p.peek() >= '0' && p.peek() <= '9' || p.peek() == '_') {
+				p.pos++
+			}
+			text := strings.ReplaceAll(string(p.src[start:p.pos]), "_", "")
+			n, err := strconv.ParseInt(text, 10, 64)
+			if err != nil {
+				p.report(start, "Invalid integer '%s'", text)
+				return nil
+			}
+			return &value{Pos: p.position(start), Val: n}
+		}
+	case isKeyChar(c):
+		{
+			word := p.key()
+			if word == "true" || word == "false" {
+				return &value{Pos: p.position(start), Val: word == "true"}
+			}
+			p.report(start, "Expected a value but got '%s'", word)
+			return nil
+		}
+	}
+	if p.atEnd() {
+		p.report(start, "Expected a value but got end of file")
+	} else {
+		p.report(start, "Expected a value but got '%c'", p.peek())
+	}
+	return nil
+}
+
+// str parses a string delimited by 'quote', escapes are only recognized in
+// basic "..." strings.
+func (p *tomlParser) str(quote byte) (string, bool) {
+	start := p.pos
+	p.pos++
+	var sb strings.Builder
+	for !p.atEnd() && p.peek() != quote && p.peek() != '\n' {
+		c := p.peek()
+		p.pos++
+		if c != '\\' || quote == '\'' {
+			sb.WriteByte(c)
+			continue
+		}
+		switch p.peek() {
+		case 'n':
+			sb.WriteByte('\n')
+		case 't':
+			sb.WriteByte('\t')
+		case '"', '\\':
+			sb.WriteByte(p.peek())
+		default:
+			p.report(p.pos-1, "Unknown escape sequence '\\%c'", p.peek())
+			return "", false
+		}
+		p.pos++
+	}
+	if p.peek() != quote {
+		p.report(start, "Unterminated string")
+		return "", false
+	}
+	p.pos++
+	return sb.String(), true
+}
@@ -0,0 +1,124 @@
+package opt
+
+import "github.com/s0h1s2/ir"
+
+func constOf(v ir.Value) (int64, bool) {
+	c, ok := v.(*ir.Const)
+	if !ok {
+		return 0, false
+	}
+	return c.Value, true
+}
+
+func boolConst(instr *ir.Instr, b bool) *ir.Const {
+	if b {
+		return &ir.Const{Typ: instr.Typ, Value: 1}
+	}
+	return &ir.Const{Typ: instr.Typ, Value: 0}
+}
+
+// fold evaluates an instruction whose operands are known, returning nil when it can't.
+func fold(instr *ir.Instr) ir.Value {
+	switch {
+	case instr.Op.IsArithmetic() || instr.Op.IsCompare():
+		{
+			x, xok := constOf(instr.Args[0])
+			y, yok := constOf(instr.Args[1])
+			if xok && yok {
+				switch instr.Op {
+				case ir.OP_ADD:
+					return &ir.Const{Typ: instr.Typ, Value: ir.Truncate(x+y, instr.Typ)}
+				case ir.OP_SUB:
+					return &ir.Const{Typ: instr.Typ, Value: ir.Truncate(x-y, instr.Typ)}
+				case ir.OP_MUL:
+					return &ir.Const{Typ: instr.Typ, Value: ir.Truncate(x*y, instr.Typ)}
+				case ir.OP_DIV:
+					// Division by zero is left for the program to trap at run time.
+					if y == 0 || x == -1<<63 && y == -1 {
+						return nil
+					}
+					return &ir.Const{Typ: instr.Typ, Value: ir.Truncate(x/y, instr.Typ)}
+				case ir.OP_EQ:
+					return boolConst(instr, x == y)
+				case ir.OP_NE:
+					return boolConst(instr, x != y)
+				case ir.OP_LT:
+					return boolConst(instr, x < y)
+				case ir.OP_LE:
+					return boolConst(instr, x <= y)
+				case ir.OP_GT:
+					return boolConst(instr, x > y)
+				case ir.OP_GE:
+					return boolConst(instr, x >= y)
+				}
+			}
+			// Algebraic identities.
+			switch {
+			case instr.Op == ir.OP_ADD && xok && x == 0:
+				return instr.Args[1]
+			case (instr.Op == ir.OP_ADD || instr.Op == ir.OP_SUB) && yok && y == 0:
+				return instr.Args[0]
+			case instr.Op == ir.OP_MUL && xok && x == 1:
+				return instr.Args[1]
+			case (instr.Op == ir.OP_MUL || instr.Op == ir.OP_DIV) && yok && y == 1:
+				return instr.Args[0]
+			case instr.Op == ir.OP_MUL && (xok && x == 0 || yok && y == 0):
+				return &ir.Const{Typ: instr.Typ, Value: 0}
+			case instr.Op == ir.OP_SUB && instr.Args[0] == instr.Args[1]:
+				return &ir.Const{Typ: instr.Typ, Value: 0}
+			}
+		}
+	case instr.Op == ir.OP_NOT:
+		{
+			if x, ok := constOf(instr.Args[0]); ok {
+				return boolConst(instr, x == 0)
+			}
+		}
+	case instr.Op == ir.OP_SEXT || instr.Op == ir.OP_TRUNC:
+		{
+			if x, ok := constOf(instr.Args[0]); ok {
+				return &ir.Const{Typ: instr.Typ, Value: ir.Truncate(x, instr.Typ)}
+			}
+		}
+	}
+	return nil
+}
+
+// constFold replaces instructions computed from constants by their value and
+// turns conditional branches on a constant into jumps.
+func constFold(fn *ir.Function) bool {
+	changed := false
+	for _, b := range fn.Blocks {
+		kept := b.Instrs[:0]
+		for _, instr := range b.Instrs {
+			if v := fold(instr); v != nil {
+				replaceUses(fn, instr, v)
+				changed = true
+				continue
+			}
+			kept = append(kept, instr)
+		}
+		b.Instrs = kept
+		term := b.Terminator()
+		if term == nil || term.Op != ir.OP_BR {
+			continue
+		}
+		cond, ok := constOf(term.Args[0])
+		if !ok {
+			continue
+		}
+		taken, dropped := term.Targets[0], term.Targets[1]
+		if cond == 0 {
+			taken, dropped = dropped, taken
+		}
+		term.Op = ir.OP_JMP
+		term.Args = nil
+		term.Targets = []*ir.Block{taken}
+		removeEdge(b, dropped)
+		changed = true
+	}
+	if changed {
+		removeUnreachable(fn)
+	}
+	return changed
+}
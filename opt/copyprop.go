@@ -0,0 +1,54 @@
+package opt
+
+import "github.com/s0h1s2/ir"
+
+// trivialPhi returns the single value a phi merges, ignoring references to itself.
+func trivialPhi(phi *ir.Instr) ir.Value {
+	var same ir.Value
+	for _, arg := range phi.Args {
+		if arg == phi || arg == same {
+			continue
+		}
+		if c, ok := arg.(*ir.Const); ok {
+			if s, ok := same.(*ir.Const); ok && s.Value == c.Value {
+				continue
+			}
+		}
+		if same != nil {
+			return nil
+		}
+		same = arg
+	}
+	return same
+}
+
+// copyProp forwards the source of copies and trivial phis to their uses.
+func copyProp(fn *ir.Function) bool {
+	changed := false
+	for progress := true; progress; {
+		progress = false
+		for _, b := range fn.Blocks {
+			kept := b.Instrs[:0]
+			for _, instr := range b.Instrs {
+				var src ir.Value
+				switch instr.Op {
+				case ir.OP_COPY:
+					src = instr.Args[0]
+				case ir.OP_PHI:
+					src = trivialPhi(instr)
+				}
+				if src == nil {
+					kept = append(kept, instr)
+					continue
+				}
+				replaceUses(fn, instr, src)
+				progress = true
+			}
+			b.Instrs = kept
+		}
+		if progress {
+			changed = true
+		}
+	}
+	return changed
+}
@@ -0,0 +1,42 @@
+package opt
+
+import "github.com/s0h1s2/ir"
+
+// dce removes unreachable blocks and every instruction that doesn't
+// contribute to a side effect, including dead phi cycles.
+func dce(fn *ir.Function) bool {
+	changed := removeUnreachable(fn)
+	live := map[*ir.Instr]bool{}
+	work := []*ir.Instr{}
+	for _, b := range fn.Blocks {
+		for _, instr := range b.Instrs {
+			if hasSideEffects(instr) {
+				live[instr] = true
+				work = append(work, instr)
+			}
+		}
+	}
+	for len(work) > 0 {
+		instr := work[len(work)-1]
+		work = work[:len(work)-1]
+		for _, arg := range instr.Args {
+			if def, ok := arg.(*ir.Instr); ok && !live[def] {
+				live[def] = true
+				work = append(work, def)
+			}
+		}
+	}
+	for _, b := range fn.Blocks {
+		kept := b.Instrs[:0]
+		for _, instr := range b.Instrs {
+			if live[instr] {
+				kept = append(kept, instr)
+			}
+		}
+		if len(kept) != len(b.Instrs) {
+			changed = true
+		}
+		b.Instrs = kept
+	}
+	return changed
+}
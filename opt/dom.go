@@ -0,0 +1,101 @@
+package opt
+
+import "github.com/s0h1s2/ir"
+
+// domTree holds immediate dominators, computed with the iterative algorithm
+// of Cooper, Harvey and Kennedy.
+type domTree struct {
+	idom     map[*ir.Block]*ir.Block
+	children map[*ir.Block][]*ir.Block
+	order    map[*ir.Block]int // reverse postorder index
+	rpo      []*ir.Block
+}
+
+func reversePostorder(entry *ir.Block) []*ir.Block {
+	visited := map[*ir.Block]bool{}
+	post := []*ir.Block{}
+	var visit func(b *ir.Block)
+	visit = func(b *ir.Block) {
+		visited[b] = true
+		for _, succ := range b.Succs {
+			if !visited[succ] {
+				visit(succ)
+			}
+		}
+		post = append(post, b)
+	}
+	visit(entry)
+	for i, j := 0, len(post)-1; i < j; i, j = i+1, j-1 {
+		post[i], post[j] = post[j], post[i]
+	}
+	return post
+}
+
+func buildDomTree(fn *ir.Function) *domTree {
+	entry := fn.Blocks[0]
+	dt := &domTree{
+		idom:     map[*ir.Block]*ir.Block{entry: entry},
+		children: map[*ir.Block][]*ir.Block{},
+		order:    map[*ir.Block]int{},
+		rpo:      reversePostorder(entry),
+	}
+	for i, b := range dt.rpo {
+		dt.order[b] = i
+	}
+	intersect := func(a *ir.Block, b *ir.Block) *ir.Block {
+		for a != b {
+			for dt.order[a] > dt.order[b] {
+				a = dt.idom[a]
+			}
+			for dt.order[b] > dt.order[a] {
+				b = dt.idom[b]
+			}
+		}
+		return a
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, b := range dt.rpo[1:] {
+			var newIdom *ir.Block
+			for _, pred := range b.Preds {
+				if _, ok := dt.idom[pred]; !ok {
+					continue
+				}
+				if newIdom == nil {
+					newIdom = pred
+				} else {
+					newIdom = intersect(pred, newIdom)
+				}
+			}
+			if dt.idom[b] != newIdom {
+				dt.idom[b] = newIdom
+				changed = true
+			}
+		}
+	}
+	for _, b := range dt.rpo[1:] {
+		dt.children[dt.idom[b]] = append(dt.children[dt.idom[b]], b)
+	}
+	return dt
+}
+
+// frontiers computes the dominance frontier of every reachable block.
+func (dt *domTree) frontiers() map[*ir.Block][]*ir.Block {
+	df := map[*ir.Block][]*ir.Block{}
+	for _, b := range dt.rpo {
+		if len(b.Preds) < 2 {
+			continue
+		}
+		for _, pred := range b.Preds {
+			if _, ok := dt.order[pred]; !ok {
+				continue
+			}
+			for runner := pred; runner != dt.idom[b]; runner = dt.idom[runner] {
+				if indexOf(df[runner], b) < 0 {
+					df[runner] = append(df[runner], b)
+				}
+			}
+		}
+	}
+	return df
+}
@@ -0,0 +1,157 @@
+package opt
+
+import (
+	"github.com/s0h1s2/ir"
+	"github.com/s0h1s2/types"
+)
+
+// promotable reports whether every use of an alloca is a plain load or store of it.
+func promotable(fn *ir.Function, alloca *ir.Instr) bool {
+	if alloca.Typ.Base.Kind == types.TYPE_STRUCT {
+		return false
+	}
+	for _, b := range fn.Blocks {
+		for _, instr := range b.Instrs {
+			for i, arg := range instr.Args {
+				if arg != alloca {
+					continue
+				}
+				if instr.Op == ir.OP_LOAD || instr.Op == ir.OP_STORE && i == 1 {
+					continue
+				}
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// mem2reg promotes allocas whose address never escapes into SSA values,
+// placing phis on the iterated dominance frontier of their stores.
+func mem2reg(fn *ir.Function) bool {
+	removeUnreachable(fn)
+	entry := fn.Blocks[0]
+	vars := map[*ir.Instr]bool{}
+	allocas := []*ir.Instr{} // in order, so phis are placed the same way every time
+	for _, instr := range entry.Instrs {
+		if instr.Op == ir.OP_ALLOCA && promotable(fn, instr) {
+			vars[instr] = true
+			allocas = append(allocas, instr)
+		}
+	}
+	if len(allocas) == 0 {
+		return false
+	}
+
+	dt := buildDomTree(fn)
+	df := dt.frontiers()
+	phiVar := map[*ir.Instr]*ir.Instr{}
+	for _, alloca := range allocas {
+		work := []*ir.Block{}
+		for _, b := range fn.Blocks {
+			for _, instr := range b.Instrs {
+				if instr.Op == ir.OP_STORE && instr.Args[1] == alloca {
+					work = append(work, b)
+					break
+				}
+			}
+		}
+		placed := map[*ir.Block]bool{}
+		for len(work) > 0 {
+			b := work[len(work)-1]
+			work = work[:len(work)-1]
+			for _, frontier := range df[b] {
+				if placed[frontier] {
+					continue
+				}
+				placed[frontier] = true
+				phi := fn.NewInstr(ir.OP_PHI, alloca.Typ.Base)
+				phi.Args = make([]ir.Value, len(frontier.Preds))
+				frontier.Insert(0, phi)
+				phiVar[phi] = alloca
+				work = append(work, frontier)
+			}
+		}
+	}
+
+	replaced := map[ir.Value]ir.Value{}
+	resolve := func(v ir.Value) ir.Value {
+		for {
+			next, ok := replaced[v]
+			if !ok {
+				return v
+			}
+			v = next
+		}
+	}
+	stacks := map[*ir.Instr][]ir.Value{}
+	current := func(alloca *ir.Instr) ir.Value {
+		stack := stacks[alloca]
+		if len(stack) == 0 {
+			// Reading a variable before any store yields its zero value.
+			return &ir.Const{Typ: alloca.Typ.Base}
+		}
+		return stack[len(stack)-1]
+	}
+	var rename func(b *ir.Block)
+	rename = func(b *ir.Block) {
+		pushed := map[*ir.Instr]int{}
+		kept := b.Instrs[:0]
+		for _, instr := range b.Instrs {
+			if alloca, ok := phiVar[instr]; ok {
+				stacks[alloca] = append(stacks[alloca], instr)
+				pushed[alloca]++
+				kept = append(kept, instr)
+				continue
+			}
+			for i, arg := range instr.Args {
+				instr.Args[i] = resolve(arg)
+			}
+			switch {
+			case instr.Op == ir.OP_LOAD && vars[asInstr(instr.Args[0])]:
+				replaced[instr] = current(asInstr(instr.Args[0]))
+			case instr.Op == ir.OP_STORE && vars[asInstr(instr.Args[1])]:
+				alloca := asInstr(instr.Args[1])
+				stacks[alloca] = append(stacks[alloca], instr.Args[0])
+				pushed[alloca]++
+			case instr.Op == ir.OP_ALLOCA && vars[instr]:
+			default:
+				kept = append(kept, instr)
+			}
+		}
+		b.Instrs = kept
+		for _, succ := range b.Succs {
+			for i, pred := range succ.Preds {
+				if pred != b {
+					continue
+				}
+				for _, instr := range succ.Instrs {
+					if alloca, ok := phiVar[instr]; ok {
+						instr.Args[i] = current(alloca)
+					}
+				}
+			}
+		}
+		for _, child := range dt.children[b] {
+			rename(child)
+		}
+		for alloca, n := range pushed {
+			stacks[alloca] = stacks[alloca][:len(stacks[alloca])-n]
+		}
+	}
+	rename(entry)
+	// Phis may refer to loads that were replaced after the phi operand was filled in.
+	for _, b := range fn.Blocks {
+		for _, instr := range b.Instrs {
+			for i, arg := range instr.Args {
+				instr.Args[i] = resolve(arg)
+			}
+		}
+	}
+	return true
+}
+
+func asInstr(v ir.Value) *ir.Instr {
+	instr, _ := v.(*ir.Instr)
+	return instr
+}
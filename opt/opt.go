@@ -0,0 +1,147 @@
+package opt
+
+import (
+	"github.com/s0h1s2/ir"
+)
+
+// Pass transforms one function and reports whether it changed anything.
+type Pass struct {
+	Name string
+	Run  func(fn *ir.Function) bool
+}
+
+var (
+	Mem2Reg     = Pass{Name: "mem2reg", Run: mem2reg}
+	ConstFold   = Pass{Name: "constfold", Run: constFold}
+	CopyProp    = Pass{Name: "copyprop", Run: copyProp}
+	DCE         = Pass{Name: "dce", Run: dce}
+	SimplifyCFG = Pass{Name: "simplifycfg", Run: simplifyCFG}
+)
+
+const maxFixPoints = 10
+
+// Pipeline returns the passes run at an optimization level.
+func Pipeline(level int) []Pass {
+	switch {
+	case level <= 0:
+		return nil
+	case level == 1:
+		return []Pass{Mem2Reg, ConstFold, CopyProp, DCE}
+	}
+	return []Pass{Mem2Reg, ConstFold, CopyProp, DCE, SimplifyCFG}
+}
+
+// Optimize runs the pipeline for 'level' over every function of the module.
+// Level 2 and above repeat the pipeline until it stops making progress.
+func Optimize(module *ir.Module, level int) {
+	passes := Pipeline(level)
+	for _, fn := range module.Functions {
+		if fn.External {
+			continue
+		}
+		for i := 0; i < maxFixPoints; i++ {
+			changed := Run(fn, passes)
+			if !changed || level < 2 {
+				break
+			}
+		}
+	}
+}
+
+func Run(fn *ir.Function, passes []Pass) bool {
+	changed := false
+	for _, pass := range passes {
+		if pass.Run(fn) {
+			changed = true
+		}
+	}
+	return changed
+}
+
+// replaceUses rewrites every operand 'old' of the function into 'new'.
+func replaceUses(fn *ir.Function, old ir.Value, new ir.Value) {
+	for _, b := range fn.Blocks {
+		for _, instr := range b.Instrs {
+			for i, arg := range instr.Args {
+				if arg == old {
+					instr.Args[i] = new
+				}
+			}
+		}
+	}
+}
+
+func removeInstr(b *ir.Block, instr *ir.Instr) {
+	for i, in := range b.Instrs {
+		if in == instr {
+			b.Instrs = append(b.Instrs[:i], b.Instrs[i+1:]...)
+			return
+		}
+	}
+}
+
+func indexOf(blocks []*ir.Block, b *ir.Block) int {
+	for i, blk := range blocks {
+		if blk == b {
+			return i
+		}
+	}
+	return -1
+}
+
+// removeEdge drops one 'from' -> 'to' edge together with the phi operands flowing along it.
+func removeEdge(from *ir.Block, to *ir.Block) {
+	if i := indexOf(from.Succs, to); i >= 0 {
+		from.Succs = append(from.Succs[:i], from.Succs[i+1:]...)
+	}
+	i := indexOf(to.Preds, from)
+	if i < 0 {
+		return
+	}
+	to.Preds = append(to.Preds[:i], to.Preds[i+1:]...)
+	for _, instr := range to.Instrs {
+		if instr.Op != ir.OP_PHI {
+			break
+		}
+		instr.Args = append(instr.Args[:i], instr.Args[i+1:]...)
+	}
+}
+
+// removeUnreachable deletes blocks the entry block cannot reach.
+func removeUnreachable(fn *ir.Function) bool {
+	reachable := map[*ir.Block]bool{}
+	var visit func(b *ir.Block)
+	visit = func(b *ir.Block) {
+		if reachable[b] {
+			return
+		}
+		reachable[b] = true
+		for _, succ := range b.Succs {
+			visit(succ)
+		}
+	}
+	visit(fn.Blocks[0])
+	if len(reachable) == len(fn.Blocks) {
+		return false
+	}
+	kept := make([]*ir.Block, 0, len(reachable))
+	for _, b := range fn.Blocks {
+		if reachable[b] {
+			kept = append(kept, b)
+			continue
+		}
+		for len(b.Succs) > 0 {
+			removeEdge(b, b.Succs[0])
+		}
+	}
+	fn.Blocks = kept
+	return true
+}
+
+func hasSideEffects(instr *ir.Instr) bool {
+	switch instr.Op {
+	case ir.OP_STORE, ir.OP_COPYMEM, ir.OP_CALL, ir.OP_DIV:
+		return true
+	}
+	return instr.Op.IsTerminator()
+}
@@ -0,0 +1,354 @@
+package opt
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/s0h1s2/ast"
+	"github.com/s0h1s2/checker"
+	"github.com/s0h1s2/error"
+	"github.com/s0h1s2/ir"
+	"github.com/s0h1s2/lexer"
+	"github.com/s0h1s2/parser"
+	"github.com/s0h1s2/resolver"
+)
+
+// lower translates 'src' to SSA form without optimizing it and returns the
+// last function.
+func lower(t *testing.T, src string) *ir.Function {
+	t.Helper()
+	bag := error.New()
+	lex := lexer.New(bag)
+	lex.SetFile("test.des")
+	tokens := lex.GetTokens([]byte(src))
+	module := &ast.Module{Name: "test", Path: "test.des", Imports: map[string]*ast.Module{}}
+	module.Decls = parser.New(tokens, bag).Parse()
+	table, decls := resolver.Resolve([]*ast.Module{module}, bag)
+	if !bag.GotErrors() {
+		checker.Check(decls, table, bag)
+	}
+	if bag.GotErrors() {
+		t.Fatalf("%v", bag.Errors())
+	}
+	functions := ir.Lower(decls, table, bag).Functions
+	return functions[len(functions)-1]
+}
+
+// Each pass is run on a function prepared by the passes before it, the dumps
+// read like the output of 'dennis ir'.
+func TestPasses(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		setup  []Pass
+		pass   Pass
+		before string
+		after  string
+	}{
+		{
+			name: "mem2reg promotes locals",
+			src:  "fn main():i32 {\n  let a:i32 = 2;\n  let b:i32 = a * 3 + 1;\n  if b > 5 {\n    return b;\n  }\n  return 0;\n}\n",
+			pass: Mem2Reg,
+			before: `fn main(): i32 {
+entry:
+  %0 = alloca i32 ; a
+  %1 = alloca i32 ; b
+  store i32 2, %0
+  %2 = load i32, %0
+  %3 = mul i32 %2, 3
+  %4 = add i32 %3, 1
+  store i32 %4, %1
+  %5 = load i32, %1
+  %6 = gt i32 %5, 5
+  br %6, then1, endif2
+then1: ; preds entry
+  %7 = load i32, %1
+  ret i32 %7
+endif2: ; preds entry, dead3
+  ret i32 0
+dead3:
+  jmp endif2
+dead4:
+  ret i32 0
+}
+`,
+			after: `fn main(): i32 {
+entry:
+  %3 = mul i32 2, 3
+  %4 = add i32 %3, 1
+  %6 = gt i32 %4, 5
+  br %6, then1, endif2
+then1: ; preds entry
+  ret i32 %4
+endif2: ; preds entry
+  ret i32 0
+}
+`,
+		},
+		{
+			name: "mem2reg places phis in loops",
+			src:  "fn main():i32 {\n  let i:i32 = 0;\n  let s:i32 = 0;\n  while i < 10 {\n    s = s + i;\n    i = i + 1;\n  }\n  return s;\n}\n",
+			pass: Mem2Reg,
+			before: `fn main(): i32 {
+entry:
+  %0 = alloca i32 ; i
+  %1 = alloca i32 ; s
+  store i32 0, %0
+  store i32 0, %1
+  jmp loop1
+loop1: ; preds entry, body2
+  %2 = load i32, %0
+  %3 = lt i32 %2, 10
+  br %3, body2, endloop3
+body2: ; preds loop1
+  %4 = load i32, %1
+  %5 = load i32, %0
+  %6 = add i32 %4, %5
+  store i32 %6, %1
+  %7 = load i32, %0
+  %8 = add i32 %7, 1
+  store i32 %8, %0
+  jmp loop1
+endloop3: ; preds loop1
+  %9 = load i32, %1
+  ret i32 %9
+dead4:
+  ret i32 0
+}
+`,
+			after: `fn main(): i32 {
+entry:
+  jmp loop1
+loop1: ; preds entry, body2
+  %11 = phi i32 [0, entry], [%6, body2]
+  %10 = phi i32 [0, entry], [%8, body2]
+  %3 = lt i32 %10, 10
+  br %3, body2, endloop3
+body2: ; preds loop1
+  %6 = add i32 %11, %10
+  %8 = add i32 %10, 1
+  jmp loop1
+endloop3: ; preds loop1
+  ret i32 %11
+}
+`,
+		},
+		{
+			name:  "constfold folds values and branches",
+			src:   "fn main():i32 {\n  let a:i32 = 2;\n  let b:i32 = a * 3 + 1;\n  if b > 5 {\n    return b;\n  }\n  return 0;\n}\n",
+			setup: []Pass{Mem2Reg},
+			pass:  ConstFold,
+			before: `fn main(): i32 {
+entry:
+  %3 = mul i32 2, 3
+  %4 = add i32 %3, 1
+  %6 = gt i32 %4, 5
+  br %6, then1, endif2
+then1: ; preds entry
+  ret i32 %4
+endif2: ; preds entry
+  ret i32 0
+}
+`,
+			after: `fn main(): i32 {
+entry:
+  jmp then1
+then1: ; preds entry
+  ret i32 7
+}
+`,
+		},
+		{
+			name:  "constfold drops the phi operands of removed edges",
+			src:   "fn main():i32 {\n  let a:i32 = 1;\n  if a == 1 {\n    a = 2;\n  } else {\n    a = 3;\n  }\n  return a;\n}\n",
+			setup: []Pass{Mem2Reg},
+			pass:  ConstFold,
+			before: `fn main(): i32 {
+entry:
+  %2 = eq i32 1, 1
+  br %2, then1, else2
+then1: ; preds entry
+  jmp endif3
+else2: ; preds entry
+  jmp endif3
+endif3: ; preds then1, else2
+  %4 = phi i32 [2, then1], [3, else2]
+  ret i32 %4
+}
+`,
+			after: `fn main(): i32 {
+entry:
+  jmp then1
+then1: ; preds entry
+  jmp endif3
+endif3: ; preds then1
+  %4 = phi i32 [2, then1]
+  ret i32 %4
+}
+`,
+		},
+		{
+			name:  "copyprop forwards trivial phis",
+			src:   "fn f(p:i32):i32 {\n  let a:i32 = 0;\n  if p > 0 {\n    a = p;\n  } else {\n    a = p;\n  }\n  return a;\n}\n",
+			setup: []Pass{Mem2Reg, ConstFold},
+			pass:  CopyProp,
+			before: `fn f(%p: i32): i32 {
+entry:
+  %3 = gt i32 %p, 0
+  br %3, then1, else2
+then1: ; preds entry
+  jmp endif3
+else2: ; preds entry
+  jmp endif3
+endif3: ; preds then1, else2
+  %7 = phi i32 [%p, then1], [%p, else2]
+  ret i32 %7
+}
+`,
+			after: `fn f(%p: i32): i32 {
+entry:
+  %3 = gt i32 %p, 0
+  br %3, then1, else2
+then1: ; preds entry
+  jmp endif3
+else2: ; preds entry
+  jmp endif3
+endif3: ; preds then1, else2
+  ret i32 %p
+}
+`,
+		},
+		{
+			name:  "dce removes dead phi cycles and keeps calls",
+			src:   "extern fn putchar(c:i32):i32;\nfn main():i32 {\n  let i:i32 = 0;\n  let u:i32 = 0;\n  while i < 3 {\n    u = u + 1;\n    putchar(65);\n    i = i + 1;\n  }\n  return i;\n}\n",
+			setup: []Pass{Mem2Reg, ConstFold, CopyProp},
+			pass:  DCE,
+			before: `fn main(): i32 {
+entry:
+  jmp loop1
+loop1: ; preds entry, body2
+  %11 = phi i32 [0, entry], [%5, body2]
+  %10 = phi i32 [0, entry], [%8, body2]
+  %3 = lt i32 %10, 3
+  br %3, body2, endloop3
+body2: ; preds loop1
+  %5 = add i32 %11, 1
+  %6 = call i32 putchar(65)
+  %8 = add i32 %10, 1
+  jmp loop1
+endloop3: ; preds loop1
+  ret i32 %10
+}
+`,
+			after: `fn main(): i32 {
+entry:
+  jmp loop1
+loop1: ; preds entry, body2
+  %10 = phi i32 [0, entry], [%8, body2]
+  %3 = lt i32 %10, 3
+  br %3, body2, endloop3
+body2: ; preds loop1
+  %6 = call i32 putchar(65)
+  %8 = add i32 %10, 1
+  jmp loop1
+endloop3: ; preds loop1
+  ret i32 %10
+}
+`,
+		},
+		{
+			name:  "simplifycfg merges straight line blocks",
+			src:   "fn main():i32 {\n  let a:i32 = 1;\n  if a == 1 {\n    a = 2;\n  } else {\n    a = 3;\n  }\n  return a;\n}\n",
+			setup: []Pass{Mem2Reg, ConstFold, CopyProp, DCE},
+			pass:  SimplifyCFG,
+			before: `fn main(): i32 {
+entry:
+  jmp then1
+then1: ; preds entry
+  jmp endif3
+endif3: ; preds then1
+  ret i32 2
+}
+`,
+			after: `fn main(): i32 {
+entry:
+  ret i32 2
+}
+`,
+		},
+		{
+			name:  "simplifycfg keeps loops",
+			src:   "fn main():i32 {\n  let i:i32 = 0;\n  while i < 10 {\n    i = i + 1;\n  }\n  return i;\n}\n",
+			setup: []Pass{Mem2Reg, ConstFold, CopyProp, DCE},
+			pass:  SimplifyCFG,
+			before: `fn main(): i32 {
+entry:
+  jmp loop1
+loop1: ; preds entry, body2
+  %6 = phi i32 [0, entry], [%4, body2]
+  %2 = lt i32 %6, 10
+  br %2, body2, endloop3
+body2: ; preds loop1
+  %4 = add i32 %6, 1
+  jmp loop1
+endloop3: ; preds loop1
+  ret i32 %6
+}
+`,
+		},
+	}
+	for _, test := range tests {
+		fn := lower(t, test.src)
+		Run(fn, test.setup)
+		if got := fn.String(); got != test.before {
+			t.Errorf("%s: before %s got\n%s", test.name, test.pass.Name, got)
+			continue
+		}
+		after := test.after
+		if after == "" {
+			after = test.before
+		}
+		changed := test.pass.Run(fn)
+		if got := fn.String(); got != after {
+			t.Errorf("%s: after %s got\n%s", test.name, test.pass.Name, got)
+		}
+		if changed != (after != test.before) {
+			t.Errorf("%s: %s reports changed %v", test.name, test.pass.Name, changed)
+		}
+	}
+}
+
+// Blocks are listed in reverse postorder with their immediate dominator and
+// dominance frontier, unreachable blocks are left out.
+func TestDominators(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{
+			"fn f(p:i32):i32 {\n  let a:i32 = 0;\n  if p > 0 {\n    a = 1;\n  } else {\n    a = 2;\n  }\n  return a;\n}\n",
+			"entry: entry []\nelse2: entry [endif3]\nthen1: entry [endif3]\nendif3: entry []\n",
+		},
+		{
+			"fn f(n:i32):i32 {\n  let i:i32 = 0;\n  while i < n {\n    if i == 5 {\n      return i;\n    }\n    i = i + 1;\n  }\n  return i;\n}\n",
+			"entry: entry []\nloop1: entry [loop1]\nendloop3: loop1 []\nbody2: loop1 [loop1]\nendif5: body2 [loop1]\nthen4: body2 []\n",
+		},
+	}
+	for _, test := range tests {
+		fn := lower(t, test.src)
+		dt := buildDomTree(fn)
+		df := dt.frontiers()
+		var sb strings.Builder
+		for _, b := range dt.rpo {
+			names := []string{}
+			for _, frontier := range df[b] {
+				names = append(names, frontier.Name)
+			}
+			fmt.Fprintf(&sb, "%s: %s [%s]\n", b.Name, dt.idom[b].Name, strings.Join(names, " "))
+		}
+		if sb.String() != test.want {
+			t.Errorf("got\n%s\nfor\n%s", sb.String(), fn)
+		}
+	}
+}
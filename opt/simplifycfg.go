@@ -0,0 +1,43 @@
+package opt
+
+import "github.com/s0h1s2/ir"
+
+// simplifyCFG merges a block into its predecessor when that edge is the only
+// way in and the only way out.
+func simplifyCFG(fn *ir.Function) bool {
+	changed := removeUnreachable(fn)
+	for merged := true; merged; {
+		merged = false
+		for _, b := range fn.Blocks[1:] {
+			if len(b.Preds) != 1 {
+				continue
+			}
+			pred := b.Preds[0]
+			if pred == b || len(pred.Succs) != 1 {
+				continue
+			}
+			if len(b.Instrs) > 0 && b.Instrs[0].Op == ir.OP_PHI {
+				continue
+			}
+			pred.Instrs = pred.Instrs[:len(pred.Instrs)-1]
+			for _, instr := range b.Instrs {
+				instr.Block = pred
+				pred.Instrs = append(pred.Instrs, instr)
+			}
+			pred.Succs = b.Succs
+			for _, succ := range b.Succs {
+				for i, p := range succ.Preds {
+					if p == b {
+						succ.Preds[i] = pred
+					}
+				}
+			}
+			i := indexOf(fn.Blocks, b)
+			fn.Blocks = append(fn.Blocks[:i], fn.Blocks[i+1:]...)
+			merged = true
+			changed = true
+			break
+		}
+	}
+	return changed
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/s0h1s2/compiler"
+	"github.com/s0h1s2/error"
+	"github.com/s0h1s2/manifest"
+)
+
+// parseSource parses the flags of a subcommand that compiles the file it is
+// given or, without one, the package whose manifest is found from the working
+// directory.
+func parseSource(flags *flag.FlagSet, args []string) (string, *manifest.Manifest, bool) {
+	flags.Parse(args)
+	switch flags.NArg() {
+	case 0:
+		{
+			path, ok := manifest.Find(".")
+			if !ok {
+				fmt.Fprintf(os.Stderr, "No file given and no %s in the current directory or its parents.\n", manifest.FILE_NAME)
+				return "", nil, false
+			}
+			bag := error.New()
+			pkg := manifest.Load(path, bag)
+			if bag.GotErrors() {
+				bag.PrintErrors()
+				return "", nil, false
+			}
+			return pkg.EntryPath(), pkg, true
+		}
+	case 1:
+		return flags.Arg(0), nil, true
+	}
+	flags.Usage()
+	return "", nil, false
+}
+
+// packageSession creates a session that finds imports in the source roots and
+// the dependencies of 'pkg' too, when a package is compiled.
+func packageSession(pkg *manifest.Manifest) *compiler.Session {
+	session := newSession()
+	if pkg != nil {
+		session.Roots = pkg.Roots()
+		session.Packages = pkg.Packages()
+	}
+	return session
+}
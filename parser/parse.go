@@ -6,6 +6,8 @@ May you share freely, never taking more than you give.
 package parser
 
 import (
+	"strings"
+
 	"github.com/s0h1s2/ast"
 	"github.com/s0h1s2/error"
 	"github.com/s0h1s2/token"
@@ -16,8 +18,10 @@ type Parser struct {
 	bag           *error.DiagnosticBag
 	tokenIndex    int
 	inRHS         bool
-	hadError      bool
 	isFlowControl bool
+	reportedAt    int           // index of the token of the last syntax error
+	comments      []token.Token // not attached yet, in source order
+	footer        []*ast.Comment
 }
 
 func (p *Parser) peekToken() *token.Token {
@@ -30,7 +34,7 @@ func (p *Parser) currentToken() *token.Token {
 	if p.tokenIndex < len(p.tokens) {
 		return &p.tokens[p.tokenIndex]
 	}
-	return &p.tokens[len(p.tokens)]
+	return &p.tokens[len(p.tokens)-1]
 }
 func (p *Parser) expectToken(kind token.TokenKind) *token.Token {
 	if p.matchToken(kind) {
@@ -38,8 +42,7 @@ func (p *Parser) expectToken(kind token.TokenKind) *token.Token {
 		p.consumeToken()
 		return token
 	}
-	p.reportHere("Expected '%s' but got '%s'", kind.String(), p.currentToken().Kind.String())
-	p.hadError = true
+	p.fail("Expected '%s' but got '%s'", kind.String(), p.currentToken().Kind.String())
 	return nil
 }
 func (p *Parser) matchToken(kind token.TokenKind) bool {
@@ -58,35 +61,215 @@ func New(tokens []token.Token, bag *error.DiagnosticBag) *Parser {
 		tokens:        tokens,
 		bag:           bag,
 		tokenIndex:    0,
-		hadError:      false,
 		isFlowControl: false,
+		reportedAt:    -1,
+	}
+}
+
+// KeepComments gives the parser the comments of its source. Parse attaches them
+// to the declarations, statements and struct fields they precede or end the
+// line of, the ones after the last declaration are left to Footer.
+func (p *Parser) KeepComments(comments []token.Token) {
+	p.comments = comments
+}
+
+// Footer returns the comments after the last declaration of the source.
+func (p *Parser) Footer() []*ast.Comment {
+	return p.footer
+}
+func (p *Parser) previousToken() *token.Token {
+	if p.tokenIndex >= len(p.tokens) {
+		return &p.tokens[len(p.tokens)-1]
+	}
+	return &p.tokens[p.tokenIndex-1]
+}
+
+// leading takes the comments before the current token.
+func (p *Parser) leading() ast.Comments {
+	c := ast.Comments{}
+	tk := p.currentToken()
+	first := tk.Pos.Line
+	for len(p.comments) > 0 && p.comments[0].Pos.Start < tk.Pos.Start {
+		c.Leading = append(c.Leading, &ast.Comment{Text: p.comments[0].Literal, Pos: p.comments[0].Pos})
+		p.comments = p.comments[1:]
+	}
+	if len(c.Leading) > 0 {
+		first = c.Leading[0].Pos.Line
+	}
+	if p.tokenIndex > 0 {
+		c.Blank = first > p.previousToken().Pos.Line+1
 	}
+	return c
 }
+
+// attach gives 'node' the comments taken before it and the one ending its line.
+// A comment after the current token belongs to whatever that token starts or
+// closes, e.g. the '}' of a block around 'node'.
+func (p *Parser) attach(node ast.Commented, c ast.Comments) {
+	if p.tokenIndex > 0 && len(p.comments) > 0 && p.comments[0].Pos.Line == p.previousToken().Pos.Line && p.comments[0].Pos.Start < p.currentToken().Pos.Start {
+		c.Trailing = &ast.Comment{Text: p.comments[0].Literal, Pos: p.comments[0].Pos}
+		p.comments = p.comments[1:]
+	}
+	*node.GetComments() = c
+}
+
+// span returns the position covering 'from' up to the end of 'to'.
+func span(from error.Position, to error.Position) error.Position {
+	from.End = to.End
+	return from
+}
+
+// reportHere reports an error at the current token, unless one already was.
 func (p *Parser) reportHere(format string, args ...interface{}) {
+	if p.reportedAt == p.tokenIndex {
+		return
+	}
+	p.reportedAt = p.tokenIndex
 	p.bag.ReportError(p.currentToken().Pos, format, args...)
 }
+
+// bailout unwinds the parser from a syntax error to the statement or
+// declaration loop that synchronizes on the tokens after it.
+type bailout struct{}
+
+// fail reports a syntax error at the current token and abandons the construct
+// being parsed.
+func (p *Parser) fail(format string, args ...interface{}) {
+	p.reportHere(format, args...)
+	panic(bailout{})
+}
+
+// guard runs 'parse' and reports whether it failed with a syntax error.
+func (p *Parser) guard(parse func()) (failed bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(bailout); !ok {
+				panic(r)
+			}
+			p.isFlowControl = false
+			failed = true
+		}
+	}()
+	parse()
+	return false
+}
+
+// atDecl reports whether the current token can only start a declaration.
+func (p *Parser) atDecl() bool {
+	switch p.currentToken().Kind {
+	case token.TK_FN, token.TK_STRUCT, token.TK_EXTERN, token.TK_PUB, token.TK_IMPORT:
+		return true
+	}
+	return false
+}
+
+// atStmt reports whether the current token starts a statement other than an
+// expression.
+func (p *Parser) atStmt() bool {
+	switch p.currentToken().Kind {
+	case token.TK_LET, token.TK_RETURN, token.TK_IF, token.TK_WHILE, token.TK_FOR, token.TK_BREAK, token.TK_CONTINUE:
+		return true
+	}
+	return false
+}
+
+// skipStmt skips the rest of a statement starting at token 'start' that failed
+// to parse: up to and including its ';', up to the '}' closing the enclosing
+// block or up to the next statement keyword or declaration. Blocks within it are
+// skipped whole, braces it opened before failing, as in 'S{x: }', are closed.
+func (p *Parser) skipStmt(start int) {
+	open := 0
+	for _, tk := range p.tokens[start:p.tokenIndex] {
+		switch tk.Kind {
+		case token.TK_OPENBRACE:
+			open++
+		case token.TK_CLOSEBRACE:
+			open = max(open-1, 0)
+		}
+	}
+	depth := 0
+	for !p.atEnd() && !p.atDecl() {
+		switch p.currentToken().Kind {
+		case token.TK_SEMICOLON:
+			{
+				if depth == 0 {
+					p.consumeToken()
+					return
+				}
+			}
+		case token.TK_OPENBRACE:
+			depth++
+		case token.TK_CLOSEBRACE:
+			{
+				if depth == 0 && open > 0 {
+					open--
+					break
+				}
+				if depth == 0 {
+					return
+				}
+				depth--
+				if depth == 0 {
+					p.consumeToken()
+					if !p.matchToken(token.TK_ELSE) {
+						return
+					}
+					// The branches of a damaged if go with it.
+					p.consumeToken()
+					if p.matchToken(token.TK_IF) {
+						p.consumeToken()
+					}
+					continue
+				}
+			}
+		default:
+			{
+				if depth == 0 && p.atStmt() {
+					return
+				}
+			}
+		}
+		p.consumeToken()
+	}
+}
+
+// skipDecl skips the rest of a declaration that failed to parse, up to the next
+// one.
+func (p *Parser) skipDecl() {
+	for !p.atEnd() && !p.atDecl() {
+		p.consumeToken()
+	}
+}
+
+// skipped returns the position of the tokens from the one at 'start' up to the
+// current one.
+func (p *Parser) skipped(start int) error.Position {
+	if p.tokenIndex <= start {
+		return p.tokens[start].Pos
+	}
+	return span(p.tokens[start].Pos, p.previousToken().Pos)
+}
 func (p *Parser) parseIdent() ast.Expr {
 	return &ast.ExprIdent{Name: p.currentToken().Literal, Pos: p.currentToken().Pos}
 }
 func (p *Parser) parseInt() ast.Expr {
-	return &ast.ExprInt{Value: p.currentToken().Literal}
+	return &ast.ExprInt{Value: p.currentToken().Literal, Pos: p.currentToken().Pos}
 }
 func (p *Parser) parseBoolean() ast.Expr {
 	val := false
 	if p.currentToken().Kind == token.TK_TRUE {
 		val = true
 	}
-	return &ast.ExprBoolean{Value: val}
+	return &ast.ExprBoolean{Value: val, Pos: p.currentToken().Pos}
 }
 func (p *Parser) parseCompound(typ ast.TypeSpec) ast.Expr {
 	tk := p.expectToken(token.TK_OPENBRACE)
 	fields := make([]ast.CompoundField, 0, 4)
 	for !p.matchToken(token.TK_CLOSEBRACE) {
-		name := p.expectToken(token.TK_IDENT)
-		if name == nil {
-			p.reportHere("Expected field name in compound initialization")
-			return nil
+		if !p.matchToken(token.TK_IDENT) {
+			p.fail("Expected field name in compound initialization but got '%s'", p.currentToken().Kind.String())
 		}
+		name := p.expectToken(token.TK_IDENT)
 		p.expectToken(token.TK_COLON)
 		init := p.parseExpression()
 		fields = append(fields, ast.CompoundField{Name: name.Literal, Init: init, Pos: name.Pos})
@@ -98,16 +281,30 @@ func (p *Parser) parseCompound(typ ast.TypeSpec) ast.Expr {
 	p.expectToken(token.TK_CLOSEBRACE)
 	return &ast.ExprCompound{Type: typ, Fields: fields, Pos: tk.Pos}
 }
+
+// isQualifiedCompound reports whether the tokens ahead are '.Name{' following a
+// module name, as in 'mem.Buffer{...}'.
+func (p *Parser) isQualifiedCompound() bool {
+	if p.isFlowControl || !p.matchToken(token.TK_DOT) || p.peekToken().Kind != token.TK_IDENT {
+		return false
+	}
+	return p.tokenIndex+2 < len(p.tokens) && p.tokens[p.tokenIndex+2].Kind == token.TK_OPENBRACE
+}
 func (p *Parser) parsePrimary() ast.Expr {
 	switch p.currentToken().Kind {
 	case token.TK_IDENT:
 		{
 			ident := p.parseIdent()
 			p.consumeToken()
+			typeName := ident.(*ast.ExprIdent)
 			if p.matchToken(token.TK_OPENBRACE) && !p.isFlowControl {
-				typeName := ident.(*ast.ExprIdent)
 				return p.parseCompound(&ast.TypeName{Name: typeName.Name, Pos: typeName.Pos})
 			}
+			if p.isQualifiedCompound() {
+				p.consumeToken()
+				name := p.expectToken(token.TK_IDENT)
+				return p.parseCompound(&ast.TypeName{Module: typeName.Name, Name: name.Literal, Pos: span(typeName.Pos, name.Pos)})
+			}
 			return ident
 		}
 	case token.TK_INTEGER:
@@ -138,12 +335,8 @@ func (p *Parser) parsePrimary() ast.Expr {
 			p.expectToken(token.TK_CLOSEPARAN)
 			return expr
 		}
-	default:
-		{
-			p.reportHere("Unexpected token '%s' in expression", p.currentToken().Kind.String())
-		}
 	}
-	p.consumeToken()
+	p.fail("Unexpected token '%s' in expression", p.currentToken().Kind.String())
 	return nil
 }
 func (p *Parser) parseBase() ast.Expr {
@@ -165,12 +358,19 @@ func (p *Parser) parseBase() ast.Expr {
 				p.consumeToken()
 			}
 			p.expectToken(token.TK_CLOSEPARAN)
-			name, ok := expr.(*ast.ExprIdent)
-			if !ok {
-				p.reportHere("Function call must be a name")
-				return nil
+			call := &ast.ExprCall{Pos: paranPos.Pos, Args: args}
+			switch callee := expr.(type) {
+			case *ast.ExprIdent:
+				call.Name, call.NamePos = callee.Name, callee.Pos
+			case *ast.ExprField:
+				if module, ok := callee.Expr.(*ast.ExprIdent); ok {
+					call.Module, call.Name, call.NamePos = module.Name, callee.Name, span(module.Pos, callee.Pos)
+				}
+			}
+			if call.Name == "" {
+				p.fail("Function call must be a name")
 			}
-			expr = &ast.ExprCall{Pos: paranPos.Pos, Args: args, Name: name.Name}
+			expr = call
 		}
 	}
 	return expr
@@ -233,19 +433,36 @@ func (p *Parser) parseVariableStmt() ast.Stmt {
 	var init ast.Expr
 	if p.matchToken(token.TK_ASSIGN) {
 		p.consumeToken()
-		init = p.parseExpression()
+		init = p.parseTail()
+	} else {
+		p.expectToken(token.TK_SEMICOLON)
 	}
-	p.expectToken(token.TK_SEMICOLON)
 	return &ast.StmtLet{Name: name.Literal, Type: typeSpec, Init: init, Pos: name.Pos}
 }
 func (p *Parser) parseReturn() ast.Stmt {
 	ret := p.expectToken(token.TK_RETURN)
-	var expr ast.Expr
-	if !p.matchToken(token.TK_SEMICOLON) {
+	if p.matchToken(token.TK_SEMICOLON) {
+		p.consumeToken()
+		return &ast.StmtReturn{Pos: ret.Pos}
+	}
+	return &ast.StmtReturn{Pos: ret.Pos, Result: p.parseTail()}
+}
+
+// parseTail parses the expression and ';' ending a let or return statement. An
+// expression that fails to parse is skipped with the rest of the statement and
+// left as a BadExpr, so the statement still declares its variable.
+func (p *Parser) parseTail() (expr ast.Expr) {
+	start := p.tokenIndex
+	if p.guard(func() {
 		expr = p.parseExpression()
+		p.expectToken(token.TK_SEMICOLON)
+	}) {
+		p.skipStmt(start)
+		if expr == nil {
+			expr = &ast.BadExpr{Pos: p.skipped(start)}
+		}
 	}
-	p.expectToken(token.TK_SEMICOLON)
-	return &ast.StmtReturn{Pos: ret.Pos, Result: expr}
+	return expr
 }
 func (p *Parser) parseIf() ast.Stmt {
 	p.expectToken(token.TK_IF)
@@ -254,51 +471,166 @@ func (p *Parser) parseIf() ast.Stmt {
 	cond := p.parseExpression()
 	p.isFlowControl = false
 	then := p.parseBlock()
-
+	var els ast.Stmt
+	if p.matchToken(token.TK_ELSE) {
+		p.consumeToken()
+		if p.matchToken(token.TK_IF) {
+			els = p.parseIf()
+		} else {
+			els = p.parseBlock()
+		}
+	}
 	return &ast.StmtIf{
 		Cond: cond,
 		Then: then,
+		Else: els,
 		Pos:  pos,
 	}
 }
-func (p *Parser) parseBlock() *ast.StmtBlock {
-	p.expectToken(token.TK_OPENBRACE)
-	stmts := []ast.Stmt{}
-	for !p.atEnd() && p.currentToken().Kind != token.TK_CLOSEBRACE {
-		switch p.currentToken().Kind {
-		case token.TK_LET:
-			{
-				p.consumeToken()
-				stmts = append(stmts, p.parseVariableStmt())
-			}
-		case token.TK_RETURN:
-			{
-				stmts = append(stmts, p.parseReturn())
-			}
-		case token.TK_OPENBRACE:
-			{
-				stmts = append(stmts, p.parseBlock())
-			}
-		case token.TK_IF:
-			{
-				stmts = append(stmts, p.parseIf())
-			}
-		default:
-			{
-				stmts = append(stmts, &ast.StmtExpr{Expr: p.parseExpression()})
-				p.expectToken(token.TK_SEMICOLON)
+func (p *Parser) parseWhile() ast.Stmt {
+	tk := p.expectToken(token.TK_WHILE)
+	p.isFlowControl = true
+	cond := p.parseExpression()
+	p.isFlowControl = false
+	body := p.parseBlock()
+	return &ast.StmtWhile{Cond: cond, Body: body, Pos: tk.Pos}
+}
+func (p *Parser) parseFor() ast.Stmt {
+	tk := p.expectToken(token.TK_FOR)
+	var init ast.Stmt
+	switch p.currentToken().Kind {
+	case token.TK_SEMICOLON:
+		p.consumeToken()
+	case token.TK_LET:
+		{
+			start := p.tokenIndex
+			p.consumeToken()
+			init = p.parseVariableStmt()
+			if p.reportedAt >= start {
+				// The let recovered by skipping into the rest of the header, which
+				// can't be trusted anymore. The body is still parsed.
+				for !p.atEnd() && !p.atDecl() && !p.matchToken(token.TK_OPENBRACE) {
+					p.consumeToken()
+				}
+				return &ast.StmtFor{Init: init, Body: p.parseBlock(), Pos: tk.Pos}
 			}
 		}
+	default:
+		{
+			start := p.currentToken().Pos
+			init = &ast.StmtExpr{Expr: p.parseExpression(), Pos: start}
+			p.expectToken(token.TK_SEMICOLON)
+		}
+	}
+	var cond, post ast.Expr
+	if !p.matchToken(token.TK_SEMICOLON) {
+		cond = p.parseExpression()
+	}
+	p.expectToken(token.TK_SEMICOLON)
+	if !p.matchToken(token.TK_OPENBRACE) {
+		p.isFlowControl = true
+		post = p.parseExpression()
+		p.isFlowControl = false
+	}
+	body := p.parseBlock()
+	return &ast.StmtFor{Init: init, Cond: cond, Post: post, Body: body, Pos: tk.Pos}
+}
+func (p *Parser) parseStmt() ast.Stmt {
+	switch p.currentToken().Kind {
+	case token.TK_LET:
+		{
+			p.consumeToken()
+			return p.parseVariableStmt()
+		}
+	case token.TK_RETURN:
+		{
+			return p.parseReturn()
+		}
+	case token.TK_OPENBRACE:
+		{
+			return p.parseBlock()
+		}
+	case token.TK_IF:
+		{
+			return p.parseIf()
+		}
+	case token.TK_WHILE:
+		{
+			return p.parseWhile()
+		}
+	case token.TK_FOR:
+		{
+			return p.parseFor()
+		}
+	case token.TK_BREAK:
+		{
+			tk := p.expectToken(token.TK_BREAK)
+			p.expectToken(token.TK_SEMICOLON)
+			return &ast.StmtBreak{Pos: tk.Pos}
+		}
+	case token.TK_CONTINUE:
+		{
+			tk := p.expectToken(token.TK_CONTINUE)
+			p.expectToken(token.TK_SEMICOLON)
+			return &ast.StmtContinue{Pos: tk.Pos}
+		}
+	}
+	start := p.currentToken().Pos
+	expr := p.parseExpression()
+	p.expectToken(token.TK_SEMICOLON)
+	return &ast.StmtExpr{Expr: expr, Pos: start}
+}
+
+// parseStmtOrBad parses a statement, one that fails to parse is skipped and left
+// as a BadStmt.
+func (p *Parser) parseStmtOrBad() (stmt ast.Stmt) {
+	start := p.tokenIndex
+	if p.guard(func() { stmt = p.parseStmt() }) {
+		if p.tokenIndex == start {
+			p.consumeToken()
+		}
+		p.skipStmt(start)
+		stmt = &ast.BadStmt{Pos: p.skipped(start)}
+	}
+	return stmt
+}
+
+// closeBrace expects the '}' closing a block or struct. One missing before the
+// next declaration or the end of the file is reported and taken as read, so the
+// declaration around it survives.
+func (p *Parser) closeBrace() {
+	if p.atEnd() || p.atDecl() {
+		p.reportHere("Expected '}' but got '%s'", p.currentToken().Kind.String())
+		return
 	}
 	p.expectToken(token.TK_CLOSEBRACE)
-	return &ast.StmtBlock{Block: stmts}
+}
+func (p *Parser) parseBlock() *ast.StmtBlock {
+	pos := p.currentToken().Pos
+	p.expectToken(token.TK_OPENBRACE)
+	stmts := []ast.Stmt{}
+	for !p.atEnd() && !p.atDecl() && !p.matchToken(token.TK_CLOSEBRACE) {
+		c := p.leading()
+		stmt := p.parseStmtOrBad()
+		p.attach(stmt.(ast.Commented), c)
+		stmts = append(stmts, stmt)
+	}
+	footer := p.leading().Leading
+	end := p.currentToken().Pos
+	p.closeBrace()
+	return &ast.StmtBlock{Block: stmts, Pos: pos, End: end, Footer: footer}
 }
 func (p *Parser) parseBaseType() ast.TypeSpec {
 	if p.matchToken(token.TK_IDENT) || p.matchToken(token.TK_STRING) {
 		name := p.expectToken(p.currentToken().Kind)
+		if name.Kind == token.TK_IDENT && p.matchToken(token.TK_DOT) {
+			p.consumeToken()
+			member := p.expectToken(token.TK_IDENT)
+			return &ast.TypeName{Module: name.Literal, Name: member.Literal, Pos: span(name.Pos, member.Pos)}
+		}
 		return &ast.TypeName{Name: name.Literal, Pos: name.Pos}
 	}
-	p.reportHere("Expected type but got '%s'", p.currentToken().Kind.String())
+	p.fail("Expected type but got '%s'", p.currentToken().Kind.String())
 	return nil
 }
 func (p *Parser) parseType() ast.TypeSpec {
@@ -314,46 +646,81 @@ func (p *Parser) parseType() ast.TypeSpec {
 }
 func (p *Parser) parseDeclarations() []ast.Decl {
 	decls := []ast.Decl{}
-	for p.currentToken().Kind != token.TK_EOF {
-		switch p.currentToken().Kind {
-		case token.TK_FN:
-			{
-				p.consumeToken()
-				decls = append(decls, p.parseFunction())
-			}
-		case token.TK_STRUCT:
-			{
-				p.consumeToken()
-				decls = append(decls, p.parseStruct())
-			}
-		case token.TK_EXTERN:
-			{
-				p.consumeToken()
-				decls = append(decls, p.parseExternal())
-			}
-		default:
-			{
-				p.reportHere("Unable to parse '%s' declaration", p.currentToken().Kind.String())
-				p.consumeToken()
-			}
+	imports := true
+	for !p.atEnd() {
+		if !p.isImport() {
+			imports = false
 		}
+		c := p.leading()
+		decl := p.parseDeclOrBad(imports)
+		p.attach(decl.(ast.Commented), c)
+		decls = append(decls, decl)
 	}
+	p.footer = p.leading().Leading
 	return decls
 }
-func (p *Parser) hasError() bool {
-	if p.hadError {
-		p.hadError = false
-		return true
+
+// isImport reports whether an import declaration, public or not, comes next.
+func (p *Parser) isImport() bool {
+	return p.matchToken(token.TK_IMPORT) || p.matchToken(token.TK_PUB) && p.peekToken().Kind == token.TK_IMPORT
+}
+
+// parseDeclOrBad parses a top level declaration, one that fails to parse is
+// skipped and left as a BadDecl.
+func (p *Parser) parseDeclOrBad(imports bool) (decl ast.Decl) {
+	start := p.tokenIndex
+	if p.guard(func() { decl = p.parseDecl(imports) }) {
+		if p.tokenIndex == start {
+			p.consumeToken()
+		}
+		p.skipDecl()
+		decl = &ast.BadDecl{Pos: p.skipped(start)}
 	}
-	return false
+	return decl
+}
+
+// parseDecl parses one top level declaration, 'imports' tells whether imports
+// may still appear.
+func (p *Parser) parseDecl(imports bool) ast.Decl {
+	pub := p.matchToken(token.TK_PUB)
+	if pub {
+		p.consumeToken()
+	}
+	switch p.currentToken().Kind {
+	case token.TK_IMPORT:
+		{
+			if pub {
+				p.reportHere("Imports can't be public")
+			}
+			if !imports {
+				p.reportHere("Imports must come before the other declarations")
+			}
+			p.consumeToken()
+			return p.parseImport()
+		}
+	case token.TK_FN:
+		{
+			p.consumeToken()
+			return p.parseFunction(pub)
+		}
+	case token.TK_STRUCT:
+		{
+			p.consumeToken()
+			return p.parseStruct(pub)
+		}
+	case token.TK_EXTERN:
+		{
+			p.consumeToken()
+			return p.parseExternal(pub)
+		}
+	}
+	p.fail("Unable to parse '%s' declaration", p.currentToken().Kind.String())
+	return nil
 }
 func (p *Parser) parseField() *ast.Field {
 	name := p.expectToken(token.TK_IDENT)
 	p.expectToken(token.TK_COLON)
 	typ := p.parseType()
-	if p.hasError() {
-		return nil
-	}
 	return &ast.Field{Name: name.Literal, Type: typ, Pos: name.Pos}
 }
 func (p *Parser) parseFunctionHeader() (*token.Token, []ast.Field, ast.TypeSpec) {
@@ -365,35 +732,75 @@ func (p *Parser) parseFunctionHeader() (*token.Token, []ast.Field, ast.TypeSpec)
 	typeResult := p.parseType()
 	return name, params, typeResult
 }
-func (p *Parser) parseExternal() ast.Decl {
+
+// parseImport parses 'import "path/to/module";', the module is named after the
+// last element of its path, or 'import "path/to/module" as name;'.
+func (p *Parser) parseImport() ast.Decl {
+	path := p.expectToken(token.TK_STRING)
+	if p.matchToken(token.TK_AS) {
+		p.consumeToken()
+		name := p.expectToken(token.TK_IDENT)
+		p.expectToken(token.TK_SEMICOLON)
+		return &ast.DeclImport{Path: path.Literal, Name: name.Literal, Alias: true, Pos: path.Pos}
+	}
+	p.expectToken(token.TK_SEMICOLON)
+	name := path.Literal[strings.LastIndex(path.Literal, "/")+1:]
+	if !isIdentifier(name) {
+		p.bag.ReportError(path.Pos, "Module name '%s' of '%s' must be an identifier, rename it with 'as'", name, path.Literal)
+		return &ast.BadDecl{Pos: path.Pos}
+	}
+	return &ast.DeclImport{Path: path.Literal, Name: name, Pos: path.Pos}
+}
+func isIdentifier(name string) bool {
+	for i, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 0 && c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return name != ""
+}
+func (p *Parser) parseExternal(pub bool) ast.Decl {
 	if p.matchToken(token.TK_FN) {
 		p.consumeToken()
 		name, params, typee := p.parseFunctionHeader()
 		p.expectToken(token.TK_SEMICOLON)
-		return &ast.DeclExternalFunction{Parameters: params, Name: name.Literal, ReturnType: typee, Pos: name.Pos}
+		return &ast.DeclExternalFunction{Parameters: params, Name: name.Literal, ReturnType: typee, Pos: name.Pos, Pub: pub}
 	}
-	p.reportHere("Expected 'fn' or 'let' after 'external' keyword but got '%s'", p.currentToken().Kind.String())
+	p.fail("Expected 'fn' or 'let' after 'external' keyword but got '%s'", p.currentToken().Kind.String())
 	return nil
 }
-func (p *Parser) parseStruct() ast.Decl {
+func (p *Parser) parseStruct(pub bool) ast.Decl {
 	name := p.expectToken(token.TK_IDENT)
 	p.expectToken(token.TK_OPENBRACE)
 	fields := make([]*ast.Field, 0, 4)
-	for !p.atEnd() && !p.matchToken(token.TK_CLOSEBRACE) {
-		fields = append(fields, p.parseField())
-		if p.expectToken(token.TK_SEMICOLON) == nil {
-			return nil
+	for !p.atEnd() && !p.atDecl() && !p.matchToken(token.TK_CLOSEBRACE) {
+		c := p.leading()
+		start := p.tokenIndex
+		var field *ast.Field
+		if p.guard(func() {
+			field = p.parseField()
+			p.expectToken(token.TK_SEMICOLON)
+		}) {
+			// The damaged field is left out.
+			if p.tokenIndex == start {
+				p.consumeToken()
+			}
+			p.skipStmt(start)
+			continue
 		}
+		p.attach(field, c)
+		fields = append(fields, field)
 	}
-	p.expectToken(token.TK_CLOSEBRACE)
-
-	if p.hasError() {
-		return nil
-	}
+	footer := p.leading().Leading
+	end := p.currentToken().Pos
+	p.closeBrace()
 	return &ast.DeclStruct{
 		Name:   name.Literal,
 		Fields: fields,
 		Pos:    name.Pos,
+		Pub:    pub,
+		End:    end,
+		Footer: footer,
 	}
 }
 
@@ -401,12 +808,9 @@ func (p *Parser) parseFunctionParameters() []ast.Field {
 	params := make([]ast.Field, 0)
 	for !p.atEnd() && !p.matchToken(token.TK_CLOSEPARAN) {
 		name := p.expectToken(token.TK_IDENT)
-		if name == nil {
-			return nil
-		}
 		p.expectToken(token.TK_COLON)
 		typeSpec := p.parseType()
-		params = append(params, ast.Field{Name: name.Literal, Type: typeSpec})
+		params = append(params, ast.Field{Name: name.Literal, Type: typeSpec, Pos: name.Pos})
 		if !p.matchToken(token.TK_COMMA) {
 			break
 		}
@@ -414,15 +818,46 @@ func (p *Parser) parseFunctionParameters() []ast.Field {
 	}
 	return params
 }
-func (p *Parser) parseFunction() *ast.DeclFunction {
+func (p *Parser) parseFunction(pub bool) *ast.DeclFunction {
 	name, params, typeResult := p.parseFunctionHeader()
 	body := p.parseBlock()
-	if p.hasError() {
-		return nil
-	}
-	return &ast.DeclFunction{Name: name.Literal, RetType: typeResult, Body: body, Pos: name.Pos, Parameters: params, End: p.currentToken().Pos}
+	return &ast.DeclFunction{Name: name.Literal, RetType: typeResult, Body: body, Pos: name.Pos, Parameters: params, End: body.End, Pub: pub}
 }
 func (p *Parser) Parse() []ast.Decl {
-	println("----PARSER----")
 	return p.parseDeclarations()
 }
+
+// The following entry points parse input that isn't a whole file, as the REPL
+// reads it.
+
+// ParseDecl parses the next top level declaration.
+func (p *Parser) ParseDecl() ast.Decl {
+	return p.parseDeclOrBad(true)
+}
+
+// ParseStmt parses the next statement.
+func (p *Parser) ParseStmt() ast.Stmt {
+	return p.parseStmtOrBad()
+}
+
+// ParseExpr parses input that is a single expression.
+func (p *Parser) ParseExpr() (expr ast.Expr) {
+	start := p.tokenIndex
+	if p.guard(func() {
+		expr = p.parseExpression()
+		if !p.atEnd() {
+			p.fail("Expected end of input but got '%s'", p.currentToken().Kind.String())
+		}
+	}) {
+		for !p.atEnd() {
+			p.consumeToken()
+		}
+		expr = &ast.BadExpr{Pos: p.skipped(start)}
+	}
+	return expr
+}
+
+// AtEnd reports whether the parser consumed all of its input.
+func (p *Parser) AtEnd() bool {
+	return p.atEnd()
+}
@@ -0,0 +1,29 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/s0h1s2/error"
+	"github.com/s0h1s2/lexer"
+)
+
+// One typo is reported once, the statements around it still parse.
+func TestRecoverOnce(t *testing.T) {
+	tests := []string{
+		"fn main():i32 {\n  let s:S = S{x: };\n  return 0;\n}\n",
+		"fn main():i32 {\n  let s:S = S{x: 1;\n  return 0;\n}\n",
+		"fn main():i32 {\n  let s:S = S{x 1};\n  return 0;\n}\n",
+		"fn main():i32 {\n  f(S{x: });\n  return 0;\n}\n",
+		"fn main():i32 {\n  let x:i32 = ;\n  return 0;\n}\n",
+		"fn main():i32 {\n  for let i:i32 = 0 i < 3; i = i + 1 {\n  }\n  return 0;\n}\n",
+	}
+	for _, src := range tests {
+		bag := error.New()
+		lex := lexer.New(bag)
+		p := New(lex.GetTokens([]byte(src)), bag)
+		p.Parse()
+		if errors := bag.Errors(); len(errors) != 1 {
+			t.Errorf("%q: want one error, got %v", src, errors)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package repl
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+
+	"github.com/s0h1s2/ast"
+	"github.com/s0h1s2/checker"
+	"github.com/s0h1s2/error"
+	"github.com/s0h1s2/interp"
+	"github.com/s0h1s2/lexer"
+	"github.com/s0h1s2/parser"
+	"github.com/s0h1s2/resolver"
+	"github.com/s0h1s2/token"
+	"github.com/s0h1s2/types"
+)
+
+const (
+	PROMPT          = ">>> "
+	PROMPT_CONTINUE = "... "
+)
+
+type session struct {
+	names  *resolver.Incremental
+	interp *interp.Interpreter
+	out    io.Writer
+}
+
+// Run reads declarations, statements and expressions from 'in' until it ends,
+// printing the value and type of every expression to 'out', and returns the
+// exit code. Input continues on the next line while braces or parentheses are
+// open. Prompts are written when 'prompt' is set.
+func Run(in io.Reader, out io.Writer, prompt bool) int {
+	names := resolver.NewIncremental()
+	s := &session{names: names, interp: interp.New(nil, names.Table(), out), out: out}
+	scanner := bufio.NewScanner(in)
+	src := []byte{}
+	for {
+		if prompt {
+			if len(src) == 0 {
+				fmt.Fprint(out, PROMPT)
+			} else {
+				fmt.Fprint(out, PROMPT_CONTINUE)
+			}
+		}
+		if !scanner.Scan() {
+			break
+		}
+		src = append(src, scanner.Bytes()...)
+		src = append(src, '\n')
+		bag := error.New()
+		tokens := lexer.New(bag).GetTokens(src)
+		if !bag.GotErrors() && depth(tokens) > 0 {
+			continue
+		}
+		code, exited := s.eval(tokens, bag)
+		if exited {
+			return code
+		}
+		src = src[:0]
+	}
+	if prompt {
+		fmt.Fprintln(out)
+	}
+	return 0
+}
+
+// depth counts the braces and parentheses left open.
+func depth(tokens []token.Token) int {
+	open := 0
+	for _, tk := range tokens {
+		switch tk.Kind {
+		case token.TK_OPENBRACE, token.TK_OPENPARAN:
+			open++
+		case token.TK_CLOSEBRACE, token.TK_CLOSEPARAN:
+			open--
+		}
+	}
+	return open
+}
+
+func (s *session) report(bag *error.DiagnosticBag) {
+	for _, e := range bag.Errors() {
+		fmt.Fprintln(s.out, e.Error())
+	}
+}
+
+// eval compiles and runs one input. Declarations are kept for the inputs that
+// follow, nothing of an input that fails to compile is.
+func (s *session) eval(tokens []token.Token, bag *error.DiagnosticBag) (code int, exited bool) {
+	s.names.Begin()
+	defer func() {
+		// A compiler bug hit by one input must not end the session.
+		if r := recover(); r != nil {
+			s.names.Discard()
+			fmt.Fprintf(s.out, "Internal compiler error: %v\n", r)
+			code, exited = 0, false
+		}
+	}()
+	if bag.GotErrors() {
+		s.report(bag)
+		return 0, false
+	}
+	if len(tokens) == 0 || tokens[0].Kind == token.TK_EOF {
+		return 0, false
+	}
+	p := parser.New(tokens, bag)
+	switch tokens[0].Kind {
+	case token.TK_FN, token.TK_STRUCT, token.TK_EXTERN, token.TK_PUB, token.TK_IMPORT:
+		{
+			s.declare(p, bag)
+			return 0, false
+		}
+	}
+	var stmts []ast.Stmt
+	if last := tokens[len(tokens)-2].Kind; last == token.TK_SEMICOLON || last == token.TK_CLOSEBRACE {
+		for !p.AtEnd() {
+			stmts = append(stmts, p.ParseStmt())
+		}
+	} else {
+		expr := p.ParseExpr()
+		stmts = append(stmts, &ast.StmtExpr{Expr: expr, Pos: tokens[0].Pos})
+	}
+	if bag.GotErrors() {
+		s.report(bag)
+		return 0, false
+	}
+	return s.exec(stmts, bag)
+}
+
+func (s *session) declare(p *parser.Parser, bag *error.DiagnosticBag) {
+	var decls []ast.Decl
+	for !p.AtEnd() {
+		decl := p.ParseDecl()
+		if node, ok := decl.(*ast.DeclImport); ok {
+			bag.ReportError(node.Pos, "Imports aren't supported in the REPL")
+		}
+		decls = append(decls, decl)
+	}
+	var resolved []resolver.DeclNode
+	if !bag.GotErrors() {
+		for _, decl := range decls {
+			if node := s.names.Decl(decl, bag); node != nil {
+				resolved = append(resolved, node)
+			}
+		}
+	}
+	if !bag.GotErrors() {
+		checker.Check(resolved, s.names.Table(), bag)
+	}
+	if bag.GotErrors() {
+		s.names.Discard()
+		s.report(bag)
+		return
+	}
+	s.interp.Declare(resolved)
+}
+
+// literal reports whether 'expr' is integer literals and arithmetic on them,
+// which have no operand to take a type from.
+func literal(expr resolver.ExprNode) bool {
+	switch node := expr.(type) {
+	case *resolver.ExprInt:
+		return true
+	case *resolver.ExprBinary:
+		return !resolver.IsCompare(node.Op) && literal(node.Left) && literal(node.Right)
+	}
+	return false
+}
+
+func (s *session) exec(stmts []ast.Stmt, bag *error.DiagnosticBag) (int, bool) {
+	table := s.names.Table()
+	resolved := make([]resolver.StmtNode, 0, len(stmts))
+	for _, stmt := range stmts {
+		resolved = append(resolved, s.names.Stmt(stmt, bag))
+	}
+	if !bag.GotErrors() {
+		for _, stmt := range resolved {
+			if node, ok := stmt.(*resolver.StmtExpr); ok {
+				// Integer literals on their own are as wide as they can be,
+				// other expressions take the type of their operands.
+				var expected *types.Type
+				if literal(node.Expr) {
+					expected = table.Symbols.GetObj("i64").Type
+				}
+				checker.CheckExpr(node.Expr, expected, table, bag)
+			} else if stmt != nil {
+				checker.CheckStmt(stmt, table, bag)
+			}
+		}
+	}
+	if bag.GotErrors() {
+		s.names.Discard()
+		s.report(bag)
+		return 0, false
+	}
+	for _, stmt := range resolved {
+		if stmt == nil {
+			continue
+		}
+		value, err := s.interp.Exec(stmt)
+		if code, ok := interp.ExitStatus(err); ok {
+			return code, true
+		}
+		if err != nil {
+			fmt.Fprintf(s.out, "Runtime error: %s\n", err)
+			return 0, false
+		}
+		if node, ok := stmt.(*resolver.StmtExpr); ok {
+			if typ := table.TypeOf(node.Expr); typ.Kind != types.TYPE_VOID {
+				fmt.Fprintf(s.out, "%s: %s\n", interp.Format(value), typ.TypeName)
+			}
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,41 @@
+package repl
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// Expressions are typed like in a compiled program, only literals on their
+// own default to i64.
+func TestExpressionTypes(t *testing.T) {
+	input := `let b:i8 = 100;
+b + b;
+let a:i32 = 3;
+a + 4;
+5;
+100 + 100;
+a = 9;
+`
+	var out bytes.Buffer
+	Run(strings.NewReader(input), &out, false)
+	want := "-56: i8\n7: i32\n5: i64\n200: i64\n9: i32\n"
+	if out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+}
+
+// A compiler bug hit by one input is reported and the session goes on.
+func TestInternalError(t *testing.T) {
+	input := `let a:i32 = 3;
+let p:*i32;
+let pp:**i32 = &p;
+**pp;
+a;
+`
+	var out bytes.Buffer
+	Run(strings.NewReader(input), &out, false)
+	if !strings.HasPrefix(out.String(), "Internal compiler error:") || !strings.HasSuffix(out.String(), "\n3: i32\n") {
+		t.Errorf("got %q", out.String())
+	}
+}
@@ -17,22 +17,40 @@ const (
 	DIV
 	AND
 	OR
+	EQ
+	NE
+	LT
+	LE
+	GT
+	GE
 )
 
 const (
 	DEREF UnaryOperator = iota
 	REFER
 	MINUS
+	NOT
 )
 
 // TODO: may refactor this.
 var KindToUnary = map[token.TokenKind]UnaryOperator{
 	token.TK_STAR: DEREF,
 	token.TK_AND:  REFER,
+	token.TK_BANG: NOT,
 }
 var KindToBinary = map[token.TokenKind]BinaryOperator{
-	token.TK_PLUS: ADD,
-	token.TK_STAR: MUL,
+	token.TK_PLUS:         ADD,
+	token.TK_STAR:         MUL,
+	token.TK_EQUAL:        EQ,
+	token.TK_NOTEQUAL:     NE,
+	token.TK_LESSTHAN:     LT,
+	token.TK_LESSEQUAL:    LE,
+	token.TK_GREATERTHAN:  GT,
+	token.TK_GREATEREQUAL: GE,
+}
+
+func IsCompare(op BinaryOperator) bool {
+	return op >= EQ && op <= GE
 }
 
 type Node interface {
@@ -55,26 +73,34 @@ type ExprNode interface {
 }
 type DeclFunction struct {
 	Name       string
+	Link       string // name in generated code
+	Public     bool
 	ReturnType *types.Type
+	Params     []Field
 	StackSize  int
 	Scope      *scope.Scope
 	Body       StmtNode // StmtBlock
+	Pos        error.Position
+	End        error.Position
 }
 type DeclExternalFunction struct {
 	Name       string
 	ReturnType *types.Type
+	Params     []Field
 	Scope      *scope.Scope // store parameters
 	Pos        error.Position
 }
 type Field struct {
 	Name string
 	Type *types.Type
+	Pos  error.Position
 }
 type DeclStruct struct {
 	Name   string
+	Type   *types.Type
 	Pos    error.Position
 	Scope  *scope.Scope
-	Fields []Field // TODO: fields need pos
+	Fields []Field
 }
 
 type StmtLet struct {
@@ -87,28 +113,64 @@ type StmtLet struct {
 type StmtExpr struct {
 	Expr  ExprNode
 	Scope *scope.Scope
+	Pos   error.Position
 }
 
 type StmtBlock struct {
 	Scope *scope.Scope
 	Body  []StmtNode
+	Pos   error.Position
+}
+type StmtIf struct {
+	Cond  ExprNode
+	Then  StmtNode // StmtBlock
+	Else  StmtNode // nil, StmtBlock or StmtIf of an 'else if'
+	Scope *scope.Scope
+	Pos   error.Position
+}
+type StmtWhile struct {
+	Cond  ExprNode
+	Body  StmtNode // StmtBlock
+	Scope *scope.Scope
+	Pos   error.Position
+}
+type StmtFor struct {
+	Init  StmtNode // nil, StmtLet or StmtExpr
+	Cond  ExprNode // nil loops until a break or return
+	Post  ExprNode
+	Body  StmtNode     // StmtBlock
+	Scope *scope.Scope // holds the variable of Init
+	Pos   error.Position
+}
+type StmtBreak struct {
+	Scope *scope.Scope
+	Pos   error.Position
+}
+type StmtContinue struct {
+	Scope *scope.Scope
+	Pos   error.Position
 }
 type StmtReturn struct {
 	Scope  *scope.Scope
 	Result ExprNode
+	Pos    error.Position
 }
 type ExprAssign struct {
 	Left  ExprNode
 	Right ExprNode
+	Pos   error.Position
 }
 type ExprBinary struct {
 	Left  ExprNode
 	Right ExprNode
 	Op    BinaryOperator
+	Type  *types.Type // set by checker
+	Pos   error.Position
 }
 
 type ExprField struct {
 	Name string
+	Expr ExprNode
 	Type *types.Type
 	Pos  error.Position
 }
@@ -125,6 +187,7 @@ type ExprCompoundField struct {
 }
 type ExprCompound struct {
 	Type   *types.Type
+	Obj    *scope.Object // the struct
 	Fields []ExprCompoundField
 	Pos    error.Position
 }
@@ -135,23 +198,31 @@ type ExprArg struct {
 }
 type ExprCall struct {
 	Name string
+	Obj  *scope.Object // the function called
 	Args []*ExprArg
 	Pos  error.Position
+	Type *types.Type // set by checker
 }
 
 type ExprInt struct {
 	Value string
+	Type  *types.Type // set by checker
+	Pos   error.Position
 }
 type ExprBool struct {
 	Value bool
+	Pos   error.Position
 }
 type ExprString struct {
 	Value string
+	Pos   error.Position
 }
 
 type ExprIdentifier struct {
 	Name string
 	Type *types.Type
+	Obj  *scope.Object
+	Pos  error.Position
 }
 
 func (d *DeclFunction) declNode() {}
@@ -159,7 +230,7 @@ func (d *DeclFunction) GetType() *types.Type {
 	return d.ReturnType
 }
 func (d *DeclFunction) GetPos() error.Position {
-	return error.Position{}
+	return d.Pos
 }
 func (d *DeclFunction) GetScope() *scope.Scope {
 	return d.Scope
@@ -167,10 +238,10 @@ func (d *DeclFunction) GetScope() *scope.Scope {
 
 func (d *DeclStruct) declNode() {}
 func (d *DeclStruct) GetType() *types.Type {
-	return nil
+	return d.Type
 }
 func (d *DeclStruct) GetPos() error.Position {
-	return error.Position{}
+	return d.Pos
 }
 func (d *DeclStruct) GetScope() *scope.Scope {
 	return d.Scope
@@ -180,7 +251,7 @@ func (d *DeclExternalFunction) GetType() *types.Type {
 	return d.ReturnType
 }
 func (d *DeclExternalFunction) GetPos() error.Position {
-	return error.Position{}
+	return d.Pos
 }
 func (d *DeclExternalFunction) GetScope() *scope.Scope {
 	return d.Scope
@@ -191,7 +262,7 @@ func (d *StmtLet) GetType() *types.Type {
 	return d.Type
 }
 func (s *StmtLet) GetPos() error.Position {
-	return error.Position{}
+	return s.Pos
 }
 func (s *StmtLet) GetScope() *scope.Scope {
 	return s.Scope
@@ -201,18 +272,73 @@ func (d *StmtBlock) GetType() *types.Type {
 	return nil
 }
 func (s *StmtBlock) GetPos() error.Position {
-	return error.Position{}
+	return s.Pos
 }
 func (s *StmtBlock) GetScope() *scope.Scope {
 	return s.Scope
 }
 
+func (d *StmtIf) stmtNode() {}
+func (d *StmtIf) GetType() *types.Type {
+	return nil
+}
+func (s *StmtIf) GetPos() error.Position {
+	return s.Pos
+}
+func (s *StmtIf) GetScope() *scope.Scope {
+	return s.Scope
+}
+
+func (d *StmtWhile) stmtNode() {}
+func (d *StmtWhile) GetType() *types.Type {
+	return nil
+}
+func (s *StmtWhile) GetPos() error.Position {
+	return s.Pos
+}
+func (s *StmtWhile) GetScope() *scope.Scope {
+	return s.Scope
+}
+
+func (d *StmtFor) stmtNode() {}
+func (d *StmtFor) GetType() *types.Type {
+	return nil
+}
+func (s *StmtFor) GetPos() error.Position {
+	return s.Pos
+}
+func (s *StmtFor) GetScope() *scope.Scope {
+	return s.Scope
+}
+
+func (d *StmtBreak) stmtNode() {}
+func (d *StmtBreak) GetType() *types.Type {
+	return nil
+}
+func (s *StmtBreak) GetPos() error.Position {
+	return s.Pos
+}
+func (s *StmtBreak) GetScope() *scope.Scope {
+	return s.Scope
+}
+
+func (d *StmtContinue) stmtNode() {}
+func (d *StmtContinue) GetType() *types.Type {
+	return nil
+}
+func (s *StmtContinue) GetPos() error.Position {
+	return s.Pos
+}
+func (s *StmtContinue) GetScope() *scope.Scope {
+	return s.Scope
+}
+
 func (d *StmtReturn) stmtNode() {}
 func (d *StmtReturn) GetType() *types.Type {
 	return nil
 }
 func (s *StmtReturn) GetPos() error.Position {
-	return error.Position{}
+	return s.Pos
 }
 func (s *StmtReturn) GetScope() *scope.Scope {
 	return s.Scope
@@ -223,7 +349,7 @@ func (d *StmtExpr) GetType() *types.Type {
 	return nil
 }
 func (s *StmtExpr) GetPos() error.Position {
-	return error.Position{}
+	return s.Pos
 }
 func (s *StmtExpr) GetScope() *scope.Scope {
 	return s.Scope
@@ -234,7 +360,7 @@ func (e *ExprAssign) GetType() *types.Type {
 	return nil
 }
 func (e *ExprAssign) GetPos() error.Position {
-	return error.Position{}
+	return e.Pos
 }
 func (e *ExprAssign) GetScope() *scope.Scope {
 	return nil
@@ -242,10 +368,10 @@ func (e *ExprAssign) GetScope() *scope.Scope {
 
 func (e *ExprBinary) exprNode() {}
 func (e *ExprBinary) GetType() *types.Type {
-	return nil
+	return e.Type
 }
 func (e *ExprBinary) GetPos() error.Position {
-	return error.Position{}
+	return e.Pos
 }
 func (e *ExprBinary) GetScope() *scope.Scope {
 	return nil
@@ -255,7 +381,7 @@ func (e *ExprIdentifier) GetType() *types.Type {
 	return e.Type
 }
 func (e *ExprIdentifier) GetPos() error.Position {
-	return error.Position{}
+	return e.Pos
 }
 func (e *ExprIdentifier) GetScope() *scope.Scope {
 	return nil
@@ -263,10 +389,10 @@ func (e *ExprIdentifier) GetScope() *scope.Scope {
 
 func (e *ExprInt) exprNode() {}
 func (e *ExprInt) GetType() *types.Type {
-	return nil
+	return e.Type
 }
 func (e *ExprInt) GetPos() error.Position {
-	return error.Position{}
+	return e.Pos
 }
 func (e *ExprInt) GetScope() *scope.Scope {
 	return nil
@@ -278,7 +404,7 @@ func (e *ExprUnary) GetType() *types.Type {
 }
 
 func (e *ExprUnary) GetPos() error.Position {
-	return error.Position{}
+	return e.Pos
 }
 func (e *ExprUnary) GetScope() *scope.Scope {
 	return nil
@@ -306,7 +432,7 @@ func (e *ExprArg) GetScope() *scope.Scope {
 }
 func (e *ExprCall) exprNode() {}
 func (e *ExprCall) GetType() *types.Type {
-	return nil
+	return e.Type
 }
 func (e *ExprCall) GetPos() error.Position {
 	return e.Pos
@@ -320,7 +446,7 @@ func (e *ExprField) GetType() *types.Type {
 	return e.Type
 }
 func (e *ExprField) GetPos() error.Position {
-	return error.Position{}
+	return e.Pos
 }
 func (e *ExprField) GetScope() *scope.Scope {
 	return nil
@@ -331,7 +457,7 @@ func (e *ExprBool) GetType() *types.Type {
 	return nil
 }
 func (e *ExprBool) GetPos() error.Position {
-	return error.Position{}
+	return e.Pos
 }
 func (e *ExprBool) GetScope() *scope.Scope {
 	return nil
@@ -341,7 +467,7 @@ func (e *ExprString) GetType() *types.Type {
 	return nil
 }
 func (e *ExprString) GetPos() error.Position {
-	return error.Position{}
+	return e.Pos
 }
 func (e *ExprString) GetScope() *scope.Scope {
 	return nil
@@ -0,0 +1,73 @@
+package resolver
+
+import (
+	"github.com/s0h1s2/ast"
+	"github.com/s0h1s2/error"
+	"github.com/s0h1s2/scope"
+)
+
+// Incremental resolves a program one declaration or statement at a time, the
+// way the REPL reads it. The top level scope persists between inputs.
+type Incremental struct {
+	r     *resolver
+	Scope *scope.Scope // variables declared outside of functions, hidden from them
+	mark  mark
+}
+
+// mark records how far the scopes had grown when an input began.
+type mark struct {
+	module int
+	scope  int
+	refs   int
+	links  int
+}
+
+func NewIncremental() *Incremental {
+	r := &resolver{
+		table:   InitTable(),
+		scopes:  make(map[*ast.Module]*scope.Scope),
+		externs: make(map[string]*DeclExternalFunction),
+		links:   make(map[string]bool),
+	}
+	r.module = scope.NewScope(r.table.Symbols)
+	r.table.Modules = []*scope.Scope{r.module}
+	return &Incremental{r: r, Scope: scope.NewScope(r.module)}
+}
+
+func (i *Incremental) Table() *Table {
+	return i.r.table
+}
+
+// Begin starts an input whose names Discard forgets.
+func (i *Incremental) Begin() {
+	i.mark = mark{i.r.module.Len(), i.Scope.Len(), len(i.r.table.Refs), len(i.r.linked)}
+}
+
+// Discard forgets the names declared since Begin, so that input which failed
+// to compile can be corrected and entered again.
+func (i *Incremental) Discard() {
+	i.r.module.Truncate(i.mark.module)
+	i.Scope.Truncate(i.mark.scope)
+	i.r.table.Refs = i.r.table.Refs[:i.mark.refs]
+	for _, link := range i.r.linked[i.mark.links:] {
+		delete(i.r.links, link)
+	}
+	i.r.linked = i.r.linked[:i.mark.links]
+	for name := range i.r.externs {
+		if !i.r.module.LookupOnce(name) {
+			delete(i.r.externs, name)
+		}
+	}
+}
+
+// Decl resolves a top level declaration other than an import.
+func (i *Incremental) Decl(decl ast.Decl, bag *error.DiagnosticBag) DeclNode {
+	i.r.handler = bag
+	return i.r.resolveDecl(decl)
+}
+
+// Stmt resolves a statement that runs outside of any function.
+func (i *Incremental) Stmt(stmt ast.Stmt, bag *error.DiagnosticBag) StmtNode {
+	i.r.handler = bag
+	return i.r.resolveStmt(stmt, i.Scope)
+}
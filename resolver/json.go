@@ -0,0 +1,278 @@
+package resolver
+
+import (
+	"github.com/s0h1s2/ast"
+	"github.com/s0h1s2/scope"
+	"github.com/s0h1s2/types"
+)
+
+var binaryNames = map[BinaryOperator]string{ADD: "+", SUB: "-", MUL: "*", DIV: "/", AND: "&&", OR: "||", EQ: "==", NE: "!=", LT: "<", LE: "<=", GT: ">", GE: ">="}
+var unaryNames = map[UnaryOperator]string{DEREF: "*", REFER: "&", MINUS: "-", NOT: "!"}
+var typeKindNames = map[types.TypeKind]string{
+	types.TYPE_INT:    "int",
+	types.TYPE_VOID:   "void",
+	types.TYPE_BOOL:   "bool",
+	types.TYPE_PTR:    "pointer",
+	types.TYPE_STRING: "string",
+	types.TYPE_STRUCT: "struct",
+}
+
+// exporter numbers the scopes of a program as the export reaches them.
+type exporter struct {
+	table  *Table
+	ids    map[*scope.Scope]int
+	scopes []*scope.Scope
+}
+
+// JSON exports a checked program for encoding/json: the resolved declarations,
+// whose types are named, every scope they reach, numbered and linked to its
+// parent, and the named types with their layout.
+func JSON(decls []DeclNode, table *Table) ast.Object {
+	e := &exporter{table: table, ids: make(map[*scope.Scope]int)}
+	global := e.scope(table.Symbols)
+	modules := make([]interface{}, len(table.Modules))
+	for i, module := range table.Modules {
+		modules[i] = e.scope(module)
+	}
+	list := []interface{}{}
+	for _, decl := range decls {
+		list = append(list, e.node(decl, nil))
+	}
+	scopes := []interface{}{}
+	named := []interface{}{}
+	for i := 0; i < len(e.scopes); i++ {
+		s := e.scopes[i]
+		symbols := []interface{}{}
+		for _, name := range s.Names() {
+			obj := s.GetObj(name)
+			symbol := ast.Object{"name": name, "kind": obj.Kind.String(), "type": typeName(obj.Type), "public": obj.Public, "scope": e.scope(obj.Scope)}
+			if obj.Pos.Line != 0 {
+				symbol["pos"] = ast.PosJSON(obj.Pos)
+				symbol["file"] = obj.Pos.File
+			}
+			symbols = append(symbols, symbol)
+			if obj.Kind == scope.TYPE {
+				named = append(named, typeJSON(obj.Type))
+			}
+		}
+		scopes = append(scopes, ast.Object{"id": i, "parent": e.scope(s.Parent()), "symbols": symbols})
+	}
+	return ast.Object{"version": ast.JSON_VERSION, "global": global, "modules": modules, "decls": list, "scopes": scopes, "types": named}
+}
+
+// scope returns the number of 's', null when there is no scope.
+func (e *exporter) scope(s *scope.Scope) interface{} {
+	if s == nil {
+		return nil
+	}
+	if id, ok := e.ids[s]; ok {
+		return id
+	}
+	id := len(e.scopes)
+	e.ids[s] = id
+	e.scopes = append(e.scopes, s)
+	e.scope(s.Parent())
+	return id
+}
+
+func typeName(t *types.Type) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.TypeName
+}
+func typeJSON(t *types.Type) ast.Object {
+	obj := ast.Object{"name": t.TypeName, "kind": typeKindNames[t.Kind], "size": t.Size, "align": t.Alignment}
+	if t.Kind == types.TYPE_STRUCT {
+		fields := make([]interface{}, len(t.Fields))
+		for i, field := range t.Fields {
+			fields[i] = ast.Object{"name": field.Name, "type": typeName(field.Type), "offset": field.Offset}
+		}
+		obj["fields"] = fields
+	}
+	return obj
+}
+func fieldsJSON(fields []Field) []interface{} {
+	list := make([]interface{}, len(fields))
+	for i, field := range fields {
+		list[i] = ast.Object{"name": field.Name, "type": typeName(field.Type), "pos": ast.PosJSON(field.Pos)}
+	}
+	return list
+}
+
+// node exports a node and its children. 'within' is the scope of the statement
+// around an expression, where its identifiers are looked up.
+func (e *exporter) node(node Node, within *scope.Scope) interface{} {
+	if node == nil {
+		return nil
+	}
+	obj := ast.Object{"pos": ast.PosJSON(node.GetPos())}
+	if expr, ok := node.(ExprNode); ok {
+		obj["type"] = typeName(e.table.TypeOf(expr))
+	}
+	if _, ok := node.(DeclNode); ok {
+		obj["file"] = node.GetPos().File
+	}
+	switch n := node.(type) {
+	case *DeclFunction:
+		{
+			obj["kind"] = "DeclFunction"
+			obj["name"] = n.Name
+			obj["link"] = n.Link
+			obj["returnType"] = typeName(n.ReturnType)
+			obj["params"] = fieldsJSON(n.Params)
+			obj["scope"] = e.scope(n.Scope)
+			obj["body"] = e.node(n.Body, n.Scope)
+		}
+	case *DeclExternalFunction:
+		{
+			obj["kind"] = "DeclExternalFunction"
+			obj["name"] = n.Name
+			obj["returnType"] = typeName(n.ReturnType)
+			obj["params"] = fieldsJSON(n.Params)
+			obj["scope"] = e.scope(n.Scope)
+		}
+	case *DeclStruct:
+		{
+			obj["kind"] = "DeclStruct"
+			obj["name"] = n.Name
+			obj["fields"] = fieldsJSON(n.Fields)
+			obj["scope"] = e.scope(n.Scope)
+		}
+	case *StmtBlock:
+		{
+			stmts := make([]interface{}, len(n.Body))
+			for i, stmt := range n.Body {
+				stmts[i] = e.node(stmt, n.Scope)
+			}
+			obj["kind"] = "StmtBlock"
+			obj["scope"] = e.scope(n.Scope)
+			obj["stmts"] = stmts
+		}
+	case *StmtLet:
+		{
+			obj["kind"] = "StmtLet"
+			obj["name"] = n.Name
+			obj["type"] = typeName(n.Type)
+			obj["init"] = e.node(n.Init, n.Scope)
+		}
+	case *StmtIf:
+		{
+			obj["kind"] = "StmtIf"
+			obj["cond"] = e.node(n.Cond, n.Scope)
+			obj["then"] = e.node(n.Then, n.Scope)
+			obj["else"] = e.node(n.Else, n.Scope)
+		}
+	case *StmtWhile:
+		{
+			obj["kind"] = "StmtWhile"
+			obj["cond"] = e.node(n.Cond, n.Scope)
+			obj["body"] = e.node(n.Body, n.Scope)
+		}
+	case *StmtFor:
+		{
+			obj["kind"] = "StmtFor"
+			obj["scope"] = e.scope(n.Scope)
+			obj["init"] = e.node(n.Init, n.Scope)
+			obj["cond"] = e.node(n.Cond, n.Scope)
+			obj["post"] = e.node(n.Post, n.Scope)
+			obj["body"] = e.node(n.Body, n.Scope)
+		}
+	case *StmtBreak:
+		{
+			obj["kind"] = "StmtBreak"
+		}
+	case *StmtContinue:
+		{
+			obj["kind"] = "StmtContinue"
+		}
+	case *StmtReturn:
+		{
+			obj["kind"] = "StmtReturn"
+			obj["result"] = e.node(n.Result, n.Scope)
+		}
+	case *StmtExpr:
+		{
+			obj["kind"] = "StmtExpr"
+			obj["expr"] = e.node(n.Expr, n.Scope)
+		}
+	case *ExprAssign:
+		{
+			obj["kind"] = "ExprAssign"
+			obj["left"] = e.node(n.Left, within)
+			obj["right"] = e.node(n.Right, within)
+		}
+	case *ExprBinary:
+		{
+			obj["kind"] = "ExprBinary"
+			obj["op"] = binaryNames[n.Op]
+			obj["left"] = e.node(n.Left, within)
+			obj["right"] = e.node(n.Right, within)
+		}
+	case *ExprField:
+		{
+			obj["kind"] = "ExprField"
+			obj["name"] = n.Name
+			obj["expr"] = e.node(n.Expr, within)
+		}
+	case *ExprUnary:
+		{
+			obj["kind"] = "ExprUnary"
+			obj["op"] = unaryNames[n.Op]
+			obj["operand"] = e.node(n.Right, within)
+		}
+	case *ExprCompound:
+		{
+			fields := make([]interface{}, len(n.Fields))
+			for i, field := range n.Fields {
+				fields[i] = ast.Object{"name": field.Name, "pos": ast.PosJSON(field.Pos), "init": e.node(field.Expr, within)}
+			}
+			obj["kind"] = "ExprCompound"
+			obj["fields"] = fields
+		}
+	case *ExprCall:
+		{
+			args := make([]interface{}, len(n.Args))
+			for i, arg := range n.Args {
+				args[i] = e.node(arg.Expr, within)
+			}
+			obj["kind"] = "ExprCall"
+			obj["name"] = n.Name
+			obj["link"] = n.Obj.Link
+			obj["args"] = args
+		}
+	case *ExprIdentifier:
+		{
+			obj["kind"] = "ExprIdentifier"
+			obj["name"] = n.Name
+			obj["scope"] = e.owner(within, n.Name, n.Obj)
+		}
+	case *ExprInt:
+		{
+			obj["kind"] = "ExprInt"
+			obj["value"] = n.Value
+		}
+	case *ExprBool:
+		{
+			obj["kind"] = "ExprBool"
+			obj["value"] = n.Value
+		}
+	case *ExprString:
+		{
+			obj["kind"] = "ExprString"
+			obj["value"] = n.Value
+		}
+	}
+	return obj
+}
+
+// owner returns the number of the scope from 'within' outwards that declares
+// 'obj' as 'name'.
+func (e *exporter) owner(within *scope.Scope, name string, obj *scope.Object) interface{} {
+	for s := within; s != nil; s = s.Parent() {
+		if s.LookupOnce(name) && s.GetObj(name) == obj {
+			return e.scope(s)
+		}
+	}
+	return nil
+}
@@ -2,6 +2,8 @@ package resolver
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 
 	"github.com/s0h1s2/ast"
 	"github.com/s0h1s2/error"
@@ -10,295 +12,570 @@ import (
 )
 
 type Table struct {
-	Symbols *scope.Scope
+	Symbols  *scope.Scope   // the builtin types, every module scope's parent
+	Modules  []*scope.Scope // top level scope of every module, in the order resolved
+	Types    *types.Universe
+	Refs     []Ref // every name bound, declarations included, in source order per module
+	pointers map[*types.Type]*types.Type
+	structs  map[*types.Type]*scope.Object
+}
+
+// Ref is a name in the source bound to the symbol it denotes, Pos spans the
+// whole name, 'mem.copy' included.
+type Ref struct {
+	Pos  error.Position
+	Name string
+	Obj  *scope.Object
 }
 
 const POINTER_SIZE = 8
 const POINTER_ALIGNMENT = 8
 
-var table *Table
-var handler *error.DiagnosticBag
-var cachedPtrTypes map[string]*types.Type = make(map[string]*types.Type)
+type resolver struct {
+	table   *Table
+	handler *error.DiagnosticBag
+	module  *scope.Scope // top level scope of the module being resolved
+	scopes  map[*ast.Module]*scope.Scope
+	externs map[string]*DeclExternalFunction
+	prefix  string          // prepended to the link names of the module being resolved
+	links   map[string]bool // link names taken
+	linked  []string        // link names in the order they were taken
+}
 
 func InitTable() *Table {
-	t := Table{Symbols: scope.NewScope(nil)}
-	t.Symbols.Define("i8", scope.NewTypeObj(types.NewType("i8", types.TYPE_INT, 1, 1)))
-	t.Symbols.Define("i16", scope.NewTypeObj(types.NewType("i16", types.TYPE_INT, 1, 1)))
-	t.Symbols.Define("i32", scope.NewTypeObj(types.NewType("i32", types.TYPE_INT, 1, 1)))
-	t.Symbols.Define("i64", scope.NewTypeObj(types.NewType("i64", types.TYPE_INT, 1, 1)))
-	t.Symbols.Define("bool", scope.NewTypeObj(types.NewType("bool", types.TYPE_BOOL, 1, 1)))
-	t.Symbols.Define("void", scope.NewTypeObj(types.NewType("void", types.TYPE_VOID, 0, 0)))
-	t.Symbols.Define("string", scope.NewTypeObj(types.NewType("string", types.TYPE_STRING, 0, 0)))
+	t := Table{Types: &types.Universe{}, Symbols: scope.NewScope(nil), pointers: make(map[*types.Type]*types.Type), structs: make(map[*types.Type]*scope.Object)}
+	t.Symbols.Define("i8", scope.NewTypeObj(t.Types.NewType("i8", types.TYPE_INT, 1, 1)))
+	t.Symbols.Define("i16", scope.NewTypeObj(t.Types.NewType("i16", types.TYPE_INT, 2, 2)))
+	t.Symbols.Define("i32", scope.NewTypeObj(t.Types.NewType("i32", types.TYPE_INT, 4, 4)))
+	t.Symbols.Define("i64", scope.NewTypeObj(t.Types.NewType("i64", types.TYPE_INT, 8, 8)))
+	t.Symbols.Define("bool", scope.NewTypeObj(t.Types.NewType("bool", types.TYPE_BOOL, 1, 1)))
+	t.Symbols.Define("void", scope.NewTypeObj(t.Types.NewType("void", types.TYPE_VOID, 0, 0)))
+	t.Symbols.Define("string", scope.NewTypeObj(t.Types.NewType("string", types.TYPE_STRING, POINTER_SIZE, POINTER_ALIGNMENT)))
 	return &t
 }
-func Resolve(program []ast.Decl, bag *error.DiagnosticBag) (*Table, []DeclNode) {
-	println("----RESOLVER----")
-	table = InitTable()
-	handler = bag
+
+// Resolve binds the names of 'modules' in a fresh symbol table. Every module
+// must come after the ones it imports, the declarations of all of them are
+// returned in that order.
+//
+// Each module has its own namespace. The last module, the program, keeps the
+// names of its declarations in generated code, the others are prefixed with
+// their module's name. Extern functions keep theirs in every module.
+func Resolve(modules []*ast.Module, bag *error.DiagnosticBag) (*Table, []DeclNode) {
+	r := &resolver{
+		table:   InitTable(),
+		handler: bag,
+		scopes:  make(map[*ast.Module]*scope.Scope),
+		externs: make(map[string]*DeclExternalFunction),
+		links:   make(map[string]bool),
+	}
+	for _, module := range modules {
+		for _, decl := range module.Decls {
+			if node, ok := decl.(*ast.DeclExternalFunction); ok {
+				r.links[node.Name] = true
+			}
+		}
+	}
 	var decls []DeclNode
-	for _, decl := range program {
-		decls = append(decls, resolveDecl(decl))
+	for i, module := range modules {
+		r.module = scope.NewScope(r.table.Symbols)
+		r.scopes[module] = r.module
+		r.table.Modules = append(r.table.Modules, r.module)
+		r.prefix = ""
+		if i < len(modules)-1 {
+			r.prefix = linkPrefix(module.Name)
+		}
+		for _, decl := range module.Decls {
+			if node, ok := decl.(*ast.DeclImport); ok {
+				r.resolveImport(node, module)
+				continue
+			}
+			if resolved := r.resolveDecl(decl); resolved != nil {
+				decls = append(decls, resolved)
+			}
+		}
+	}
+	return r.table, decls
+}
+func (r *resolver) resolveImport(node *ast.DeclImport, module *ast.Module) {
+	imported, ok := r.scopes[module.Imports[node.Path]]
+	if !ok {
+		return
+	}
+	if r.module.LookupOnce(node.Name) {
+		r.handler.ReportError(node.Pos, "Module '%s' is imported more than once, rename one with 'as'", node.Name)
+		return
 	}
-	return table, decls
+	obj := scope.NewObj(scope.MODULE, nil)
+	obj.Scope = imported
+	r.define(r.module, node.Name, obj, node.Pos)
+}
+
+// define declares 'name' at 'pos' in 's'.
+func (r *resolver) define(s *scope.Scope, name string, obj *scope.Object, pos error.Position) {
+	obj.Pos = pos
+	s.Define(name, obj)
+	r.ref(pos, name, obj)
 }
-func isTypeExist(typee ast.TypeSpec) (*types.Type, bool) {
+func (r *resolver) ref(pos error.Position, name string, obj *scope.Object) {
+	r.table.Refs = append(r.table.Refs, Ref{Pos: pos, Name: name, Obj: obj})
+}
+
+// lookup finds the top level 'name' visible from the module being resolved or,
+// when 'module' is set, among the public declarations of the module imported
+// under that name. 'missing' formats the error for a name that doesn't exist.
+func (r *resolver) lookup(module string, name string, pos error.Position, missing string) *scope.Object {
+	if module == "" {
+		obj := r.module.GetObj(name)
+		if obj == nil {
+			r.handler.ReportError(pos, missing, name)
+			return nil
+		}
+		r.ref(pos, name, obj)
+		return obj
+	}
+	imported := r.module.GetObj(module)
+	if imported == nil || imported.Kind != scope.MODULE {
+		r.handler.ReportError(pos, "Module '%s' isn't imported", module)
+		return nil
+	}
+	if !imported.Scope.LookupOnce(name) {
+		r.handler.ReportError(pos, missing, module+"."+name)
+		return nil
+	}
+	obj := imported.Scope.GetObj(name)
+	if !obj.Public {
+		r.handler.ReportError(pos, "'%s' isn't public in module '%s'", name, module)
+		return nil
+	}
+	r.ref(pos, name, obj)
+	return obj
+}
+
+// declare defines the top level 'name' in the module being resolved. Other
+// modules may use the same name, their link names tell them apart.
+func (r *resolver) declare(what string, name string, pos error.Position, obj *scope.Object) bool {
+	if r.module.Lookup(name) {
+		r.handler.ReportError(pos, "Can't redeclare %s '%s' more than once", what, name)
+		return false
+	}
+	r.define(r.module, name, obj, pos)
+	return true
+}
+
+// link takes the name in generated code of the top level 'name' declared in
+// the module being resolved.
+func (r *resolver) link(name string) string {
+	base := name
+	if r.prefix != "" {
+		base = r.prefix + "_" + name
+	}
+	link := base
+	for n := 1; r.links[link]; n++ {
+		link = base + "_" + strconv.Itoa(n)
+	}
+	r.links[link] = true
+	r.linked = append(r.linked, link)
+	return link
+}
+
+// linkPrefix turns a module name into an identifier.
+func linkPrefix(module string) string {
+	return strings.Map(func(c rune) rune {
+		if c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' {
+			return c
+		}
+		return '_'
+	}, module)
+}
+
+// Struct returns the object declaring the struct type 'typ'.
+func (t *Table) Struct(typ *types.Type) *scope.Object {
+	return t.structs[typ]
+}
+func (r *resolver) isTypeExist(typee ast.TypeSpec) (*types.Type, bool) {
 	switch t := typee.(type) {
 	case *ast.TypeName:
 		{
-			if table.Symbols.Lookup(t.Name) {
-				obj := table.Symbols.GetObj(t.Name)
-				if obj.Kind != scope.TYPE {
-					handler.ReportError(typee.GetPos(), "Type '%s' must be a type not variable name or function name", t.Name)
-					return nil, false
-				}
-				return obj.Type, true
+			obj := r.lookup(t.Module, t.Name, typee.GetPos(), "Type '%s' doesn't exist")
+			if obj == nil {
+				return nil, false
+			}
+			if obj.Kind != scope.TYPE {
+				r.handler.ReportError(typee.GetPos(), "Type '%s' must be a type not variable name or function name", t.Name)
+				return nil, false
 			}
-			handler.ReportError(typee.GetPos(), "Type '%s' doesn't exist", t.Name)
+			return obj.Type, true
 		}
 	case *ast.TypePtr:
 		{
-			val, ok := isTypeExist(t.Base)
+			val, ok := r.isTypeExist(t.Base)
 			if ok {
-				typeName := "*" + val.TypeName
-				if cachedPtrTypes[typeName] != nil {
-					return cachedPtrTypes[typeName], true
-				}
-				ptr := types.NewType(typeName, types.TYPE_PTR, POINTER_SIZE, POINTER_ALIGNMENT)
-				ptr.Base = val
-				cachedPtrTypes[typeName] = ptr
-				return ptr, true
+				return r.table.PointerTo(val), true
 			}
 		}
 	}
 	return nil, false
 }
-func resolveDecl(decl ast.Decl) DeclNode {
+
+// PointerTo returns the shared pointer type whose base is 'base'.
+func (t *Table) PointerTo(base *types.Type) *types.Type {
+	if ptr, ok := t.pointers[base]; ok {
+		return ptr
+	}
+	ptr := t.Types.NewType("*"+base.TypeName, types.TYPE_PTR, POINTER_SIZE, POINTER_ALIGNMENT)
+	ptr.Base = base
+	ptr.Link = "*" + base.Link
+	t.pointers[base] = ptr
+	return ptr
+}
+
+// TypeOf returns the type of a checked expression, including the ones whose
+// type is implied by the node kind rather than stored on it.
+func (t *Table) TypeOf(expr ExprNode) *types.Type {
+	switch node := expr.(type) {
+	case *ExprUnary:
+		{
+			switch node.Op {
+			case DEREF:
+				return node.Type.Base
+			case REFER:
+				return t.PointerTo(node.Type)
+			case NOT:
+				return t.Symbols.GetObj("bool").Type
+			}
+		}
+	case *ExprAssign:
+		{
+			return t.TypeOf(node.Left)
+		}
+	case *ExprBool:
+		{
+			return t.Symbols.GetObj("bool").Type
+		}
+	case *ExprString:
+		{
+			return t.Symbols.GetObj("string").Type
+		}
+	case *ExprCall:
+		{
+			return node.Obj.Type
+		}
+	}
+	return expr.GetType()
+}
+func (r *resolver) resolveDecl(decl ast.Decl) DeclNode {
 	switch node := decl.(type) {
 	case *ast.DeclExternalFunction:
 		{
-			if table.Symbols.LookupOnce(node.Name) {
-				handler.ReportError(node.Pos, "Can't redeclare function '%s' more than once", node.Name)
-				return nil
-			}
-			retType, ok := isTypeExist(node.ReturnType)
+			retType, ok := r.isTypeExist(node.ReturnType)
 			if !ok {
 				return nil
 			}
 			fnScope := scope.NewScope(nil)
-			table.Symbols.Define(node.Name, scope.NewObj(scope.FN, retType))
+			params := make([]Field, 0, len(node.Parameters))
 			for _, param := range node.Parameters {
 				if !fnScope.LookupOnce(param.Name) {
-					typ, ok := isTypeExist(param.Type)
+					typ, ok := r.isTypeExist(param.Type)
 					if !ok {
 						return nil
 					}
-					fnScope.Define(param.Name, scope.NewObj(scope.PARAM, typ))
+					r.define(fnScope, param.Name, scope.NewObj(scope.PARAM, typ), param.Pos)
+					params = append(params, Field{Name: param.Name, Type: typ, Pos: param.Pos})
 				} else {
-					handler.ReportError(node.Pos, "Can't redeclare '%s' parameter more than once", param.Name)
+					r.handler.ReportError(node.Pos, "Can't redeclare '%s' parameter more than once", param.Name)
 					return nil
 				}
 			}
 
-			table.Symbols.GetObj(node.Name).Scope = fnScope
-			return &DeclExternalFunction{ReturnType: retType, Name: node.Name, Scope: fnScope, Pos: node.Pos}
+			extern := &DeclExternalFunction{ReturnType: retType, Name: node.Name, Scope: fnScope, Pos: node.Pos, Params: params}
+			obj := scope.NewObj(scope.FN, retType)
+			obj.Scope = fnScope
+			obj.Public = node.Pub
+			obj.Link = node.Name
+			if prev, ok := r.externs[node.Name]; ok && !r.module.LookupOnce(node.Name) {
+				// Modules may each declare the same C function, it is emitted once.
+				if !sameSignature(prev, extern) {
+					r.handler.ReportError(node.Pos, "Extern function '%s' doesn't match its declaration in '%s'", node.Name, prev.Pos.File)
+				} else {
+					r.define(r.module, node.Name, obj, node.Pos)
+				}
+				return nil
+			}
+			if !r.declare("function", node.Name, node.Pos, obj) {
+				return nil
+			}
+			r.externs[node.Name] = extern
+			return extern
 		}
 	case *ast.DeclFunction:
 		{
-			if table.Symbols.LookupOnce(node.Name) {
-				handler.ReportError(node.Pos, "Can't redeclare function '%s' more than once", node.Name)
-				return nil
-			}
-			retType, ok := isTypeExist(node.RetType)
+			retType, ok := r.isTypeExist(node.RetType)
 			if !ok {
 				return nil
 			}
 			fnScope := scope.NewScope(nil)
-			table.Symbols.Define(node.Name, scope.NewObj(scope.FN, retType))
+			obj := scope.NewObj(scope.FN, retType)
+			obj.Scope = fnScope
+			obj.Public = node.Pub
+			if !r.declare("function", node.Name, node.Pos, obj) {
+				return nil
+			}
+			obj.Link = r.link(node.Name)
+			params := make([]Field, 0, len(node.Parameters))
 			for _, param := range node.Parameters {
 				if !fnScope.LookupOnce(param.Name) {
-					typ, ok := isTypeExist(param.Type)
+					typ, ok := r.isTypeExist(param.Type)
 					if !ok {
 						return nil
 					}
-					fnScope.Define(param.Name, scope.NewObj(scope.PARAM, typ))
+					r.define(fnScope, param.Name, scope.NewObj(scope.PARAM, typ), param.Pos)
+					params = append(params, Field{Name: param.Name, Type: typ, Pos: param.Pos})
 				} else {
-					handler.ReportError(node.Pos, "Can't redeclare '%s' parameter more than once", param.Name)
+					r.handler.ReportError(node.Pos, "Can't redeclare '%s' parameter more than once", param.Name)
 					return nil
 				}
 			}
 
-			table.Symbols.GetObj(node.Name).Scope = fnScope
-			resolvedBody := resolveStmt(node.Body, fnScope)
-			return &DeclFunction{Scope: fnScope, Name: node.Name, Body: resolvedBody, ReturnType: retType}
+			resolvedBody := r.resolveStmt(node.Body, fnScope)
+			return &DeclFunction{Scope: fnScope, Name: node.Name, Link: obj.Link, Public: node.Pub, Body: resolvedBody, ReturnType: retType, Params: params, Pos: node.Pos, End: node.End}
 		}
 	case *ast.DeclStruct:
 		{
-			if table.Symbols.LookupOnce(node.Name) {
-				handler.ReportError(node.Pos, "Can't redeclare struct '%s' more than once", node.Name)
-				return nil
-			}
 			structScope := scope.NewScope(nil)
-			obj := scope.NewObj(scope.TYPE, types.NewType(node.Name, types.TYPE_STRUCT, 0, 0))
+			structType := r.table.Types.NewType(node.Name, types.TYPE_STRUCT, 0, 0)
+			obj := scope.NewObj(scope.TYPE, structType)
 			obj.Scope = structScope
-			table.Symbols.Define(node.Name, obj)
+			obj.Public = node.Pub
+			if !r.declare("struct", node.Name, node.Pos, obj) {
+				return nil
+			}
+			obj.Link = r.link(node.Name)
+			structType.Link = obj.Link
+			r.table.structs[structType] = obj
 			fields := make([]Field, 0, 4)
 			for _, field := range node.Fields {
 				if structScope.LookupOnce(field.Name) {
-					handler.ReportError(field.Pos, "Can't redeclare '%s' field more than once in struct '%s'", field.Name, node.Name)
+					r.handler.ReportError(field.Pos, "Can't redeclare '%s' field more than once in struct '%s'", field.Name, node.Name)
 					return nil
 				}
-				typ, ok := isTypeExist(field.Type)
+				typ, ok := r.isTypeExist(field.Type)
 				if !ok {
 					return nil
 				}
 				obj := scope.NewObj(scope.FIELD, typ)
 				if typ.Kind == types.TYPE_STRUCT {
-					obj.Scope = table.Symbols.GetObj(typ.TypeName).Scope
+					obj.Scope = r.table.Struct(typ).Scope
 				}
-				structScope.Define(field.Name, obj)
-				fields = append(fields, Field{Name: field.Name, Type: typ})
+				r.define(structScope, field.Name, obj, field.Pos)
+				structType.AddField(field.Name, typ)
+				fields = append(fields, Field{Name: field.Name, Type: typ, Pos: field.Pos})
 			}
-			return &DeclStruct{Name: node.Name, Fields: fields, Pos: node.Pos, Scope: structScope}
+			structType.Layout()
+			return &DeclStruct{Name: node.Name, Type: structType, Fields: fields, Pos: node.Pos, Scope: structScope}
 		}
 	}
 	return nil
 }
 
-func resolveStmt(stmt ast.Stmt, currScope *scope.Scope) StmtNode {
+func sameSignature(a *DeclExternalFunction, b *DeclExternalFunction) bool {
+	if a.ReturnType != b.ReturnType || len(a.Params) != len(b.Params) {
+		return false
+	}
+	for i := range a.Params {
+		if a.Params[i].Type != b.Params[i].Type {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *resolver) resolveStmt(stmt ast.Stmt, currScope *scope.Scope) StmtNode {
 	pos := stmt.GetPos()
 	switch node := stmt.(type) {
 	case *ast.StmtLet:
 		{
 			if !currScope.LookupOnce(node.Name) {
-				typ, ok := isTypeExist(node.Type)
+				typ, ok := r.isTypeExist(node.Type)
 				if !ok {
 					return nil
 				}
-				currScope.Define(node.Name, scope.NewObj(scope.VAR, typ))
+				r.define(currScope, node.Name, scope.NewObj(scope.VAR, typ), node.Pos)
 				var resolvedExpr ExprNode
 				if node.Init != nil {
-					resolvedExpr = resolveExpr(node.Init, currScope, nil)
+					resolvedExpr = r.resolveExpr(node.Init, currScope, nil)
 				}
 				return &StmtLet{Name: node.Name, Init: resolvedExpr, Scope: currScope, Type: typ, Pos: node.Pos}
 			}
-			handler.ReportError(pos, "Can't redeclare '%s' variable more than once in same block", node.Name)
+			r.handler.ReportError(pos, "Can't redeclare '%s' variable more than once in same block", node.Name)
 		}
 	case *ast.StmtReturn:
 		{
+			if _, ok := node.Result.(*ast.BadExpr); ok {
+				return nil
+			}
 			if node.Result != nil {
-				resolvedExpr := resolveExpr(node.Result, currScope, nil)
-				return &StmtReturn{Result: resolvedExpr}
+				resolvedExpr := r.resolveExpr(node.Result, currScope, nil)
+				return &StmtReturn{Result: resolvedExpr, Scope: currScope, Pos: pos}
 			}
+			return &StmtReturn{Scope: currScope, Pos: pos}
+		}
+	case *ast.StmtIf:
+		{
+			cond := r.resolveExpr(node.Cond, currScope, nil)
+			then := r.resolveStmt(node.Then, currScope)
+			var els StmtNode
+			if node.Else != nil {
+				els = r.resolveStmt(node.Else, currScope)
+			}
+			return &StmtIf{Cond: cond, Then: then, Else: els, Scope: currScope, Pos: pos}
+		}
+	case *ast.StmtWhile:
+		{
+			cond := r.resolveExpr(node.Cond, currScope, nil)
+			body := r.resolveStmt(node.Body, currScope)
+			return &StmtWhile{Cond: cond, Body: body, Scope: currScope, Pos: pos}
+		}
+	case *ast.StmtFor:
+		{
+			s := scope.NewScope(currScope)
+			loop := &StmtFor{Scope: s, Pos: pos}
+			if node.Init != nil {
+				loop.Init = r.resolveStmt(node.Init, s)
+			}
+			if node.Cond != nil {
+				loop.Cond = r.resolveExpr(node.Cond, s, nil)
+			}
+			if node.Post != nil {
+				loop.Post = r.resolveExpr(node.Post, s, nil)
+			}
+			loop.Body = r.resolveStmt(node.Body, s)
+			return loop
+		}
+	case *ast.StmtBreak:
+		{
+			return &StmtBreak{Scope: currScope, Pos: pos}
+		}
+	case *ast.StmtContinue:
+		{
+			return &StmtContinue{Scope: currScope, Pos: pos}
 		}
 	case *ast.StmtExpr:
 		{
-			expr := resolveExpr(node.Expr, currScope, nil)
-			return &StmtExpr{Expr: expr, Scope: currScope}
+			expr := r.resolveExpr(node.Expr, currScope, nil)
+			return &StmtExpr{Expr: expr, Scope: currScope, Pos: pos}
 		}
 	case *ast.StmtBlock:
 		{
 			s := scope.NewScope(currScope)
 			var resolvedStmts []StmtNode
 			for _, stmt := range node.Block {
-				resolvedStmts = append(resolvedStmts, resolveStmt(stmt, s))
+				if resolved := r.resolveStmt(stmt, s); resolved != nil {
+					resolvedStmts = append(resolvedStmts, resolved)
+				}
 			}
-			return &StmtBlock{Scope: s, Body: resolvedStmts}
+			return &StmtBlock{Scope: s, Body: resolvedStmts, Pos: pos}
 		}
 	}
 	return nil
 }
 
-func resolveExpr(expr ast.Expr, currScope *scope.Scope, typeScope *scope.Scope) ExprNode {
+func (r *resolver) resolveExpr(expr ast.Expr, currScope *scope.Scope, typeScope *scope.Scope) ExprNode {
 	pos := expr.GetPos()
 	switch node := expr.(type) {
 	case *ast.ExprBinary:
 		{
-			left := resolveExpr(node.Left, currScope, nil)
-			right := resolveExpr(node.Right, currScope, nil)
-			return &ExprBinary{Left: left, Right: right, Op: KindToBinary[node.Op]}
+			left := r.resolveExpr(node.Left, currScope, nil)
+			right := r.resolveExpr(node.Right, currScope, nil)
+			return &ExprBinary{Left: left, Right: right, Op: KindToBinary[node.Op], Pos: pos}
 		}
 	case *ast.ExprCompound:
 		{
-			typ, ok := isTypeExist(node.Type)
+			typ, ok := r.isTypeExist(node.Type)
 			if !ok {
 				return nil
 			}
 			// Type must not be a pointer or primitive  e.g '*Vector{}','i32'
 			if typ.Kind != types.TYPE_STRUCT /* || union*/ {
-				handler.ReportError(node.Pos, "Type must be a struct or union in order to compose")
+				r.handler.ReportError(node.Pos, "Type must be a struct or union in order to compose")
 				return nil
 			}
-			structScope := table.Symbols.GetObj(typ.TypeName).Scope
+			structObj := r.table.Struct(typ)
+			structScope := structObj.Scope
 			fieldsName := structScope.QueryByKind(scope.FIELD)
 			resolvedFieldsName := map[string]bool{}
 			resolvedFields := make([]ExprCompoundField, 0, 4)
 			for _, field := range node.Fields {
 				if !structScope.LookupOnce(field.Name) {
-					handler.ReportError(field.Pos, "'%s' doesn't have '%s' field", typ.TypeName, field.Name)
+					r.handler.ReportError(field.Pos, "'%s' doesn't have '%s' field", typ.TypeName, field.Name)
 					continue
 				}
+				r.ref(field.Pos, field.Name, structScope.GetObj(field.Name))
 				resolvedFieldsName[field.Name] = true
-				resolvedExpr := resolveExpr(field.Init, currScope, nil)
-				resolvedFields = append(resolvedFields, ExprCompoundField{Name: field.Name, Expr: resolvedExpr})
+				resolvedExpr := r.resolveExpr(field.Init, currScope, nil)
+				resolvedFields = append(resolvedFields, ExprCompoundField{Name: field.Name, Expr: resolvedExpr, Pos: field.Pos})
 			}
 			for _, fieldName := range fieldsName {
 				_, ok := resolvedFieldsName[fieldName]
 				if !ok {
-					handler.ReportError(node.Pos, "Field '%s' must be initialized in '%s' struct Compound", fieldName, typ.TypeName)
+					r.handler.ReportError(node.Pos, "Field '%s' must be initialized in '%s' struct Compound", fieldName, typ.TypeName)
 				}
 			}
-			return &ExprCompound{Type: typ, Fields: resolvedFields, Pos: node.Pos}
+			return &ExprCompound{Type: typ, Obj: structObj, Fields: resolvedFields, Pos: node.Pos}
 		}
 	case *ast.ExprCall:
 		{
-			if !table.Symbols.LookupOnce(node.Name) {
-				handler.ReportError(node.Pos, "Function '%s' not found", node.Name)
+			fnObj := r.lookup(node.Module, node.Name, node.NamePos, "Function '%s' not found")
+			if fnObj == nil {
+				return nil
+			}
+			if fnObj.Kind != scope.FN {
+				r.handler.ReportError(node.Pos, "'%s' isn't a function", node.Name)
 				return nil
 			}
-			fnObj := table.Symbols.GetObj(node.Name)
 			params := fnObj.Scope.QueryByKind(scope.PARAM)
 			args := make([]*ExprArg, 0)
 			for _, arg := range node.Args {
-				resolved := resolveExpr(arg, currScope, nil)
-				args = append(args, &ExprArg{Expr: resolved})
+				resolved := r.resolveExpr(arg, currScope, nil)
+				args = append(args, &ExprArg{Expr: resolved, Pos: arg.GetPos()})
 			}
 			paramLen := len(params)
 			argsLen := len(args)
 			if paramLen != argsLen {
-				handler.ReportError(node.Pos, "Function '%s' expected '%d' arguments but got '%d' arguments", node.Name, paramLen, argsLen)
+				r.handler.ReportError(node.Pos, "Function '%s' expected '%d' arguments but got '%d' arguments", node.Name, paramLen, argsLen)
 				return nil
 			}
-			return &ExprCall{Name: node.Name, Args: args, Pos: node.Pos}
+			return &ExprCall{Name: node.Name, Obj: fnObj, Args: args, Pos: node.Pos}
 		}
 	case *ast.ExprAssign:
 		{
-			left := resolveExpr(node.Left, currScope, nil)
-			right := resolveExpr(node.Right, currScope, nil)
-			return &ExprAssign{Right: right, Left: left}
+			left := r.resolveExpr(node.Left, currScope, nil)
+			right := r.resolveExpr(node.Right, currScope, nil)
+			return &ExprAssign{Right: right, Left: left, Pos: pos}
 		}
 	case *ast.ExprInt:
 		{
-			return &ExprInt{Value: node.Value}
+			return &ExprInt{Value: node.Value, Pos: pos}
 		}
 	case *ast.ExprBoolean:
 		{
-			return &ExprBool{Value: node.Value}
+			return &ExprBool{Value: node.Value, Pos: pos}
 		}
 	case *ast.ExprString:
 		{
-			return &ExprString{Value: node.Value}
+			return &ExprString{Value: node.Value, Pos: pos}
 		}
 
 	case *ast.ExprUnary:
 		{
-			resolved := resolveExpr(node.Right, currScope, nil)
+			resolved := r.resolveExpr(node.Right, currScope, nil)
 			if resolved != nil {
-				return &ExprUnary{Type: resolved.GetType(), Right: resolved, Op: KindToUnary[node.Op]}
+				return &ExprUnary{Type: resolved.GetType(), Right: resolved, Op: KindToUnary[node.Op], Pos: pos}
 			}
 		}
 	case *ast.ExprField:
 		{
-			left := resolveExpr(node.Expr, currScope, nil)
+			left := r.resolveExpr(node.Expr, currScope, nil)
 			if left != nil {
 				typ := left.GetType()
 				if typ.Kind == types.TYPE_PTR {
@@ -306,14 +583,15 @@ func resolveExpr(expr ast.Expr, currScope *scope.Scope, typeScope *scope.Scope)
 				}
 				typeName := typ.TypeName
 				if typ.Kind != types.TYPE_STRUCT {
-					handler.ReportError(left.GetPos(), "Primitive type '%s' doesn't have fields", typeName)
+					r.handler.ReportError(left.GetPos(), "Primitive type '%s' doesn't have fields", typeName)
 					return nil
 				}
-				structScope := table.Symbols.GetObj(typeName).Scope
+				structScope := r.table.Struct(typ).Scope
 				if structScope.LookupOnce(node.Name) {
-					return &ExprField{Type: structScope.GetObj(node.Name).Type, Name: node.Name}
+					r.ref(node.Pos, node.Name, structScope.GetObj(node.Name))
+					return &ExprField{Type: structScope.GetObj(node.Name).Type, Name: node.Name, Expr: left, Pos: node.Pos}
 				} else {
-					handler.ReportError(left.GetPos(), "'%s' doesn't have '%s' field", typeName, node.Name)
+					r.handler.ReportError(left.GetPos(), "'%s' doesn't have '%s' field", typeName, node.Name)
 				}
 			}
 			return left
@@ -322,10 +600,17 @@ func resolveExpr(expr ast.Expr, currScope *scope.Scope, typeScope *scope.Scope)
 	case *ast.ExprIdent:
 		{
 			if !currScope.Lookup(node.Name) {
-				handler.ReportError(pos, "Variable '%s' not found", node.Name)
+				r.handler.ReportError(pos, "Variable '%s' not found", node.Name)
 				return nil
 			}
-			return &ExprIdentifier{Name: node.Name, Type: currScope.GetObj(node.Name).Type}
+			obj := currScope.GetObj(node.Name)
+			r.ref(pos, node.Name, obj)
+			return &ExprIdentifier{Name: node.Name, Type: obj.Type, Obj: obj, Pos: pos}
+		}
+	case *ast.BadExpr:
+		{
+			// Reported by the parser.
+			return nil
 		}
 	default:
 		{
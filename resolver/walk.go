@@ -0,0 +1,119 @@
+package resolver
+
+// Visitor is called by Walk for every node it reaches. The visitor Visit returns
+// visits the children of the node, they are skipped when it is nil.
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+// Walk traverses the resolved tree rooted at 'node' depth first. It calls
+// v.Visit(node) and, unless that returns nil, walks every child with the
+// visitor returned and then calls its Visit(nil). Nodes that failed to resolve
+// are skipped.
+func Walk(v Visitor, node Node) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+	switch n := node.(type) {
+	case *DeclFunction:
+		{
+			walk(v, n.Body)
+		}
+	case *StmtBlock:
+		{
+			for _, stmt := range n.Body {
+				walk(v, stmt)
+			}
+		}
+	case *StmtLet:
+		{
+			walk(v, n.Init)
+		}
+	case *StmtIf:
+		{
+			walk(v, n.Cond)
+			walk(v, n.Then)
+			walk(v, n.Else)
+		}
+	case *StmtWhile:
+		{
+			walk(v, n.Cond)
+			walk(v, n.Body)
+		}
+	case *StmtFor:
+		{
+			walk(v, n.Init)
+			walk(v, n.Cond)
+			walk(v, n.Post)
+			walk(v, n.Body)
+		}
+	case *StmtReturn:
+		{
+			walk(v, n.Result)
+		}
+	case *StmtExpr:
+		{
+			walk(v, n.Expr)
+		}
+	case *ExprAssign:
+		{
+			walk(v, n.Left)
+			walk(v, n.Right)
+		}
+	case *ExprBinary:
+		{
+			walk(v, n.Left)
+			walk(v, n.Right)
+		}
+	case *ExprField:
+		{
+			walk(v, n.Expr)
+		}
+	case *ExprUnary:
+		{
+			walk(v, n.Right)
+		}
+	case *ExprCompound:
+		{
+			for _, field := range n.Fields {
+				walk(v, field.Expr)
+			}
+		}
+	case *ExprCall:
+		{
+			for _, arg := range n.Args {
+				if arg != nil {
+					Walk(v, arg)
+				}
+			}
+		}
+	case *ExprArg:
+		{
+			walk(v, n.Expr)
+		}
+	}
+	v.Visit(nil)
+}
+
+// walk walks 'node' unless it is missing.
+func walk(v Visitor, node Node) {
+	if node != nil {
+		Walk(v, node)
+	}
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect walks the resolved tree rooted at 'node', calling f for every node
+// and, after the children of a node, f(nil). The children are skipped when f
+// returns false.
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
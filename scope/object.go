@@ -2,6 +2,7 @@ package scope
 
 import (
 	// "github.com/s0h1s2/ast"
+	"github.com/s0h1s2/error"
 	"github.com/s0h1s2/types"
 )
 
@@ -13,12 +14,22 @@ const (
 	PARAM
 	FIELD
 	TYPE
+	MODULE // an imported module, Scope holds its top level declarations
 )
 
+var kindNames = [...]string{FN: "fn", VAR: "var", PARAM: "param", FIELD: "field", TYPE: "type", MODULE: "module"}
+
+func (k ObjectKind) String() string {
+	return kindNames[k]
+}
+
 type Object struct {
-	Kind  ObjectKind
-	Type  *types.Type
-	Scope *Scope
+	Kind   ObjectKind
+	Type   *types.Type
+	Scope  *Scope
+	Public bool           // visible to the modules importing the declaring one
+	Link   string         // name of a top level declaration in generated code, unique in the program
+	Pos    error.Position // where the name is declared, zero for builtins
 }
 
 func NewObj(kind ObjectKind, typee *types.Type) *Object {
@@ -3,6 +3,7 @@ package scope
 type Scope struct {
 	parent  *Scope
 	symbols map[string]*Object
+	names   []string // definition order
 }
 
 func NewScope(parent *Scope) *Scope {
@@ -29,12 +30,15 @@ func (s *Scope) LookupOnce(name string) bool {
 }
 
 func (s *Scope) Define(name string, obj *Object) {
+	if _, ok := s.symbols[name]; !ok {
+		s.names = append(s.names, name)
+	}
 	s.symbols[name] = obj
 }
 func (s *Scope) QueryByKind(kind ObjectKind) []string {
 	objects := make([]string, 0, 4)
-	for k, v := range s.symbols {
-		if v.Kind == kind {
+	for _, k := range s.names {
+		if s.symbols[k].Kind == kind {
 			objects = append(objects, k)
 		}
 	}
@@ -42,8 +46,8 @@ func (s *Scope) QueryByKind(kind ObjectKind) []string {
 }
 func (s *Scope) QueryObjByKind(kind ObjectKind) []*Object {
 	objects := make([]*Object, 0, 4)
-	for _, v := range s.symbols {
-		if v.Kind == kind {
+	for _, k := range s.names {
+		if v := s.symbols[k]; v.Kind == kind {
 			objects = append(objects, v)
 		}
 	}
@@ -60,3 +64,26 @@ func (s *Scope) GetObj(name string) *Object {
 	}
 	return nil
 }
+
+// Len returns the number of names defined in the scope.
+func (s *Scope) Len() int {
+	return len(s.names)
+}
+
+// Truncate forgets every name but the first 'n' defined.
+func (s *Scope) Truncate(n int) {
+	for _, name := range s.names[n:] {
+		delete(s.symbols, name)
+	}
+	s.names = s.names[:n]
+}
+
+// Parent returns the enclosing scope, nil for the outermost one.
+func (s *Scope) Parent() *Scope {
+	return s.parent
+}
+
+// Names lists the names defined in the scope in definition order.
+func (s *Scope) Names() []string {
+	return s.names
+}
@@ -28,6 +28,7 @@ const (
 	TK_GREATERTHAN
 	TK_GREATEREQUAL
 	TK_LESSEQUAL
+	TK_COMMENT
 	// KEYWORDS
 	keywords_begin
 	TK_LET
@@ -35,10 +36,18 @@ const (
 	TK_FN
 	TK_STRUCT
 	TK_IF
+	TK_ELSE
 	TK_TRUE
 	TK_FALSE
 	TK_STRING
 	TK_RETURN
+	TK_IMPORT
+	TK_PUB
+	TK_WHILE
+	TK_FOR
+	TK_BREAK
+	TK_CONTINUE
+	TK_AS
 	keywords_end
 
 	TK_EOF
@@ -65,15 +74,24 @@ var tokenKindString = [...]string{
 	TK_LESSEQUAL:    "<=",
 	TK_NOTEQUAL:     "!=",
 	TK_EQUAL:        "==",
+	TK_COMMENT:      "comment",
 	TK_INTEGER:      "integer",
 	TK_STRING:       "string",
 	TK_EXTERN:       "extern",
 	TK_IDENT:        "identifier",
 	TK_RETURN:       "return",
+	TK_IMPORT:       "import",
+	TK_PUB:          "pub",
+	TK_WHILE:        "while",
+	TK_FOR:          "for",
+	TK_BREAK:        "break",
+	TK_CONTINUE:     "continue",
+	TK_AS:           "as",
 	TK_STRUCT:       "struct",
 	TK_LET:          "let",
 	TK_FN:           "fn",
 	TK_IF:           "if",
+	TK_ELSE:         "else",
 	TK_TRUE:         "true",
 	TK_FALSE:        "false",
 	TK_EOF:          "EOF",
@@ -1,7 +1,5 @@
 package types
 
-var typeId int = 0
-
 type TypeKind int
 
 const (
@@ -13,23 +11,70 @@ const (
 	TYPE_STRUCT
 )
 
+type Field struct {
+	Name   string
+	Type   *Type
+	Offset uint64
+}
+
 type Type struct {
 	TypeName  string
+	Link      string // name in generated code, unlike TypeName unique in the program
 	Kind      TypeKind
 	Size      uint64
 	Alignment uint64
 	Base      *Type
 	TypeId    int
+	Fields    []Field // struct fields in declaration order
+}
+
+// Universe hands out the ids of the types of one compilation.
+type Universe struct {
+	lastId int
 }
 
-func NewType(name string, kind TypeKind, size uint64, align uint64) *Type {
-	typeId++
+func (u *Universe) NewType(name string, kind TypeKind, size uint64, align uint64) *Type {
+	u.lastId++
 	return &Type{
 		TypeName:  name,
+		Link:      name,
 		Kind:      kind,
 		Size:      size,
 		Alignment: align,
-		TypeId:    typeId,
+		TypeId:    u.lastId,
 		Base:      nil,
 	}
 }
+
+// AddField appends a field to a struct type and grows its size and alignment.
+func (t *Type) AddField(name string, typ *Type) {
+	offset := alignUp(t.Size, typ.Alignment)
+	t.Fields = append(t.Fields, Field{Name: name, Type: typ, Offset: offset})
+	t.Size = offset + typ.Size
+	if typ.Alignment > t.Alignment {
+		t.Alignment = typ.Alignment
+	}
+}
+
+// Layout pads the struct size to a multiple of its alignment.
+func (t *Type) Layout() {
+	if t.Alignment == 0 {
+		t.Alignment = 1
+	}
+	t.Size = alignUp(t.Size, t.Alignment)
+}
+func (t *Type) Field(name string) *Field {
+	for i := range t.Fields {
+		if t.Fields[i].Name == name {
+			return &t.Fields[i]
+		}
+	}
+	return nil
+}
+
+func alignUp(n uint64, align uint64) uint64 {
+	if align == 0 {
+		return n
+	}
+	return (n + align - 1) / align * align
+}
@@ -0,0 +1,367 @@
+package vm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"runtime"
+
+	"github.com/s0h1s2/bytecode"
+	"github.com/s0h1s2/interp"
+)
+
+type runtimeError string
+
+func (e runtimeError) Error() string {
+	return string(e)
+}
+
+// exitError unwinds the machine when the program calls exit.
+type exitError int64
+
+func (e exitError) Error() string {
+	return fmt.Sprintf("exit status %d", int64(e))
+}
+
+// HostFunc implements an extern function. String arguments arrive as addresses, see CString.
+type HostFunc func(m *VM, args []int64) int64
+
+const (
+	MemorySize   = 1 << 20
+	maxCallDepth = 10000
+)
+
+type VM struct {
+	program *bytecode.Program
+	memory  []byte
+	stack   []int64
+	sp      int64 // frames grow down from the end of memory
+	depth   int
+	hosts   []HostFunc
+	out     io.Writer
+}
+
+// New loads a program into a fresh memory. Externs named like the interpreter
+// builtins are bound to them, others must be provided with Define before use.
+func New(program *bytecode.Program, out io.Writer) *VM {
+	m := &VM{
+		program: program,
+		memory:  make([]byte, MemorySize),
+		sp:      MemorySize,
+		hosts:   make([]HostFunc, len(program.Externs)),
+		out:     out,
+	}
+	copy(m.memory[bytecode.DataBase:], program.Data)
+	for i, ext := range program.Externs {
+		m.hosts[i] = builtins[ext.Name]
+	}
+	return m
+}
+
+// Define binds an extern function of the program to a Go implementation.
+func (m *VM) Define(name string, fn HostFunc) {
+	for i, ext := range m.program.Externs {
+		if ext.Name == name {
+			m.hosts[i] = fn
+		}
+	}
+}
+
+// Run calls 'main' and returns its result as the process exit code.
+func Run(program *bytecode.Program, out io.Writer) (int, error) {
+	m := New(program, out)
+	if _, fn := program.Function("main"); fn == nil {
+		return 1, fmt.Errorf("function 'main' not found")
+	}
+	result, err := m.Call("main")
+	if code, ok := err.(exitError); ok {
+		return int(uint8(code)), nil
+	}
+	if err != nil {
+		return 1, err
+	}
+	return int(uint8(result)), nil
+}
+
+// Call invokes a function by name and converts runtime failures, including
+// those of damaged programs, into errors.
+func (m *VM) Call(name string, args ...int64) (result int64, err error) {
+	index, fn := m.program.Function(name)
+	if fn == nil {
+		return 0, fmt.Errorf("function '%s' not found", name)
+	}
+	if len(args) != len(fn.Params) {
+		return 0, fmt.Errorf("function '%s' expects %d arguments but got %d", name, len(fn.Params), len(args))
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			switch e := r.(type) {
+			case runtimeError:
+				err = e
+			case exitError:
+				err = e
+			case runtime.Error:
+				// A damaged program or a host function failing, the
+				// embedding service keeps running.
+				err = fmt.Errorf("internal error: %s", e)
+			default:
+				panic(r)
+			}
+			m.stack = m.stack[:0]
+			m.sp = MemorySize
+			m.depth = 0
+		}
+	}()
+	m.stack = append(m.stack, args...)
+	m.call(index)
+	if len(m.stack) > 0 {
+		result = m.pop()
+	}
+	return result, nil
+}
+
+func (m *VM) push(v int64) {
+	m.stack = append(m.stack, v)
+}
+func (m *VM) pop() int64 {
+	if len(m.stack) == 0 {
+		panic(runtimeError("stack underflow"))
+	}
+	v := m.stack[len(m.stack)-1]
+	m.stack = m.stack[:len(m.stack)-1]
+	return v
+}
+
+// bytes returns the memory at 'addr' checking that 'size' bytes are accessible.
+func (m *VM) bytes(addr int64, size int64) []byte {
+	if addr < bytecode.DataBase || size < 0 || addr+size > int64(len(m.memory)) {
+		panic(runtimeError(fmt.Sprintf("invalid memory access at %#x", addr)))
+	}
+	return m.memory[addr : addr+size]
+}
+
+func (m *VM) load(addr int64, size int64, signed bool) int64 {
+	b := m.bytes(addr, size)
+	switch size {
+	case 1:
+		if signed {
+			return int64(int8(b[0]))
+		}
+		return int64(b[0])
+	case 2:
+		if signed {
+			return int64(int16(binary.LittleEndian.Uint16(b)))
+		}
+		return int64(binary.LittleEndian.Uint16(b))
+	case 4:
+		if signed {
+			return int64(int32(binary.LittleEndian.Uint32(b)))
+		}
+		return int64(binary.LittleEndian.Uint32(b))
+	}
+	return int64(binary.LittleEndian.Uint64(b))
+}
+func (m *VM) store(addr int64, size int64, v int64) {
+	b := m.bytes(addr, size)
+	switch size {
+	case 1:
+		b[0] = byte(v)
+	case 2:
+		binary.LittleEndian.PutUint16(b, uint16(v))
+	case 4:
+		binary.LittleEndian.PutUint32(b, uint32(v))
+	default:
+		binary.LittleEndian.PutUint64(b, uint64(v))
+	}
+}
+
+func wrap(v int64, size int64) int64 {
+	switch size {
+	case 1:
+		return int64(int8(v))
+	case 2:
+		return int64(int16(v))
+	case 4:
+		return int64(int32(v))
+	}
+	return v
+}
+
+// CString reads the NUL terminated string at 'addr'.
+func (m *VM) CString(addr int64) string {
+	for end := addr; ; end++ {
+		if m.bytes(end, 1)[0] == 0 {
+			return string(m.memory[addr:end])
+		}
+	}
+}
+
+func boolInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// call runs a function whose arguments are on top of the stack.
+func (m *VM) call(index int) {
+	fn := m.program.Functions[index]
+	m.depth++
+	if m.depth > maxCallDepth {
+		panic(runtimeError("stack overflow"))
+	}
+	callerSp := m.sp
+	fp := m.sp - fn.FrameSize
+	if fp < bytecode.DataBase+int64(len(m.program.Data)) {
+		panic(runtimeError("stack overflow"))
+	}
+	m.sp = fp
+	for i := len(fn.Params) - 1; i >= 0; i-- {
+		m.store(fp+fn.Params[i].Offset, fn.Params[i].Size, m.pop())
+	}
+	code := fn.Code
+	for pc := 0; pc < len(code); {
+		op := bytecode.Op(code[pc])
+		pc++
+		var arg int64
+		if op.HasOperand() {
+			arg, pc = bytecode.Operand(code, pc)
+		}
+		switch op {
+		case bytecode.OP_CONST:
+			m.push(arg)
+		case bytecode.OP_LOCAL:
+			m.push(fp + arg)
+		case bytecode.OP_LOAD, bytecode.OP_LOADU:
+			m.push(m.load(m.pop(), arg, op == bytecode.OP_LOAD))
+		case bytecode.OP_STORE, bytecode.OP_TEE:
+			{
+				v := m.pop()
+				m.store(m.pop(), arg, v)
+				if op == bytecode.OP_TEE {
+					m.push(v)
+				}
+			}
+		case bytecode.OP_COPY:
+			{
+				src := m.bytes(m.pop(), arg)
+				copy(m.bytes(m.pop(), arg), src)
+			}
+		case bytecode.OP_NOT:
+			m.push(boolInt(m.pop() == 0))
+		case bytecode.OP_NEG:
+			m.push(-m.pop())
+		case bytecode.OP_WRAP:
+			m.push(wrap(m.pop(), arg))
+		case bytecode.OP_DROP:
+			m.pop()
+		case bytecode.OP_JMP:
+			pc = int(arg)
+		case bytecode.OP_JZ:
+			{
+				if m.pop() == 0 {
+					pc = int(arg)
+				}
+			}
+		case bytecode.OP_CALL:
+			m.call(int(arg))
+		case bytecode.OP_CALLX:
+			m.callHost(int(arg))
+		case bytecode.OP_RET, bytecode.OP_RETV:
+			{
+				m.sp = callerSp
+				m.depth--
+				return
+			}
+		default:
+			{
+				y := m.pop()
+				x := m.pop()
+				m.push(arithmetic(op, x, y))
+			}
+		}
+	}
+	panic(runtimeError(fmt.Sprintf("function '%s' ended without returning", fn.Name)))
+}
+
+func arithmetic(op bytecode.Op, x int64, y int64) int64 {
+	switch op {
+	case bytecode.OP_ADD:
+		return x + y
+	case bytecode.OP_SUB:
+		return x - y
+	case bytecode.OP_MUL:
+		return x * y
+	case bytecode.OP_DIV:
+		if y == 0 {
+			panic(runtimeError("division by zero"))
+		}
+		return x / y
+	case bytecode.OP_EQ:
+		return boolInt(x == y)
+	case bytecode.OP_NE:
+		return boolInt(x != y)
+	case bytecode.OP_LT:
+		return boolInt(x < y)
+	case bytecode.OP_LE:
+		return boolInt(x <= y)
+	case bytecode.OP_GT:
+		return boolInt(x > y)
+	case bytecode.OP_GE:
+		return boolInt(x >= y)
+	}
+	panic(runtimeError(fmt.Sprintf("invalid opcode %s", op)))
+}
+
+func (m *VM) callHost(index int) {
+	ext := m.program.Externs[index]
+	host := m.hosts[index]
+	if host == nil {
+		panic(runtimeError(fmt.Sprintf("external function '%s' is not available in the vm", ext.Name)))
+	}
+	args := make([]int64, len(ext.Strings))
+	for i := len(args) - 1; i >= 0; i-- {
+		args[i] = m.pop()
+	}
+	result := host(m, args)
+	if ext.Returns {
+		m.push(result)
+	}
+}
+
+// builtins mirror the interpreter's stand ins for common C functions.
+var builtins = map[string]HostFunc{
+	"puts": func(m *VM, args []int64) int64 {
+		n, _ := fmt.Fprintln(m.out, m.CString(args[0]))
+		return int64(n)
+	},
+	"putchar": func(m *VM, args []int64) int64 {
+		m.out.Write([]byte{byte(args[0])})
+		return args[0]
+	},
+	"printf": func(m *VM, args []int64) int64 {
+		values := make([]interp.Value, 0, len(args)-1)
+		strs := m.program.Externs[m.externIndex("printf")].Strings
+		for i, arg := range args[1:] {
+			if strs[i+1] {
+				values = append(values, m.CString(arg))
+			} else {
+				values = append(values, arg)
+			}
+		}
+		n, _ := fmt.Fprint(m.out, interp.CFormat(m.CString(args[0]), values))
+		return int64(n)
+	},
+	"exit": func(m *VM, args []int64) int64 {
+		panic(exitError(args[0]))
+	},
+}
+
+func (m *VM) externIndex(name string) int {
+	for i, ext := range m.program.Externs {
+		if ext.Name == name {
+			return i
+		}
+	}
+	return -1
+}
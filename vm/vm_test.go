@@ -0,0 +1,45 @@
+package vm_test
+
+import (
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/s0h1s2/bytecode"
+	"github.com/s0h1s2/compiler"
+	"github.com/s0h1s2/vm"
+)
+
+// Damaged bytecode files are rejected by Read or fail with an error, they never
+// take the embedding program down.
+func TestDamagedProgram(t *testing.T) {
+	for _, path := range []string{"../examples/structs.des", "../examples/branches.des"} {
+		s := compiler.NewSession()
+		if !s.ParseFile(path) {
+			t.Fatalf("%s: %v", path, s.Diagnostics.Errors())
+		}
+		data, ok := s.Emit(compiler.OUTPUT_BC)
+		if !ok {
+			t.Fatalf("%s: %v", path, s.Diagnostics.Errors())
+		}
+		random := rand.New(rand.NewSource(1))
+		for trial := 0; trial < 500; trial++ {
+			damaged := append([]byte(nil), data...)
+			for i := 0; i < 3; i++ {
+				damaged[random.Intn(len(damaged))] = byte(random.Intn(256))
+			}
+			program, err := bytecode.Read(damaged)
+			if err != nil {
+				continue
+			}
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						t.Fatalf("%s: trial %d panicked: %v", path, trial, r)
+					}
+				}()
+				vm.Run(program, io.Discard)
+			}()
+		}
+	}
+}
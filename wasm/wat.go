@@ -0,0 +1,666 @@
+package wasm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/s0h1s2/error"
+	"github.com/s0h1s2/resolver"
+	"github.com/s0h1s2/scope"
+	"github.com/s0h1s2/types"
+)
+
+const (
+	memoryPages = 2
+	pageSize    = 65536
+	dataBase    = 1024 // strings start here so that address 0 stays invalid
+	stackTop    = memoryPages * pageSize
+)
+
+type generator struct {
+	handler *error.DiagnosticBag
+	table   *resolver.Table
+	data    strings.Builder
+	dataEnd int
+	body    strings.Builder
+	indent  int
+	fun     *resolver.DeclFunction
+	// Scalars whose address is never taken live in wasm locals, everything
+	// else lives in the function's frame in linear memory.
+	addressed map[*scope.Object]bool
+	locals    map[*scope.Object]string
+	slots     map[*scope.Object]int
+	declared  []string
+	names     map[string]bool
+	frameSize int
+	loops     []int // labels of the loops around the statement, innermost last
+	labels    int
+}
+
+// Generate translates checked declarations into a WebAssembly text module.
+// Extern functions are imported from the "env" module and every function is exported.
+func Generate(decls []resolver.DeclNode, table *resolver.Table, handler *error.DiagnosticBag) string {
+	g := &generator{handler: handler, table: table, dataEnd: dataBase}
+	var imports, funcs strings.Builder
+	for _, decl := range decls {
+		switch node := decl.(type) {
+		case *resolver.DeclExternalFunction:
+			{
+				fmt.Fprintf(&imports, "  (import \"env\" \"%s\" (func $%s%s))\n", node.Name, node.Name, signature(node.Params, node.ReturnType, false))
+			}
+		case *resolver.DeclFunction:
+			{
+				g.genFunction(node)
+				funcs.WriteString(g.body.String())
+			}
+		}
+	}
+	var sb strings.Builder
+	sb.WriteString("(module\n")
+	sb.WriteString(imports.String())
+	fmt.Fprintf(&sb, "  (memory (export \"memory\") %d)\n", memoryPages)
+	fmt.Fprintf(&sb, "  (global $__stack_pointer (mut i32) (i32.const %d))\n", stackTop)
+	sb.WriteString(funcs.String())
+	sb.WriteString(g.data.String())
+	sb.WriteString(")\n")
+	return sb.String()
+}
+
+// valType maps a Dennis type to the wasm value holding it. Structs are handled through their address.
+func valType(typ *types.Type) string {
+	if typ.Kind == types.TYPE_INT && typ.Size == 8 {
+		return "i64"
+	}
+	return "i32"
+}
+
+func signature(params []resolver.Field, ret *types.Type, named bool) string {
+	var sb strings.Builder
+	for _, param := range params {
+		if named {
+			fmt.Fprintf(&sb, " (param $%s %s)", param.Name, valType(param.Type))
+		} else {
+			fmt.Fprintf(&sb, " (param %s)", valType(param.Type))
+		}
+	}
+	if ret.Kind != types.TYPE_VOID {
+		fmt.Fprintf(&sb, " (result %s)", valType(ret))
+	}
+	return sb.String()
+}
+
+func (g *generator) line(format string, args ...interface{}) {
+	g.body.WriteString(strings.Repeat("  ", g.indent))
+	fmt.Fprintf(&g.body, format, args...)
+	g.body.WriteByte('\n')
+}
+
+// local declares a wasm local with a name not used yet in the current function.
+func (g *generator) local(name string, typ string) string {
+	unique := name
+	for i := 1; g.names[unique]; i++ {
+		unique = fmt.Sprintf("%s.%d", name, i)
+	}
+	g.names[unique] = true
+	g.declared = append(g.declared, fmt.Sprintf("(local $%s %s)", unique, typ))
+	return unique
+}
+func (g *generator) allocSlot(typ *types.Type) int {
+	align := int(typ.Alignment)
+	if align == 0 {
+		align = 1
+	}
+	offset := (g.frameSize + align - 1) / align * align
+	g.frameSize = offset + int(typ.Size)
+	return offset
+}
+
+func (g *generator) genFunction(fun *resolver.DeclFunction) {
+	g.fun = fun
+	g.body.Reset()
+	g.locals = make(map[*scope.Object]string)
+	g.slots = make(map[*scope.Object]int)
+	// The frame pointer and result locals are named '.fp' and '.result',
+	// which no parameter or variable can be.
+	g.names = make(map[string]bool)
+	g.declared = nil
+	g.frameSize = 0
+	g.labels = 0
+	if fun.ReturnType.Kind == types.TYPE_STRUCT {
+		g.handler.ReportError(fun.GetPos(), "Returning struct '%s' by value is not supported yet", fun.ReturnType.TypeName)
+		return
+	}
+	g.addressed = map[*scope.Object]bool{}
+	addressTaken(fun.Body, g.addressed)
+
+	g.indent = 3
+	for _, param := range fun.Params {
+		if param.Type.Kind == types.TYPE_STRUCT {
+			g.handler.ReportError(fun.GetPos(), "Passing struct '%s' by value is not supported yet", param.Type.TypeName)
+			return
+		}
+		g.names[param.Name] = true
+		obj := fun.Scope.GetObj(param.Name)
+		if !g.addressed[obj] {
+			g.locals[obj] = param.Name
+			continue
+		}
+		g.slots[obj] = g.allocSlot(param.Type)
+		g.line("local.get $.fp")
+		g.line("local.get $%s", param.Name)
+		g.store(param.Type, g.slots[obj])
+	}
+	g.genStmt(fun.Body)
+	g.indent = 0
+	stmts := g.body.String()
+
+	g.body.Reset()
+	g.indent = 1
+	g.line("(func $%s (export \"%s\")%s", fun.Link, fun.Link, signature(fun.Params, fun.ReturnType, true))
+	g.indent++
+	g.line("(local $.fp i32)")
+	if fun.ReturnType.Kind != types.TYPE_VOID {
+		g.line("(local $.result %s)", valType(fun.ReturnType))
+	}
+	for _, decl := range g.declared {
+		g.line("%s", decl)
+	}
+	frameSize := (g.frameSize + 15) / 16 * 16
+	g.line("global.get $__stack_pointer")
+	if frameSize > 0 {
+		g.line("i32.const %d", frameSize)
+		g.line("i32.sub")
+		g.line("local.tee $.fp")
+		g.line("global.set $__stack_pointer")
+	} else {
+		g.line("local.set $.fp")
+	}
+	g.line("block $exit")
+	g.body.WriteString(stmts)
+	g.line("end")
+	if frameSize > 0 {
+		g.line("local.get $.fp")
+		g.line("i32.const %d", frameSize)
+		g.line("i32.add")
+		g.line("global.set $__stack_pointer")
+	}
+	if fun.ReturnType.Kind != types.TYPE_VOID {
+		g.line("local.get $.result")
+	}
+	g.indent--
+	g.line(")")
+}
+
+// addressTaken records variables whose address escapes through '&'.
+func addressTaken(node resolver.Node, addressed map[*scope.Object]bool) {
+	resolver.Inspect(node, func(node resolver.Node) bool {
+		unary, ok := node.(*resolver.ExprUnary)
+		if !ok || unary.Op != resolver.REFER {
+			return true
+		}
+		root := unary.Right
+		for {
+			field, ok := root.(*resolver.ExprField)
+			if !ok {
+				break
+			}
+			root = field.Expr
+		}
+		if ident, ok := root.(*resolver.ExprIdentifier); ok {
+			addressed[ident.Obj] = true
+		}
+		return true
+	})
+}
+
+func (g *generator) genStmt(stmt resolver.StmtNode) {
+	switch node := stmt.(type) {
+	case *resolver.StmtBlock:
+		{
+			for _, stmt := range node.Body {
+				g.genStmt(stmt)
+			}
+		}
+	case *resolver.StmtLet:
+		{
+			obj := node.Scope.GetObj(node.Name)
+			if node.Type.Kind != types.TYPE_STRUCT && !g.addressed[obj] {
+				g.locals[obj] = g.local(node.Name, valType(node.Type))
+				if node.Init != nil {
+					g.genValue(node.Init, node.Type)
+					g.line("local.set $%s", g.locals[obj])
+				}
+				return
+			}
+			g.slots[obj] = g.allocSlot(node.Type)
+			if node.Init == nil {
+				return
+			}
+			if node.Type.Kind == types.TYPE_STRUCT {
+				g.frameAddr(g.slots[obj])
+				g.genAddr(node.Init)
+				g.copyBytes(node.Type.Size)
+				return
+			}
+			g.line("local.get $.fp")
+			g.genValue(node.Init, node.Type)
+			g.store(node.Type, g.slots[obj])
+		}
+	case *resolver.StmtIf:
+		{
+			g.genExpr(node.Cond)
+			g.line("if")
+			g.indent++
+			g.genStmt(node.Then)
+			g.indent--
+			if node.Else != nil {
+				g.line("else")
+				g.indent++
+				g.genStmt(node.Else)
+				g.indent--
+			}
+			g.line("end")
+		}
+	case *resolver.StmtWhile:
+		{
+			g.genLoop(node.Cond, nil, node.Body)
+		}
+	case *resolver.StmtFor:
+		{
+			g.genStmt(node.Init)
+			var post resolver.StmtNode
+			if node.Post != nil {
+				post = &resolver.StmtExpr{Expr: node.Post, Scope: node.Scope, Pos: node.Pos}
+			}
+			g.genLoop(node.Cond, post, node.Body)
+		}
+	case *resolver.StmtBreak:
+		{
+			g.line("br $break.%d", g.loops[len(g.loops)-1])
+		}
+	case *resolver.StmtContinue:
+		{
+			g.line("br $continue.%d", g.loops[len(g.loops)-1])
+		}
+	case *resolver.StmtReturn:
+		{
+			if node.Result != nil {
+				g.genValue(node.Result, g.fun.ReturnType)
+				g.line("local.set $.result")
+			}
+			g.line("br $exit")
+		}
+	case *resolver.StmtExpr:
+		{
+			if assign, ok := node.Expr.(*resolver.ExprAssign); ok {
+				g.genAssign(assign, false)
+				return
+			}
+			typ := g.exprType(node.Expr)
+			if typ.Kind == types.TYPE_STRUCT {
+				g.genAddr(node.Expr)
+			} else {
+				g.genExpr(node.Expr)
+			}
+			if typ.Kind != types.TYPE_VOID {
+				g.line("drop")
+			}
+		}
+	}
+}
+
+// genLoop runs 'body' then 'post' while 'cond', which may be nil, holds. A break
+// leaves the outer block, a continue the inner one that ends before 'post'.
+func (g *generator) genLoop(cond resolver.ExprNode, post resolver.StmtNode, body resolver.StmtNode) {
+	label := g.labels
+	g.labels++
+	g.line("block $break.%d", label)
+	g.indent++
+	g.line("loop $loop.%d", label)
+	g.indent++
+	if cond != nil {
+		g.genExpr(cond)
+		g.line("i32.eqz")
+		g.line("br_if $break.%d", label)
+	}
+	g.line("block $continue.%d", label)
+	g.indent++
+	g.loops = append(g.loops, label)
+	g.genStmt(body)
+	g.loops = g.loops[:len(g.loops)-1]
+	g.indent--
+	g.line("end")
+	g.genStmt(post)
+	g.line("br $loop.%d", label)
+	g.indent--
+	g.line("end")
+	g.indent--
+	g.line("end")
+}
+
+var binaryOps = map[resolver.BinaryOperator]string{
+	resolver.ADD: "add",
+	resolver.SUB: "sub",
+	resolver.MUL: "mul",
+	resolver.DIV: "div_s",
+	resolver.EQ:  "eq",
+	resolver.NE:  "ne",
+	resolver.LT:  "lt_s",
+	resolver.LE:  "le_s",
+	resolver.GT:  "gt_s",
+	resolver.GE:  "ge_s",
+}
+
+// genExpr pushes the value of a scalar expression, or the address of a struct one.
+func (g *generator) genExpr(expr resolver.ExprNode) {
+	switch node := expr.(type) {
+	case *resolver.ExprInt:
+		{
+			val, err := strconv.ParseInt(node.Value, 10, 64)
+			if err != nil {
+				g.handler.ReportError(node.GetPos(), "Integer literal '%s' is out of range", node.Value)
+			}
+			g.line("%s.const %d", valType(g.exprType(node)), val)
+		}
+	case *resolver.ExprBool:
+		{
+			val := 0
+			if node.Value {
+				val = 1
+			}
+			g.line("i32.const %d", val)
+		}
+	case *resolver.ExprString:
+		{
+			g.line("i32.const %d", g.addString(node.Value))
+		}
+	case *resolver.ExprIdentifier:
+		{
+			if name, ok := g.locals[node.Obj]; ok {
+				g.line("local.get $%s", name)
+				return
+			}
+			g.genAddr(node)
+			g.load(g.exprType(node))
+		}
+	case *resolver.ExprField:
+		{
+			g.genAddr(node)
+			g.load(g.exprType(node))
+		}
+	case *resolver.ExprUnary:
+		{
+			switch node.Op {
+			case resolver.REFER:
+				{
+					g.genAddr(node.Right)
+				}
+			case resolver.DEREF:
+				{
+					g.genExpr(node.Right)
+					g.load(g.exprType(node))
+				}
+			case resolver.NOT:
+				{
+					g.genExpr(node.Right)
+					g.line("i32.eqz")
+				}
+			case resolver.MINUS:
+				{
+					typ := g.exprType(node)
+					g.line("%s.const 0", valType(typ))
+					g.genValue(node.Right, typ)
+					g.line("%s.sub", valType(typ))
+					g.wrap(typ)
+				}
+			}
+		}
+	case *resolver.ExprBinary:
+		{
+			operandType := node.Type
+			if resolver.IsCompare(node.Op) {
+				operandType = g.exprType(node.Left)
+				if right := g.exprType(node.Right); right.Size > operandType.Size {
+					operandType = right
+				}
+			}
+			g.genValue(node.Left, operandType)
+			g.genValue(node.Right, operandType)
+			g.line("%s.%s", valType(operandType), binaryOps[node.Op])
+			if !resolver.IsCompare(node.Op) {
+				g.wrap(node.Type)
+			}
+		}
+	case *resolver.ExprAssign:
+		{
+			g.genAssign(node, true)
+		}
+	case *resolver.ExprCall:
+		{
+			g.genCall(node)
+		}
+	case *resolver.ExprCompound:
+		{
+			g.genAddr(node)
+		}
+	default:
+		{
+			panic(fmt.Sprintf("Unhandled %T or unreachable", node))
+		}
+	}
+}
+
+// genValue pushes a scalar converted to the representation of 'to'.
+func (g *generator) genValue(expr resolver.ExprNode, to *types.Type) {
+	g.genExpr(expr)
+	from := g.exprType(expr)
+	if from.Kind != types.TYPE_INT || to.Kind != types.TYPE_INT || from.Size == to.Size {
+		return
+	}
+	switch {
+	case valType(from) == "i32" && valType(to) == "i64":
+		g.line("i64.extend_i32_s")
+	case valType(from) == "i64" && valType(to) == "i32":
+		g.line("i32.wrap_i64")
+	}
+	if to.Size < from.Size {
+		g.wrap(to)
+	}
+}
+
+// wrap sign extends the low bits of an i32 holding an integer narrower than 32 bits.
+func (g *generator) wrap(typ *types.Type) {
+	if typ.Kind != types.TYPE_INT {
+		return
+	}
+	switch typ.Size {
+	case 1:
+		g.line("i32.extend8_s")
+	case 2:
+		g.line("i32.extend16_s")
+	}
+}
+
+func (g *generator) genAssign(node *resolver.ExprAssign, result bool) {
+	typ := g.exprType(node.Left)
+	if ident, ok := node.Left.(*resolver.ExprIdentifier); ok {
+		if name, ok := g.locals[ident.Obj]; ok {
+			g.genValue(node.Right, typ)
+			if result {
+				g.line("local.tee $%s", name)
+			} else {
+				g.line("local.set $%s", name)
+			}
+			return
+		}
+	}
+	g.genAddr(node.Left)
+	var addr string
+	if result {
+		addr = g.local("addr", "i32")
+		g.line("local.tee $%s", addr)
+	}
+	if typ.Kind == types.TYPE_STRUCT {
+		g.genAddr(node.Right)
+		g.copyBytes(typ.Size)
+	} else {
+		g.genValue(node.Right, typ)
+		g.store(typ, 0)
+	}
+	if result {
+		g.line("local.get $%s", addr)
+		if typ.Kind != types.TYPE_STRUCT {
+			g.load(typ)
+		}
+	}
+}
+
+// genAddr pushes the address of an lvalue or struct value.
+func (g *generator) genAddr(expr resolver.ExprNode) {
+	switch node := expr.(type) {
+	case *resolver.ExprIdentifier:
+		{
+			g.frameAddr(g.slots[node.Obj])
+		}
+	case *resolver.ExprField:
+		{
+			base := g.exprType(node.Expr)
+			if base.Kind == types.TYPE_PTR {
+				g.genExpr(node.Expr)
+				base = base.Base
+			} else {
+				g.genAddr(node.Expr)
+			}
+			field := base.Field(node.Name)
+			if field.Offset != 0 {
+				g.line("i32.const %d", field.Offset)
+				g.line("i32.add")
+			}
+		}
+	case *resolver.ExprUnary:
+		{
+			if node.Op != resolver.DEREF {
+				g.handler.ReportError(node.GetPos(), "Expression is not addressable")
+				return
+			}
+			g.genExpr(node.Right)
+		}
+	case *resolver.ExprCompound:
+		{
+			slot := g.allocSlot(node.Type)
+			for _, init := range node.Fields {
+				field := node.Type.Field(init.Name)
+				if field.Type.Kind == types.TYPE_STRUCT {
+					g.frameAddr(slot + int(field.Offset))
+					g.genAddr(init.Expr)
+					g.copyBytes(field.Type.Size)
+					continue
+				}
+				g.line("local.get $.fp")
+				g.genValue(init.Expr, field.Type)
+				g.store(field.Type, slot+int(field.Offset))
+			}
+			g.frameAddr(slot)
+		}
+	default:
+		{
+			g.handler.ReportError(expr.GetPos(), "Expression is not addressable")
+		}
+	}
+}
+
+// frameAddr pushes the address of a frame slot.
+func (g *generator) frameAddr(offset int) {
+	g.line("local.get $.fp")
+	if offset != 0 {
+		g.line("i32.const %d", offset)
+		g.line("i32.add")
+	}
+}
+
+func (g *generator) genCall(node *resolver.ExprCall) {
+	fnObj := node.Obj
+	params := fnObj.Scope.QueryObjByKind(scope.PARAM)
+	for i, arg := range node.Args {
+		if typ := g.exprType(arg.Expr); typ.Kind == types.TYPE_STRUCT {
+			g.handler.ReportError(node.Pos, "Passing struct '%s' by value is not supported yet", typ.TypeName)
+			return
+		}
+		g.genValue(arg.Expr, params[i].Type)
+	}
+	if fnObj.Type.Kind == types.TYPE_STRUCT {
+		g.handler.ReportError(node.Pos, "Returning struct '%s' by value is not supported yet", fnObj.Type.TypeName)
+	}
+	g.line("call $%s", fnObj.Link)
+}
+
+func (g *generator) exprType(expr resolver.ExprNode) *types.Type {
+	return g.table.TypeOf(expr)
+}
+
+// load replaces the address on top of the stack with the value of type 'typ' stored there.
+func (g *generator) load(typ *types.Type) {
+	switch {
+	case typ.Kind == types.TYPE_STRUCT:
+		return
+	case typ.Kind == types.TYPE_BOOL:
+		g.line("i32.load8_u")
+	case typ.Kind != types.TYPE_INT:
+		// Pointers are 32 bits wide in the low half of their slot.
+		g.line("i32.load")
+	case typ.Size == 1:
+		g.line("i32.load8_s")
+	case typ.Size == 2:
+		g.line("i32.load16_s")
+	case typ.Size == 4:
+		g.line("i32.load")
+	default:
+		g.line("i64.load")
+	}
+}
+
+// store writes the value on top of the stack to the address below it plus 'offset'.
+func (g *generator) store(typ *types.Type, offset int) {
+	suffix := ""
+	if offset != 0 {
+		suffix = fmt.Sprintf(" offset=%d", offset)
+	}
+	switch {
+	case typ.Kind == types.TYPE_BOOL || typ.Size == 1:
+		g.line("i32.store8%s", suffix)
+	case typ.Kind == types.TYPE_INT && typ.Size == 2:
+		g.line("i32.store16%s", suffix)
+	case typ.Kind == types.TYPE_INT && typ.Size == 8:
+		g.line("i64.store%s", suffix)
+	default:
+		g.line("i32.store%s", suffix)
+	}
+}
+
+// copyBytes copies 'size' bytes to the first address on the stack from the second.
+func (g *generator) copyBytes(size uint64) {
+	g.line("i32.const %d", size)
+	g.line("memory.copy")
+}
+
+// addString places a NUL terminated string in the data section and returns its address.
+func (g *generator) addString(value string) int {
+	addr := g.dataEnd
+	fmt.Fprintf(&g.data, "  (data (i32.const %d) \"%s\\00\")\n", addr, escape(value))
+	g.dataEnd += len(value) + 1
+	return addr
+}
+
+func escape(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < ' ' || c > '~' || c == '"' || c == '\\' {
+			fmt.Fprintf(&sb, "\\%02x", c)
+			continue
+		}
+		sb.WriteByte(c)
+	}
+	return sb.String()
+}
@@ -0,0 +1,68 @@
+package wasm_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/s0h1s2/compiler"
+)
+
+func generate(t *testing.T, src string) string {
+	t.Helper()
+	s := compiler.NewSession()
+	if !s.Parse("test.des", []byte(src)) {
+		t.Fatalf("syntax errors %v", s.Diagnostics.Errors())
+	}
+	out, ok := s.Emit(compiler.OUTPUT_WAT)
+	if !ok {
+		t.Fatalf("errors %v", s.Diagnostics.Errors())
+	}
+	return string(out)
+}
+
+// Variables whose address is taken anywhere in the body live in the frame,
+// the others in wasm locals.
+func TestAddressTaken(t *testing.T) {
+	wat := generate(t, `
+fn main():i32 {
+  let a:i32 = 1;
+  let b:i32 = 2;
+  let c:i32 = 3;
+  let kept:i32 = 4;
+  while true {
+    if a == 1 {
+      let p:*i32 = &a;
+    } else {
+      for let i:i32 = 0; i < 1; i = i + 1 {
+        let q:*i32 = &b;
+      }
+    }
+    break;
+  }
+  let r:*i32 = &c;
+  return kept;
+}
+`)
+	for _, name := range []string{"a", "b", "c"} {
+		if strings.Contains(wat, "(local $"+name+" ") {
+			t.Errorf("'%s' has its address taken but lives in a local:\n%s", name, wat)
+		}
+	}
+	for _, name := range []string{"kept", "p", "q", "r", "i"} {
+		if !strings.Contains(wat, "(local $"+name+" ") {
+			t.Errorf("'%s' should live in a local:\n%s", name, wat)
+		}
+	}
+}
+
+// Parameters may be named like the locals the generator adds to every function.
+func TestParameterNames(t *testing.T) {
+	wat := generate(t, `
+fn f(fp:i32, result:i32):i32 {
+  return fp + result;
+}
+`)
+	if strings.Count(wat, "$fp i32") != 1 || strings.Count(wat, "$result i32") != 1 {
+		t.Errorf("parameters clash with the generated locals:\n%s", wat)
+	}
+}